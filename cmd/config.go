@@ -10,12 +10,85 @@ import (
 type Config struct {
 	Apps struct {
 		Rest struct {
-			Port       int `yaml:"port"`
+			Port           int      `yaml:"port"`
+			AllowedOrigins []string `yaml:"allowed_origins"`
+			SocketIO       struct {
+				Enabled        bool  `yaml:"enabled"`
+				AllowAnonymous bool  `yaml:"allow_anonymous"`
+				PingInterval   int64 `yaml:"ping_interval"`
+				PingTimeout    int64 `yaml:"ping_timeout"`
+			} `yaml:"socketio"`
+			Webhooks struct {
+				URL        string   `yaml:"url"`
+				Secret     string   `yaml:"secret"`
+				Events     []string `yaml:"events"`
+				QueueSize  int      `yaml:"queue_size"`
+				Workers    int      `yaml:"workers"`
+				MaxRetries int      `yaml:"max_retries"`
+				Timeout    int64    `yaml:"timeout"`
+				Debounce   int64    `yaml:"debounce"`
+			} `yaml:"webhooks"`
+			Admin struct {
+				Token string `yaml:"token"`
+			} `yaml:"admin"`
+			Events struct {
+				Token      string `yaml:"token"`
+				BufferSize int    `yaml:"buffer_size"`
+				KeepAlive  int64  `yaml:"keep_alive"`
+			} `yaml:"events"`
+			Shutdown struct {
+				Timeout        int64 `yaml:"timeout"`
+				ReconnectAfter int64 `yaml:"reconnect_after"`
+			} `yaml:"shutdown"`
 			Validation struct {
 				JWTHeaderName      string `yaml:"jwt_header_name"`
 				JWTValidationURL   string `yaml:"jwt_validation_url"`
 				BoardValidationURL string `yaml:"board_validation_url"`
+				Timeout            int64  `yaml:"timeout"`
+				Deadline           int64  `yaml:"deadline"`
+				MaxIdleConns       int    `yaml:"max_idle_conns"`
+				MaxRetries         int    `yaml:"max_retries"`
+				RetryBackoff       int64  `yaml:"retry_backoff"`
+				CircuitBreaker     struct {
+					Threshold int   `yaml:"threshold"`
+					Cooldown  int64 `yaml:"cooldown"`
+				} `yaml:"circuit_breaker"`
+				Fallback    string `yaml:"fallback"`
+				FallbackTTL int64  `yaml:"fallback_ttl"`
 			} `yaml:"validation"`
+			WebSocket struct {
+				MessageQueueSize      int   `yaml:"message_queue_size"`
+				MaxConcurrentHandlers int   `yaml:"max_concurrent_handlers"`
+				PingInterval          int64 `yaml:"ping_interval"`
+				PongWait              int64 `yaml:"pong_wait"`
+				WriteTimeout          int64 `yaml:"write_timeout"`
+				MaxMessageSize        int64 `yaml:"max_message_size"`
+				MaxSceneSize          int   `yaml:"max_scene_size"`
+				MaxElements           int   `yaml:"max_elements"`
+				MaxTextLength         int   `yaml:"max_text_length"`
+				MaxViolations         int   `yaml:"max_violations"`
+				ViolationWindow       int64 `yaml:"violation_window"`
+				RateLimits            map[string]struct {
+					Rate  float64 `yaml:"rate"`
+					Burst int     `yaml:"burst"`
+				} `yaml:"rate_limits"`
+				Compression struct {
+					Enabled   bool `yaml:"enabled"`
+					Level     int  `yaml:"level"`
+					Threshold int  `yaml:"threshold"`
+				} `yaml:"compression"`
+				KeepEncryptedScenes bool   `yaml:"keep_encrypted_scenes"`
+				MaxRoomUsers        int    `yaml:"max_room_users"`
+				RoomOverflow        string `yaml:"room_overflow"`
+				Resume              struct {
+					GracePeriod int64 `yaml:"grace_period"`
+					HistorySize int   `yaml:"history_size"`
+				} `yaml:"resume"`
+				Chat struct {
+					HistorySize      int `yaml:"history_size"`
+					MaxMessageLength int `yaml:"max_message_length"`
+				} `yaml:"chat"`
+			} `yaml:"websocket"`
 		} `yaml:"rest"`
 	} `yaml:"apps"`
 	Logging struct {
@@ -34,14 +107,33 @@ type Config struct {
 			RedisAddress  string `yaml:"redis_address"`
 			RedisPassword string `yaml:"redis_password"`
 			RedisDB       int    `yaml:"redis_db"`
+			MaxScenesSize int64  `yaml:"max_scenes_size"`
 		} `yaml:"rooms"`
+		Files struct {
+			Type         string `yaml:"type"`
+			Path         string `yaml:"path"`
+			MaxFileSize  int64  `yaml:"max_file_size"`
+			MaxBoardSize int64  `yaml:"max_board_size"`
+			MaxTotalSize int64  `yaml:"max_total_size"`
+			TTL          int64  `yaml:"ttl"`
+		} `yaml:"files"`
+		Snapshots struct {
+			Type string `yaml:"type"`
+			Path string `yaml:"path"`
+		} `yaml:"snapshots"`
 	} `yaml:"storage"`
 	Cache struct {
-		Type          string `yaml:"type"`
-		TTL           int64  `yaml:"ttl"`
-		RedisAddress  string `yaml:"redis_address"`
-		RedisPassword string `yaml:"redis_password"`
-		RedisDB       int    `yaml:"redis_db"`
+		Type               string `yaml:"type"`
+		TTL                int64  `yaml:"ttl"`
+		AccessTTL          int64  `yaml:"access_ttl"`
+		NegativeTTL        int64  `yaml:"negative_ttl"`
+		MaxEntries         int    `yaml:"max_entries"`
+		NegativeMaxEntries int    `yaml:"negative_max_entries"`
+		FallbackMaxEntries int    `yaml:"fallback_max_entries"`
+		CleanupInterval    int64  `yaml:"cleanup_interval"`
+		RedisAddress       string `yaml:"redis_address"`
+		RedisPassword      string `yaml:"redis_password"`
+		RedisDB            int    `yaml:"redis_db"`
 	} `yaml:"cache"`
 }
 
@@ -1,7 +1,37 @@
 package cache
 
 type Cache interface {
+	// Set stores the item that never expires
 	Set(key string, value interface{}) error
 	Get(key string) (interface{}, error)
+
+	// SetWithTTL stores the item that expires after the ttl in seconds
 	SetWithTTL(key string, value interface{}, ttl int64) error
+
+	// Delete removes the item, it is not an error if the item doesn't exist
+	Delete(key string) error
+
+	// Len returns the number of the items, including the expired ones not removed yet
+	Len() int
+
+	// Stats returns the counters of the cache
+	Stats() Stats
+
+	// Close releases the resources of the cache, e.g. stops its background workers
+	Close() error
+}
+
+// Stats is the counters of the cache since it was created.
+type Stats struct {
+	// Hits is the number of the lookups that found an item
+	Hits uint64
+
+	// Misses is the number of the lookups that found no item or an expired one
+	Misses uint64
+
+	// Evictions is the number of the items removed to keep the cache within its capacity
+	Evictions uint64
+
+	// Expirations is the number of the expired items removed
+	Expirations uint64
 }
@@ -0,0 +1,34 @@
+package inmemory
+
+import (
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCleanupInterval = 60
+	defaultMaxEntries      = 100000
+)
+
+type Config struct {
+	// MaxEntries is the capacity of the cache, the least recently used items are evicted beyond it
+	MaxEntries int
+
+	// CleanupInterval is the interval between the removals of the expired items in seconds
+	CleanupInterval int64
+
+	Logger *zap.Logger
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults.
+func (c Config) withDefaults() Config {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = defaultMaxEntries
+	}
+	if c.CleanupInterval <= 0 {
+		c.CleanupInterval = defaultCleanupInterval
+	}
+	if c.Logger == nil {
+		c.Logger = zap.NewNop()
+	}
+	return c
+}
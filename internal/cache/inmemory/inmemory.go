@@ -1,62 +1,213 @@
 package inmemory
 
 import (
+	"container/list"
+	"crypto/sha256"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/cache"
 )
 
 type Item struct {
-	Value      interface{}
+	Value interface{}
+
+	// Expiration is the time the item expires in nanoseconds, zero means it never expires
 	Expiration int64
+
+	// key is the hashed key of the item
+	key [sha256.Size]byte
 }
 
+// expired reports whether the item expired by the time in nanoseconds, the items without TTL never expire.
+func (i *Item) expired(now int64) bool {
+	return i.Expiration != 0 && i.Expiration < now
+}
+
+// Cache is an LRU cache bounded by the number of the items. The keys are stored hashed,
+// so the cache never keeps the tokens and the size of a key doesn't depend on the token.
 type Cache struct {
-	mu     sync.RWMutex
-	items  map[string]*Item
+	mu    sync.Mutex
+	items map[[sha256.Size]byte]*list.Element
+
+	// order is the list of the items, the most recently used first
+	order *list.List
+
+	// maxEntries is the capacity of the cache
+	maxEntries int
+
+	stats  cache.Stats
 	logger *zap.Logger
+
+	// done is closed to stop the janitor
+	done      chan struct{}
+	closeOnce *sync.Once
 }
 
-func NewCache(logger *zap.Logger) *Cache {
-	return &Cache{
-		items:  make(map[string]*Item),
-		logger: logger,
+// NewCache creates the cache and starts the janitor removing the expired items.
+func NewCache(config *Config) *Cache {
+	cfg := config.withDefaults()
+	c := &Cache{
+		items:      make(map[[sha256.Size]byte]*list.Element),
+		order:      list.New(),
+		maxEntries: cfg.MaxEntries,
+		logger:     cfg.Logger,
+		done:       make(chan struct{}),
+		closeOnce:  &sync.Once{},
 	}
+	go c.janitor(time.Duration(cfg.CleanupInterval) * time.Second)
+	return c
 }
 
+// Set stores the item without the expiration, so it never expires. It can still be evicted beyond the capacity.
 func (c *Cache) Set(key string, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = &Item{
-		Value: value,
-	}
-	c.logger.Debug("User added to cache", zap.String("key", key))
+	c.set(key, value, 0)
+	c.logger.Debug("User added to cache")
 	return nil
 }
 
+// SetWithTTL stores the item that expires after the ttl in seconds.
 func (c *Cache) SetWithTTL(key string, value interface{}, ttl int64) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = &Item{
-		Value:      value,
-		Expiration: time.Now().UnixNano() + ttl*int64(time.Second),
-	}
-	c.logger.Debug("User added to cache", zap.String("key", key))
+	c.set(key, value, time.Now().UnixNano()+ttl*int64(time.Second))
+	c.logger.Debug("User added to cache")
 	return nil
 }
 
 func (c *Cache) Get(key string) (interface{}, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	item, ok := c.items[key]
-	if !ok || item.Expiration < time.Now().UnixNano() {
-		c.logger.Debug("User not found in cache", zap.String("key", key))
+	element, ok := c.items[sha256.Sum256([]byte(key))]
+	if !ok {
+		c.stats.Misses++
+		c.logger.Debug("User not found in cache")
+		return nil, nil
+	}
+	item, ok := itemOf(element)
+	if !ok || item.expired(time.Now().UnixNano()) {
+		c.remove(element)
+		c.stats.Misses++
+		c.stats.Expirations++
+		c.logger.Debug("User not found in cache")
 		return nil, nil
 	}
 
+	c.order.MoveToFront(element)
+	c.stats.Hits++
 	return item.Value, nil
 }
+
+func (c *Cache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[sha256.Sum256([]byte(key))]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *Cache) Stats() cache.Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Close stops the janitor.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// set stores the item and evicts the least recently used items beyond the capacity, the mutex must be held.
+func (c *Cache) set(key string, value interface{}, expiration int64) {
+	hashed := sha256.Sum256([]byte(key))
+	if element, ok := c.items[hashed]; ok {
+		if item, ok := itemOf(element); ok {
+			item.Value = value
+			item.Expiration = expiration
+			c.order.MoveToFront(element)
+			return
+		}
+		c.remove(element)
+	}
+
+	c.items[hashed] = c.order.PushFront(&Item{
+		Value:      value,
+		Expiration: expiration,
+		key:        hashed,
+	})
+	for len(c.items) > c.maxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove deletes the item of the list element, the mutex must be held.
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	if item, ok := itemOf(element); ok {
+		delete(c.items, item.key)
+	}
+}
+
+// itemOf returns the item of the list element.
+func itemOf(element *list.Element) (*Item, bool) {
+	item, ok := element.Value.(*Item)
+	return item, ok
+}
+
+// janitor removes the expired items, so the tokens that are never used again don't stay in memory.
+func (c *Cache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Cache) deleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	removed := 0
+	for _, element := range c.items {
+		if item, ok := itemOf(element); !ok || item.expired(now) {
+			c.remove(element)
+			removed++
+		}
+	}
+	c.stats.Expirations += uint64(removed)
+	if removed > 0 {
+		c.logger.Debug(
+			"Expired items removed from cache",
+			zap.Int("count", removed),
+			zap.Int("left", len(c.items)),
+			zap.Uint64("hits", c.stats.Hits),
+			zap.Uint64("misses", c.stats.Misses),
+			zap.Uint64("evictions", c.stats.Evictions),
+		)
+	}
+}
@@ -0,0 +1,153 @@
+package inmemory
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testValue = "value"
+
+func TestCacheExpiration(t *testing.T) {
+	c := NewCache(&Config{Logger: zap.NewNop()})
+	defer func() { _ = c.Close() }()
+
+	_ = c.SetWithTTL("expired", testValue, -1)
+	_ = c.SetWithTTL("alive", testValue, 3600)
+	_ = c.Set("forever", testValue)
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{key: "expired", want: nil},
+		{key: "alive", want: testValue},
+		{key: "forever", want: testValue},
+		{key: "unknown", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got, _ := c.Get(tt.key); got != tt.want {
+				t.Errorf("Get(%s) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if got := c.Stats().Expirations; got != 1 {
+		t.Errorf("Expirations = %d, want 1", got)
+	}
+}
+
+func TestCacheDeleteExpired(t *testing.T) {
+	c := NewCache(&Config{Logger: zap.NewNop()})
+	defer func() { _ = c.Close() }()
+
+	_ = c.SetWithTTL("first", testValue, -1)
+	_ = c.SetWithTTL("second", testValue, -1)
+	_ = c.Set("forever", testValue)
+
+	// The janitor removes the expired items that are never read again
+	c.deleteExpired()
+	if got := c.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+	if got := c.Stats().Expirations; got != 2 {
+		t.Errorf("Expirations = %d, want 2", got)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		// calls is a list of "set:<key>" and "get:<key>" calls
+		calls         []string
+		wantKept      []string
+		wantEvicted   []string
+		wantEvictions uint64
+	}{
+		{
+			name:       "within the capacity",
+			maxEntries: 3,
+			calls:      []string{"set:a", "set:b", "set:c"},
+			wantKept:   []string{"a", "b", "c"},
+		},
+		{
+			name:          "least recently set is evicted",
+			maxEntries:    2,
+			calls:         []string{"set:a", "set:b", "set:c"},
+			wantKept:      []string{"b", "c"},
+			wantEvicted:   []string{"a"},
+			wantEvictions: 1,
+		},
+		{
+			name:          "read keeps the item",
+			maxEntries:    2,
+			calls:         []string{"set:a", "set:b", "get:a", "set:c"},
+			wantKept:      []string{"a", "c"},
+			wantEvicted:   []string{"b"},
+			wantEvictions: 1,
+		},
+		{
+			name:          "update keeps the item",
+			maxEntries:    2,
+			calls:         []string{"set:a", "set:b", "set:a", "set:c"},
+			wantKept:      []string{"a", "c"},
+			wantEvicted:   []string{"b"},
+			wantEvictions: 1,
+		},
+		{
+			name:          "several evictions",
+			maxEntries:    1,
+			calls:         []string{"set:a", "set:b", "set:c"},
+			wantKept:      []string{"c"},
+			wantEvicted:   []string{"a", "b"},
+			wantEvictions: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(&Config{MaxEntries: tt.maxEntries, Logger: zap.NewNop()})
+			defer func() { _ = c.Close() }()
+
+			for _, call := range tt.calls {
+				switch op, key := call[:3], call[4:]; op {
+				case "set":
+					_ = c.Set(key, key)
+				case "get":
+					_, _ = c.Get(key)
+				default:
+					t.Fatalf("unknown call %q", call)
+				}
+			}
+
+			if got := c.Stats().Evictions; got != tt.wantEvictions {
+				t.Errorf("Evictions = %d, want %d", got, tt.wantEvictions)
+			}
+			if got := c.Len(); got != len(tt.wantKept) {
+				t.Errorf("Len() = %d, want %d", got, len(tt.wantKept))
+			}
+			for _, key := range tt.wantKept {
+				if v, _ := c.Get(key); v != key {
+					t.Errorf("Get(%s) = %v, want %s", key, v, key)
+				}
+			}
+			for _, key := range tt.wantEvicted {
+				if v, _ := c.Get(key); v != nil {
+					t.Errorf("Get(%s) = %v, want nil", key, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheWithoutLogger(t *testing.T) {
+	c := NewCache(&Config{})
+	defer func() { _ = c.Close() }()
+
+	_ = c.Set("key", testValue)
+	if got, _ := c.Get("key"); got != testValue {
+		t.Errorf("Get(key) = %v, want %s", got, testValue)
+	}
+}
@@ -0,0 +1,33 @@
+package codec
+
+const (
+	JSONSubprotocol    = "excaliroom.json.v1"
+	MsgpackSubprotocol = "excaliroom.msgpack.v1"
+)
+
+// Codec encodes and decodes the websocket messages.
+type Codec interface {
+	// Name is the websocket subprotocol that selects the codec
+	Name() string
+
+	// MessageType is the websocket message type of the encoded messages
+	MessageType() int
+
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Subprotocols returns the subprotocols of the supported codecs in the order of preference.
+func Subprotocols() []string {
+	return []string{MsgpackSubprotocol, JSONSubprotocol}
+}
+
+// BySubprotocol returns the codec selected by the negotiated subprotocol, JSON is used by default.
+func BySubprotocol(subprotocol string) Codec {
+	switch subprotocol {
+	case MsgpackSubprotocol:
+		return NewMsgpack()
+	default:
+		return NewJSON()
+	}
+}
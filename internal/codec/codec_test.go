@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type testMessage struct {
+	Event    string   `json:"event"`
+	BoardID  string   `json:"board_id"`
+	Elements []string `json:"elements,omitempty"`
+	Count    int      `json:"count"`
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   Codec
+		msgType int
+	}{
+		{name: "json", codec: NewJSON(), msgType: websocket.TextMessage},
+		{name: "msgpack", codec: NewMsgpack(), msgType: websocket.BinaryMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.codec.MessageType(); got != tt.msgType {
+				t.Errorf("MessageType() = %d, want %d", got, tt.msgType)
+			}
+
+			want := testMessage{Event: "newData", BoardID: "board-1", Elements: []string{"a", "b"}, Count: 2}
+			data, err := tt.codec.Marshal(want)
+			if err != nil {
+				t.Fatalf("Marshal() unexpected error: %v", err)
+			}
+			var got testMessage
+			if err := tt.codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Unmarshal(Marshal()) = %+v, want %+v", got, want)
+			}
+
+			// The other codecs use the same field names, so a map decodes with the JSON names
+			var fields map[string]interface{}
+			if err := tt.codec.Unmarshal(data, &fields); err != nil {
+				t.Fatalf("Unmarshal() unexpected error: %v", err)
+			}
+			if fields["board_id"] != want.BoardID {
+				t.Errorf("board_id = %v, want %s", fields["board_id"], want.BoardID)
+			}
+
+			if err := tt.codec.Unmarshal([]byte{0xc1}, &got); err == nil {
+				t.Error("Unmarshal() of the invalid data expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestBySubprotocol(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		want        string
+	}{
+		{subprotocol: MsgpackSubprotocol, want: MsgpackSubprotocol},
+		{subprotocol: JSONSubprotocol, want: JSONSubprotocol},
+		{subprotocol: "", want: JSONSubprotocol},
+		{subprotocol: "unknown", want: JSONSubprotocol},
+	}
+
+	for _, tt := range tests {
+		if got := BySubprotocol(tt.subprotocol).Name(); got != tt.want {
+			t.Errorf("BySubprotocol(%q) = %s, want %s", tt.subprotocol, got, tt.want)
+		}
+	}
+}
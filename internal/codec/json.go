@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// JSON is the default codec, it encodes the messages as text JSON.
+type JSON struct{}
+
+func NewJSON() *JSON {
+	return &JSON{}
+}
+
+func (c *JSON) Name() string {
+	return JSONSubprotocol
+}
+
+func (c *JSON) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (c *JSON) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+	return data, nil
+}
+
+func (c *JSON) Unmarshal(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Msgpack encodes the messages as binary MessagePack. The field names are the same as in JSON.
+type Msgpack struct{}
+
+func NewMsgpack() *Msgpack {
+	return &Msgpack{}
+}
+
+func (c *Msgpack) Name() string {
+	return MsgpackSubprotocol
+}
+
+func (c *Msgpack) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (c *Msgpack) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to marshal msgpack: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Msgpack) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("failed to unmarshal msgpack: %w", err)
+	}
+	return nil
+}
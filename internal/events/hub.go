@@ -0,0 +1,118 @@
+package events
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const defaultBufferSize = 256
+
+// Hub fans the room events out to the subscribers.
+type Hub struct {
+	// subscribers is a set of the active subscriptions
+	subscribers map[*Subscription]bool
+
+	// bufferSize is the number of the events buffered per subscription
+	bufferSize int
+
+	// mtx guards subscribers and closed
+	mtx    *sync.RWMutex
+	closed bool
+
+	logger *zap.Logger
+}
+
+// Subscription receives the events of the hub.
+type Subscription struct {
+	// boardIDs is a set of the boards the events are received for, nil means all
+	boardIDs map[string]bool
+
+	events chan models.RoomEvent
+}
+
+func NewHub(bufferSize int, logger *zap.Logger) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &Hub{
+		subscribers: make(map[*Subscription]bool),
+		bufferSize:  bufferSize,
+		mtx:         &sync.RWMutex{},
+		logger:      logger,
+	}
+}
+
+// Subscribe creates the subscription for the events of the boards, all boards if the list is empty.
+// It returns nil if the hub is closed.
+func (h *Hub) Subscribe(boardIDs []string) *Subscription {
+	s := &Subscription{
+		events: make(chan models.RoomEvent, h.bufferSize),
+	}
+	if len(boardIDs) > 0 {
+		s.boardIDs = make(map[string]bool, len(boardIDs))
+		for _, id := range boardIDs {
+			s.boardIDs[id] = true
+		}
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.subscribers[s] = true
+	return s
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Notify sends the event to the subscribers. The subscribers that can't keep up are dropped,
+// so a slow consumer never blocks the websocket path.
+func (h *Hub) Notify(event models.RoomEvent) {
+	slow := make([]*Subscription, 0)
+
+	h.mtx.RLock()
+	for s := range h.subscribers {
+		if s.boardIDs != nil && !s.boardIDs[event.BoardID] {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mtx.RUnlock()
+
+	for _, s := range slow {
+		h.logger.Warn("Events subscriber is too slow, dropping it")
+		h.Unsubscribe(s)
+	}
+}
+
+// Close closes all the subscriptions, the new subscriptions are rejected.
+func (h *Hub) Close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Events returns the channel of the events, it is closed when the subscription ends.
+func (s *Subscription) Events() <-chan models.RoomEvent {
+	return s.events
+}
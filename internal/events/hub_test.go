@@ -0,0 +1,94 @@
+package events
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	testBoardID      = "board-1"
+	testOtherBoardID = "board-2"
+)
+
+func newTestEvent(boardID string) models.RoomEvent {
+	return models.NewRoomEvent(models.RoomEventRoomCreated, models.NewRoom(boardID), "")
+}
+
+// received drains the buffered events of the subscription.
+func received(s *Subscription) []models.RoomEvent {
+	var events []models.RoomEvent
+	for {
+		select {
+		case event, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestHubFiltersBoards(t *testing.T) {
+	h := NewHub(0, zap.NewNop())
+	all := h.Subscribe(nil)
+	board := h.Subscribe([]string{testBoardID})
+
+	h.Notify(newTestEvent(testBoardID))
+	h.Notify(newTestEvent(testOtherBoardID))
+
+	if got := len(received(all)); got != 2 {
+		t.Errorf("subscription of all boards got %d events, want 2", got)
+	}
+	events := received(board)
+	if len(events) != 1 || events[0].BoardID != testBoardID {
+		t.Errorf("subscription of %s got %v, want only its event", testBoardID, events)
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	h := NewHub(1, zap.NewNop())
+	s := h.Subscribe(nil)
+
+	// The second event doesn't fit into the buffer, so the subscription ends after the first one
+	h.Notify(newTestEvent(testBoardID))
+	h.Notify(newTestEvent(testBoardID))
+
+	if _, ok := <-s.Events(); !ok {
+		t.Fatal("first event not received")
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Error("slow subscription not closed")
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := NewHub(0, zap.NewNop())
+	s := h.Subscribe(nil)
+
+	h.Unsubscribe(s)
+	// Unsubscribing twice is a no-op
+	h.Unsubscribe(s)
+	h.Notify(newTestEvent(testBoardID))
+
+	if _, ok := <-s.Events(); ok {
+		t.Error("subscription not closed")
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(0, zap.NewNop())
+	s := h.Subscribe(nil)
+
+	h.Close()
+	if _, ok := <-s.Events(); ok {
+		t.Error("subscription not closed")
+	}
+	if h.Subscribe(nil) != nil {
+		t.Error("Subscribe() after Close() = subscription, want nil")
+	}
+}
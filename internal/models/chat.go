@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// ChatMessage is a text message sent to the users of a room.
+//
+//nolint:tagliatelle
+type ChatMessage struct {
+	// ID is the unique identifier of the message
+	ID string `json:"id"`
+
+	// UserID is the identifier of the author
+	UserID string `json:"user_id"`
+
+	// Text is the plain text of the message, empty in the encrypted rooms
+	Text string `json:"text,omitempty"`
+
+	// Data is the text encrypted by the clients in the encrypted rooms, relayed without parsing
+	Data *EncryptedText `json:"data,omitempty"`
+
+	// Timestamp is the time the message was sent in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// EncryptedText is the text of a chat message encrypted by the clients.
+type EncryptedText struct {
+	// Payload is the encrypted text
+	Payload []byte `json:"payload"`
+
+	// IV is the initialization vector used to encrypt the payload
+	IV []byte `json:"iv"`
+}
+
+// NewChatMessage creates a new chat message of the user.
+func NewChatMessage(userID, text string) ChatMessage {
+	return ChatMessage{
+		ID:        generateRandomID(),
+		UserID:    userID,
+		Text:      text,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// NewEncryptedChatMessage creates a new chat message of the user with the encrypted text.
+func NewEncryptedChatMessage(userID string, data *EncryptedText) ChatMessage {
+	return ChatMessage{
+		ID:        generateRandomID(),
+		UserID:    userID,
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Icerzack/excaliroom/internal/codec"
+)
+
+// Connection is a websocket connection that is safe to write to from multiple goroutines.
+type Connection struct {
+	*websocket.Conn
+
+	// Codec is the codec negotiated with the peer
+	Codec codec.Codec
+
+	// writeTimeout is the time allowed to write a message to the peer
+	writeTimeout time.Duration
+
+	// compressionThreshold is the minimum size of a message that is compressed,
+	// it has effect only if the compression was negotiated with the peer
+	compressionThreshold int
+
+	// compressed is true if permessage-deflate was negotiated with the peer and the peer accepts
+	// the compressed messages, it is guarded by mtx
+	compressed bool
+
+	// mtx serializes the writes to the connection
+	mtx *sync.Mutex
+
+	// protocolVersion is the protocol version agreed with the peer
+	protocolVersion int
+
+	// capabilities is a set of the protocol capabilities agreed with the peer
+	capabilities map[string]bool
+
+	// protocolMtx guards the protocol version and capabilities
+	protocolMtx *sync.RWMutex
+}
+
+// NewConnection wraps the websocket connection.
+func NewConnection(
+	conn *websocket.Conn,
+	c codec.Codec,
+	writeTimeout time.Duration,
+	compressionThreshold int,
+) *Connection {
+	return &Connection{
+		Conn:                 conn,
+		Codec:                c,
+		writeTimeout:         writeTimeout,
+		compressionThreshold: compressionThreshold,
+		mtx:                  &sync.Mutex{},
+		protocolVersion:      1,
+		capabilities:         make(map[string]bool),
+		protocolMtx:          &sync.RWMutex{},
+	}
+}
+
+// SetProtocol stores the protocol version and the capabilities agreed with the peer.
+func (c *Connection) SetProtocol(version int, capabilities []string) {
+	c.protocolMtx.Lock()
+	defer c.protocolMtx.Unlock()
+	c.protocolVersion = version
+	c.capabilities = make(map[string]bool, len(capabilities))
+	for _, capability := range capabilities {
+		c.capabilities[capability] = true
+	}
+}
+
+func (c *Connection) ProtocolVersion() int {
+	// Get the protocol version of the connection
+	c.protocolMtx.RLock()
+	defer c.protocolMtx.RUnlock()
+	return c.protocolVersion
+}
+
+func (c *Connection) HasCapability(capability string) bool {
+	// Check if the capability was agreed with the peer
+	c.protocolMtx.RLock()
+	defer c.protocolMtx.RUnlock()
+	return c.capabilities[capability]
+}
+
+// SetCompressed enables the compression of the messages written to the connection.
+func (c *Connection) SetCompressed(compressed bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.compressed = compressed
+}
+
+func (c *Connection) IsCompressed() bool {
+	// Check if the written messages are compressed
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.compressed
+}
+
+// Send encodes the message with the connection codec and writes it to the connection.
+func (c *Connection) Send(v interface{}) error {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return c.WriteMessage(c.Codec.MessageType(), data)
+}
+
+func (c *Connection) WriteMessage(messageType int, data []byte) error {
+	// Write message to the connection
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	c.Conn.EnableWriteCompression(c.compressed && len(data) >= c.compressionThreshold)
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// Frame is a websocket message written with WriteFrames.
+type Frame struct {
+	Type int
+	Data []byte
+}
+
+// WriteFrames writes the frames one after another without interleaving them with other writes.
+func (c *Connection) WriteFrames(frames ...Frame) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	for _, frame := range frames {
+		c.Conn.EnableWriteCompression(c.compressed && len(frame.Data) >= c.compressionThreshold)
+		if err := c.Conn.WriteMessage(frame.Type, frame.Data); err != nil {
+			return fmt.Errorf("failed to write frame: %w", err)
+		}
+	}
+	return nil
+}
+
+// WritePreparedMessage writes the message encoded once for all the recipients, size is the payload size.
+func (c *Connection) WritePreparedMessage(pm *websocket.PreparedMessage, size int) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	c.Conn.EnableWriteCompression(c.compressed && size >= c.compressionThreshold)
+	return c.Conn.WritePreparedMessage(pm)
+}
+
+// CloseWithCode sends the close frame with the code and the reason and closes the connection.
+func (c *Connection) CloseWithCode(code int, reason string) error {
+	message := websocket.FormatCloseMessage(code, reason)
+	_ = c.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.writeTimeout))
+	return c.Conn.Close()
+}
+
+func (c *Connection) Ping() error {
+	// Write ping control message to the connection
+	return c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout))
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Connections is a set of the open connections, it is used to drain them on shutdown.
+type Connections struct {
+	conns map[*Connection]struct{}
+
+	// empty is closed and replaced when the set becomes empty
+	empty chan struct{}
+
+	mtx *sync.Mutex
+}
+
+func NewConnections() *Connections {
+	empty := make(chan struct{})
+	close(empty)
+	return &Connections{
+		conns: make(map[*Connection]struct{}),
+		empty: empty,
+		mtx:   &sync.Mutex{},
+	}
+}
+
+func (c *Connections) Add(conn *Connection) {
+	// Add connection to the set
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.conns) == 0 {
+		c.empty = make(chan struct{})
+	}
+	c.conns[conn] = struct{}{}
+}
+
+func (c *Connections) Remove(conn *Connection) {
+	// Remove connection from the set
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.conns[conn]; !ok {
+		return
+	}
+	delete(c.conns, conn)
+	if len(c.conns) == 0 {
+		close(c.empty)
+	}
+}
+
+func (c *Connections) GetAll() []*Connection {
+	// Get all the connections
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	conns := make([]*Connection, 0, len(c.conns))
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Wait blocks until all the connections are removed or the context is done.
+func (c *Connections) Wait(ctx context.Context) error {
+	c.mtx.Lock()
+	empty := c.empty
+	c.mtx.Unlock()
+
+	select {
+	case <-empty:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for connections: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+)
+
+// Types of the room events.
+const (
+	RoomEventRoomCreated   = "roomCreated"
+	RoomEventRoomClosed    = "roomClosed"
+	RoomEventUserJoined    = "userJoined"
+	RoomEventUserLeft      = "userLeft"
+	RoomEventLeaderChanged = "leaderChanged"
+	RoomEventSceneUpdated  = "sceneUpdated"
+)
+
+// RoomEvent is a change of a room reported to the backend.
+//
+//nolint:tagliatelle
+type RoomEvent struct {
+	// ID is the unique identifier of the event
+	ID string `json:"id"`
+
+	// Type is the type of the event
+	Type string `json:"event"`
+
+	// BoardID is the unique identifier of the board of the room
+	BoardID string `json:"board_id"`
+
+	// UserID is the unique identifier of the user who caused the event, if any
+	UserID string `json:"user_id,omitempty"`
+
+	// UserIDs is the list of the users in the room after the event
+	UserIDs []string `json:"user_ids"`
+
+	// LeaderID is the unique identifier of the leader of the room after the event
+	LeaderID string `json:"leader_id"`
+
+	// Timestamp is the time of the event in milliseconds
+	Timestamp int64 `json:"timestamp"`
+
+	// SceneSize is the size of the updated scene in bytes, it is set only for the scene updates
+	SceneSize int `json:"scene_size,omitempty"`
+
+	// Encrypted is true if the updated scene is encrypted
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	// Elements is the JSON array of the updated elements, it is empty for the encrypted scenes
+	Elements string `json:"-"`
+
+	// AppState is the JSON object of the updated app state, it is empty for the encrypted scenes
+	AppState string `json:"-"`
+}
+
+// NewSceneUpdatedEvent creates the event of the room scene update caused by the user.
+func NewSceneUpdatedEvent(room *Room, userID, elements, appState string) RoomEvent {
+	event := NewRoomEvent(RoomEventSceneUpdated, room, userID)
+	event.SceneSize = len(elements) + len(appState)
+	event.Elements = elements
+	event.AppState = appState
+	return event
+}
+
+// NewEncryptedSceneUpdatedEvent creates the event of the encrypted room scene update caused by the user.
+func NewEncryptedSceneUpdatedEvent(room *Room, userID string, scene *EncryptedScene) RoomEvent {
+	event := NewRoomEvent(RoomEventSceneUpdated, room, userID)
+	event.SceneSize = len(scene.Payload)
+	event.Encrypted = true
+	return event
+}
+
+// NewRoomEvent creates the event of the room caused by the user.
+func NewRoomEvent(eventType string, room *Room, userID string) RoomEvent {
+	users := room.GetUsers()
+	userIDs := make([]string, 0, len(users))
+	for _, u := range users {
+		userIDs = append(userIDs, u.ID)
+	}
+	return RoomEvent{
+		ID:        generateRandomID(),
+		Type:      eventType,
+		BoardID:   room.BoardID,
+		UserID:    userID,
+		UserIDs:   userIDs,
+		LeaderID:  room.GetLeader(),
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
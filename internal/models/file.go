@@ -0,0 +1,22 @@
+package models
+
+// MaxFileIDLength is the maximum length of the file id, Excalidraw uses 40 characters long hashes.
+const MaxFileIDLength = 128
+
+// File is a binary file of a board, e.g. an image added to the scene.
+type File struct {
+	// ID is the unique identifier of the file within the board
+	ID string
+
+	// BoardID is the unique identifier of the board that the file belongs to
+	BoardID string
+
+	// MimeType is the media type of the file
+	MimeType string
+
+	// Data is the content of the file
+	Data []byte
+
+	// Created is the time when the file was uploaded in milliseconds since epoch
+	Created int64
+}
@@ -2,7 +2,14 @@ package models
 
 import (
 	"crypto/rand"
+	"errors"
 	"sync"
+	"time"
+)
+
+var (
+	ErrRoomLocked = errors.New("room is locked")
+	ErrRoomFull   = errors.New("room is full")
 )
 
 type Room struct {
@@ -24,21 +31,73 @@ type Room struct {
 	// AppState is a string that represents the app state of the board
 	AppState string
 
+	// Encrypted is true if the scene is encrypted by the clients and relayed without parsing
+	Encrypted bool
+
+	// EncryptedScene is the last encrypted scene of the board
+	EncryptedScene *EncryptedScene
+
+	// MaxUsers is the maximum number of the participants of the room, zero means the server limit
+	MaxUsers int
+
+	// Locked is true if the new users can't join the room
+	Locked bool
+
+	// spectators is a set of the ids of the users who joined the full room and can only watch
+	spectators map[string]bool
+
+	// chat is the list of the last chat messages of the room
+	chat []ChatMessage
+
+	// Revision is the number of the scene updates of the room
+	Revision int64
+
+	// history is the list of the last events sent to the users, without the scenes
+	history []HistoryEntry
+
+	// historySeq is the sequence number of the last event added to the history
+	historySeq int64
+
+	// historyTruncated is the last event dropped from the history, nil if none was dropped
+	historyTruncated *HistoryEntry
+
+	// lastActive is the time of the last scene update or membership change of the room
+	lastActive time.Time
+
+	// pins is the number of the operations using the room, the pinned rooms are not evicted
+	pins int
+
 	// mtx is a mutex
 	mtx *sync.RWMutex
 
 	RoomMutex *sync.Mutex
 }
 
+// HistoryEntry is an event sent to the users of the room.
+type HistoryEntry struct {
+	// Seq is the sequence number of the event in the room
+	Seq int64
+
+	// Revision is the revision of the room scene when the event was sent
+	Revision int64
+
+	// Message is the event
+	Message interface{}
+}
+
 // NewRoom creates a new room.
 func NewRoom(boardID string) *Room {
 	return &Room{
-		ID:        generateRandomID(),
-		BoardID:   boardID,
-		Users:     make([]*User, 0),
-		LeaderID:  "0",
-		mtx:       &sync.RWMutex{},
-		RoomMutex: &sync.Mutex{},
+		ID:         generateRandomID(),
+		BoardID:    boardID,
+		Users:      make([]*User, 0),
+		LeaderID:   "0",
+		spectators: make(map[string]bool),
+		chat:       make([]ChatMessage, 0),
+		history:    make([]HistoryEntry, 0),
+		lastActive: time.Now(),
+		mtx:        &sync.RWMutex{},
+		RoomMutex:  &sync.Mutex{},
 	}
 }
 
@@ -47,6 +106,7 @@ func (r *Room) AddUser(newUser *User) {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 	r.Users = append(r.Users, newUser)
+	r.lastActive = time.Now()
 }
 
 func (r *Room) RemoveUser(userID string) {
@@ -55,10 +115,130 @@ func (r *Room) RemoveUser(userID string) {
 	defer r.mtx.Unlock()
 	for i, u := range r.Users {
 		if u.ID == userID {
-			r.Users = append(r.Users[:i], r.Users[i+1:]...)
+			// The slice returned by GetUsers is shared, so the users are copied instead of shifted in place
+			users := make([]*User, 0, len(r.Users)-1)
+			users = append(users, r.Users[:i]...)
+			r.Users = append(users, r.Users[i+1:]...)
 			break
 		}
 	}
+	delete(r.spectators, userID)
+	r.lastActive = time.Now()
+}
+
+// Join adds the user to the room unless it is locked or has limit participants.
+// If spectate is true, the users beyond the limit join as spectators. It returns whether the user is a spectator.
+func (r *Room) Join(newUser *User, limit int, spectate bool) (bool, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.Locked {
+		return false, ErrRoomLocked
+	}
+	spectator := limit > 0 && len(r.Users)-len(r.spectators) >= limit
+	if spectator && !spectate {
+		return false, ErrRoomFull
+	}
+	newUser.Color = r.nextColor()
+	r.Users = append(r.Users, newUser)
+	r.lastActive = time.Now()
+	if spectator {
+		r.spectators[newUser.ID] = true
+	}
+	return spectator, nil
+}
+
+// PromoteSpectator makes the spectator who joined first a participant if the room has less than limit participants.
+// It returns the id of the promoted user or an empty string.
+func (r *Room) PromoteSpectator(limit int) string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.spectators) == 0 || (limit > 0 && len(r.Users)-len(r.spectators) >= limit) {
+		return ""
+	}
+	for _, u := range r.Users {
+		if r.spectators[u.ID] {
+			delete(r.spectators, u.ID)
+			return u.ID
+		}
+	}
+	return ""
+}
+
+func (r *Room) IsSpectator(userID string) bool {
+	// Check if the user is a spectator of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.spectators[userID]
+}
+
+// GetMembers returns the ids of the participants and the spectators of the room.
+func (r *Room) GetMembers() ([]string, []string) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	userIDs := make([]string, 0, len(r.Users))
+	spectatorIDs := make([]string, 0, len(r.spectators))
+	for _, u := range r.Users {
+		if r.spectators[u.ID] {
+			spectatorIDs = append(spectatorIDs, u.ID)
+		} else {
+			userIDs = append(userIDs, u.ID)
+		}
+	}
+	return userIDs, spectatorIDs
+}
+
+// GetParticipants returns the profiles of the users of the room in the order they joined.
+func (r *Room) GetParticipants() []Participant {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	participants := make([]Participant, 0, len(r.Users))
+	for _, u := range r.Users {
+		participants = append(participants, u.Participant(r.spectators[u.ID]))
+	}
+	return participants
+}
+
+// nextColor returns the first collaborator color not used in the room, the mutex must be held.
+// The colors repeat once all of them are used.
+func (r *Room) nextColor() string {
+	used := make(map[string]bool, len(r.Users))
+	for _, u := range r.Users {
+		used[u.Color] = true
+	}
+	for i := 0; i < CollaboratorColorCount; i++ {
+		if color := CollaboratorColor(i); !used[color] {
+			return color
+		}
+	}
+	return CollaboratorColor(len(r.Users))
+}
+
+func (r *Room) SetMaxUsers(maxUsers int) {
+	// Set maximum number of the participants of the room
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.MaxUsers = maxUsers
+}
+
+func (r *Room) GetMaxUsers() int {
+	// Get maximum number of the participants of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.MaxUsers
+}
+
+func (r *Room) SetLocked(locked bool) {
+	// Set lock of the room
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.Locked = locked
+}
+
+func (r *Room) IsLocked() bool {
+	// Check if the room is locked
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.Locked
 }
 
 func (r *Room) GetUsers() []*User {
@@ -75,6 +255,13 @@ func (r *Room) SetLeader(leaderID string) {
 	r.LeaderID = leaderID
 }
 
+func (r *Room) GetLeader() string {
+	// Get leader of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.LeaderID
+}
+
 func (r *Room) SetElements(elements string) {
 	// Set elements of the room
 	r.mtx.Lock()
@@ -103,6 +290,129 @@ func (r *Room) GetAppState() string {
 	return r.AppState
 }
 
+func (r *Room) SetEncryptedScene(scene *EncryptedScene) {
+	// Set encrypted scene of the room
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.EncryptedScene = scene
+}
+
+func (r *Room) GetEncryptedScene() *EncryptedScene {
+	// Get encrypted scene of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.EncryptedScene
+}
+
+func (r *Room) NextRevision() int64 {
+	// Increment the revision of the room scene
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.Revision++
+	r.lastActive = time.Now()
+	return r.Revision
+}
+
+func (r *Room) GetRevision() int64 {
+	// Get revision of the room scene
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.Revision
+}
+
+// AddHistory appends the event to the history, dropping the oldest events beyond the limit.
+func (r *Room) AddHistory(message interface{}, limit int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.historySeq++
+	r.history = append(r.history, HistoryEntry{Seq: r.historySeq, Revision: r.Revision, Message: message})
+	if len(r.history) > limit {
+		dropped := len(r.history) - limit
+		last := r.history[dropped-1]
+		r.historyTruncated = &last
+		r.history = append(r.history[:0:0], r.history[dropped:]...)
+	}
+}
+
+func (r *Room) GetHistorySeq() int64 {
+	// Get sequence number of the last event of the history
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.historySeq
+}
+
+// GetHistory returns the events sent after the sequence number since the scene revision.
+// It returns false if some of them were already dropped from the history.
+func (r *Room) GetHistory(revision, seq int64) ([]interface{}, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	messages := make([]interface{}, 0)
+	for _, entry := range r.history {
+		// The events sent at the same revision could be missed after the scene was received
+		if entry.Revision >= revision && entry.Seq > seq {
+			messages = append(messages, entry.Message)
+		}
+	}
+	truncated := r.historyTruncated != nil && r.historyTruncated.Revision >= revision && r.historyTruncated.Seq > seq
+	return messages, !truncated
+}
+
+// SceneSize returns the size of the scene kept by the room in bytes.
+func (r *Room) SceneSize() int {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	size := len(r.Elements) + len(r.AppState)
+	if r.EncryptedScene != nil {
+		size += len(r.EncryptedScene.Payload) + len(r.EncryptedScene.IV)
+	}
+	return size
+}
+
+func (r *Room) GetLastActive() time.Time {
+	// Get time of the last activity of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.lastActive
+}
+
+func (r *Room) Pin() {
+	// Protect the room from the eviction
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pins++
+}
+
+func (r *Room) Unpin() {
+	// Release the room pinned by Pin
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pins--
+}
+
+// IsIdle reports whether the room has no users and is not pinned, so it can be evicted.
+func (r *Room) IsIdle() bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return len(r.Users) == 0 && r.pins == 0
+}
+
+// AddChatMessage appends the message to the chat history, dropping the oldest messages beyond the limit.
+func (r *Room) AddChatMessage(message ChatMessage, limit int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.chat = append(r.chat, message)
+	if len(r.chat) > limit {
+		r.chat = append(r.chat[:0:0], r.chat[len(r.chat)-limit:]...)
+	}
+}
+
+func (r *Room) GetChatMessages() []ChatMessage {
+	// Get chat history of the room
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return append(make([]ChatMessage, 0, len(r.chat)), r.chat...)
+}
+
 // generateRandomID generates a random ID for the room.
 func generateRandomID() string {
 	const idLength = 16
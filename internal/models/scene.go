@@ -0,0 +1,10 @@
+package models
+
+// EncryptedScene is a scene encrypted by the clients with the room key.
+type EncryptedScene struct {
+	// Payload is the encrypted scene
+	Payload []byte
+
+	// IV is the initialization vector used to encrypt the payload
+	IV []byte
+}
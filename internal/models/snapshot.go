@@ -0,0 +1,34 @@
+package models
+
+// Snapshot is the scene of a room saved on shutdown or on eviction and restored later.
+//
+//nolint:tagliatelle
+type Snapshot struct {
+	// BoardID is the unique identifier of the board that the room belongs to
+	BoardID string `json:"board_id"`
+
+	// Elements is a string that represents the elements of the board
+	Elements string `json:"elements,omitempty"`
+
+	// AppState is a string that represents the app state of the board
+	AppState string `json:"app_state,omitempty"`
+
+	// Encrypted is true if the room relays the encrypted scenes
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	// EncryptedScene is the last encrypted scene of the board
+	EncryptedScene *EncryptedScene `json:"encrypted_scene,omitempty"`
+
+	// Locked is true if the new users can't join the room
+	Locked bool `json:"locked,omitempty"`
+
+	// MaxUsers is the maximum number of the participants of the room, zero means the server limit
+	MaxUsers int `json:"max_users,omitempty"`
+
+	// Evicted is true if the room was evicted over the memory budget, such snapshots are restored
+	// only when a user joins the board
+	Evicted bool `json:"evicted,omitempty"`
+
+	// Created is the time the snapshot was taken in milliseconds
+	Created int64 `json:"created"`
+}
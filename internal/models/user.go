@@ -1,6 +1,28 @@
 package models
 
-import "github.com/gorilla/websocket"
+// CollaboratorColorCount is the number of the colors in the palette of the users of a room.
+const CollaboratorColorCount = 10
+
+// CollaboratorColor returns the color of the palette assigned to the users of a room, the palette repeats.
+func CollaboratorColor(i int) string {
+	palette := [CollaboratorColorCount]string{
+		"#e03131",
+		"#1971c2",
+		"#2f9e44",
+		"#f08c00",
+		"#9c36b5",
+		"#0c8599",
+		"#e8590c",
+		"#6741d9",
+		"#c2255c",
+		"#66a80f",
+	}
+	i %= CollaboratorColorCount
+	if i < 0 {
+		i += CollaboratorColorCount
+	}
+	return palette[i]
+}
 
 // User is a struct that represents a user.
 type User struct {
@@ -10,6 +32,46 @@ type User struct {
 	// RoomID is the unique identifier of the room that the user belongs to.
 	RoomID string
 
-	// Conn is the connection of the user.
-	Conn *websocket.Conn
+	// Name is the display name of the user, it can be empty.
+	Name string
+
+	// AvatarURL is the URL of the avatar of the user, it can be empty.
+	AvatarURL string
+
+	// Color is the collaborator color assigned to the user in the room.
+	Color string
+
+	// Conn is the connection of the user, nil while the user waits for the session resume.
+	Conn *Connection
+}
+
+// Participant is the public profile of a user of a room.
+//
+//nolint:tagliatelle
+type Participant struct {
+	// ID is the unique identifier of the user
+	ID string `json:"id"`
+
+	// Name is the display name of the user
+	Name string `json:"name,omitempty"`
+
+	// AvatarURL is the URL of the avatar of the user
+	AvatarURL string `json:"avatar_url,omitempty"`
+
+	// Color is the collaborator color of the user
+	Color string `json:"color"`
+
+	// Spectator is true if the user only watches the room
+	Spectator bool `json:"spectator,omitempty"`
+}
+
+// Participant returns the public profile of the user.
+func (u *User) Participant(spectator bool) Participant {
+	return Participant{
+		ID:        u.ID,
+		Name:      u.Name,
+		AvatarURL: u.AvatarURL,
+		Color:     u.Color,
+		Spectator: spectator,
+	}
 }
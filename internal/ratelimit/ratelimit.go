@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limit is a token bucket limit for a single event type.
+type Limit struct {
+	// Rate is the number of events allowed per second, zero or less disables the limit
+	Rate float64
+
+	// Burst is the maximum number of events allowed at once
+	Burst int
+}
+
+// Limiter tracks the rate limits and the violations of a single connection.
+// It is used only by the goroutine processing the connection messages.
+type Limiter struct {
+	// limiters is a map of token buckets by event type
+	limiters map[string]*rate.Limiter
+
+	// violations is the times of the limit violations within the window, the oldest first
+	violations []time.Time
+
+	// maxViolations is the number of violations within the window after which the connection is dropped
+	maxViolations int
+
+	// window is the time the violations are counted for
+	window time.Duration
+
+	// now returns the current time
+	now func() time.Time
+}
+
+func NewLimiter(limits map[string]Limit, maxViolations int, window time.Duration) *Limiter {
+	limiters := make(map[string]*rate.Limiter, len(limits))
+	for event, limit := range limits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		limiters[event] = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+	}
+	return &Limiter{
+		limiters:      limiters,
+		maxViolations: maxViolations,
+		window:        window,
+		now:           time.Now,
+	}
+}
+
+// Allow reports whether the event may be processed now.
+func (l *Limiter) Allow(event string) bool {
+	limiter, ok := l.limiters[event]
+	if !ok {
+		return true
+	}
+	return limiter.Allow()
+}
+
+// Violate records a violation and reports whether the connection must be dropped. The violations older
+// than the window are forgotten, so a connection hitting the limits now and then is never dropped.
+func (l *Limiter) Violate() bool {
+	now := l.now()
+	kept := 0
+	for _, t := range l.violations {
+		if now.Sub(t) < l.window {
+			l.violations[kept] = t
+			kept++
+		}
+	}
+	l.violations = append(l.violations[:kept], now)
+	return len(l.violations) > l.maxViolations
+}
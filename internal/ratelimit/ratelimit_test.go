@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	const (
+		limited  = "limited"
+		disabled = "disabled"
+	)
+	limiter := NewLimiter(map[string]Limit{
+		limited:  {Rate: 1, Burst: 2},
+		disabled: {Rate: 0, Burst: 1},
+	}, 10, time.Minute)
+
+	tests := []struct {
+		event string
+		want  bool
+	}{
+		{event: limited, want: true},
+		{event: limited, want: true},
+		{event: limited, want: false},
+		{event: disabled, want: true},
+		{event: disabled, want: true},
+		{event: "unknown", want: true},
+	}
+	for i, tt := range tests {
+		if got := limiter.Allow(tt.event); got != tt.want {
+			t.Errorf("call %d: Allow(%s) = %v, want %v", i, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterViolate(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxViolations int
+		window        time.Duration
+		// offsets are the times of the violations since the start
+		offsets []time.Duration
+		want    []bool
+	}{
+		{
+			name:          "within the limit",
+			maxViolations: 2,
+			window:        time.Minute,
+			offsets:       []time.Duration{0, time.Second},
+			want:          []bool{false, false},
+		},
+		{
+			name:          "over the limit within the window",
+			maxViolations: 2,
+			window:        time.Minute,
+			offsets:       []time.Duration{0, time.Second, 2 * time.Second},
+			want:          []bool{false, false, true},
+		},
+		{
+			name:          "old violations are forgotten",
+			maxViolations: 2,
+			window:        time.Minute,
+			offsets:       []time.Duration{0, time.Second, time.Minute + time.Second},
+			want:          []bool{false, false, false},
+		},
+		{
+			name:          "sliding window",
+			maxViolations: 2,
+			window:        time.Minute,
+			offsets:       []time.Duration{0, 30 * time.Second, 61 * time.Second, 62 * time.Second},
+			want:          []bool{false, false, false, true},
+		},
+		{
+			name:          "occasional violations",
+			maxViolations: 1,
+			window:        time.Minute,
+			offsets:       []time.Duration{0, 2 * time.Minute, 4 * time.Minute, 6 * time.Minute},
+			want:          []bool{false, false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			var now time.Time
+			limiter := NewLimiter(nil, tt.maxViolations, tt.window)
+			limiter.now = func() time.Time { return now }
+
+			for i, offset := range tt.offsets {
+				now = start.Add(offset)
+				if got := limiter.Violate(); got != tt.want[i] {
+					t.Errorf("violation %d: Violate() = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
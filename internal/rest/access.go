@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxInvalidateRequestSize is the maximum size of the access invalidation request body in bytes.
+const maxInvalidateRequestSize = 4 << 10
+
+// AccessInvalidator drops the cached access of the users to the boards.
+type AccessInvalidator interface {
+	InvalidateAccess(boardID, userID string, disconnect bool) int
+}
+
+// AccessInvalidators invalidates the access with all the invalidators, e.g. of the websocket and Socket.IO endpoints.
+type AccessInvalidators []AccessInvalidator
+
+func (a AccessInvalidators) InvalidateAccess(boardID, userID string, disconnect bool) int {
+	disconnected := 0
+	for _, invalidator := range a {
+		disconnected += invalidator.InvalidateAccess(boardID, userID, disconnect)
+	}
+	return disconnected
+}
+
+// authorize checks the bearer token of the request, the unauthorized request is rejected with 401.
+func authorize(w http.ResponseWriter, r *http.Request, expected string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// accessHandler serves the /access endpoints called by the backend.
+type accessHandler struct {
+	invalidator AccessInvalidator
+	token       string
+	logger      *zap.Logger
+}
+
+//nolint:tagliatelle
+type invalidateAccessRequest struct {
+	BoardID    string `json:"board_id"`
+	UserID     string `json:"user_id"`
+	Disconnect bool   `json:"disconnect"`
+}
+
+type invalidateAccessResponse struct {
+	Disconnected int `json:"disconnected"`
+}
+
+func newAccessHandler(invalidator AccessInvalidator, token string, logger *zap.Logger) *accessHandler {
+	return &accessHandler{
+		invalidator: invalidator,
+		token:       token,
+		logger:      logger,
+	}
+}
+
+// invalidate drops the cached access of the user to the board, or of all its users if user_id is empty.
+func (h *accessHandler) invalidate(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.token) {
+		return
+	}
+
+	var request invalidateAccessRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxInvalidateRequestSize)).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.BoardID == "" {
+		http.Error(w, "board_id is required", http.StatusBadRequest)
+		return
+	}
+
+	disconnected := h.invalidator.InvalidateAccess(request.BoardID, request.UserID, request.Disconnect)
+	h.logger.Debug(
+		"Access invalidation requested",
+		zap.String("boardID", request.BoardID),
+		zap.String("userID", request.UserID),
+		zap.Int("disconnected", disconnected),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(invalidateAccessResponse{Disconnected: disconnected})
+}
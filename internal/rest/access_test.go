@@ -0,0 +1,121 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// testInvalidateBody is the request invalidating the access of all the users to the board.
+const testInvalidateBody = `{"board_id":"` + testBoardID + `"}`
+
+// invalidation is the call of the access invalidator.
+type invalidation struct {
+	boardID    string
+	userID     string
+	disconnect bool
+}
+
+// testInvalidator records the invalidations and disconnects a single user for each.
+type testInvalidator struct {
+	invalidations []invalidation
+}
+
+func (i *testInvalidator) InvalidateAccess(boardID, userID string, disconnect bool) int {
+	i.invalidations = append(i.invalidations, invalidation{boardID: boardID, userID: userID, disconnect: disconnect})
+	if !disconnect {
+		return 0
+	}
+	return 1
+}
+
+func TestInvalidateAccess(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		want             invalidation
+		wantDisconnected int
+	}{
+		{
+			name: "board",
+			body: testInvalidateBody,
+			want: invalidation{boardID: testBoardID},
+		},
+		{
+			name:             "user disconnected",
+			body:             `{"board_id":"` + testBoardID + `","user_id":"` + testUserID + `","disconnect":true}`,
+			want:             invalidation{boardID: testBoardID, userID: testUserID, disconnect: true},
+			wantDisconnected: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The invalidation reaches the websocket and the Socket.IO endpoints
+			wsInvalidator, socketIOInvalidator := &testInvalidator{}, &testInvalidator{}
+			h := newAccessHandler(AccessInvalidators{wsInvalidator, socketIOInvalidator}, testAdminToken, zap.NewNop())
+
+			r := httptest.NewRequest(http.MethodPost, "/access/invalidate", strings.NewReader(tt.body))
+			setAdminToken(r, testAdminToken)
+			w := httptest.NewRecorder()
+			h.invalidate(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var response invalidateAccessResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			if response.Disconnected != tt.wantDisconnected {
+				t.Errorf("disconnected = %d, want %d", response.Disconnected, tt.wantDisconnected)
+			}
+			for _, invalidator := range []*testInvalidator{wsInvalidator, socketIOInvalidator} {
+				if len(invalidator.invalidations) != 1 || invalidator.invalidations[0] != tt.want {
+					t.Errorf("invalidations = %+v, want [%+v]", invalidator.invalidations, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidateAccessRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{name: "no token", body: testInvalidateBody, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "wrong", body: testInvalidateBody, wantStatus: http.StatusUnauthorized},
+		{name: "invalid body", token: testAdminToken, body: "{", wantStatus: http.StatusBadRequest},
+		{
+			name:       "no board",
+			token:      testAdminToken,
+			body:       `{"user_id":"` + testUserID + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalidator := &testInvalidator{}
+			h := newAccessHandler(invalidator, testAdminToken, zap.NewNop())
+
+			r := httptest.NewRequest(http.MethodPost, "/access/invalidate", strings.NewReader(tt.body))
+			setAdminToken(r, tt.token)
+			w := httptest.NewRecorder()
+			h.invalidate(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(invalidator.invalidations) != 0 {
+				t.Errorf("invalidations = %+v, want none", invalidator.invalidations)
+			}
+		})
+	}
+}
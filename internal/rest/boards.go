@@ -0,0 +1,405 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/rest/ws"
+	"github.com/Icerzack/excaliroom/internal/scene"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+// maxImportSize is the maximum size of the imported document in bytes.
+const maxImportSize = 64 << 20
+
+// Validator checks the access of the JWT token owner to the board.
+type Validator interface {
+	ValidateAccess(ctx context.Context, jwt, boardID string) (string, error)
+	ValidateOwner(ctx context.Context, jwt, boardID string) (string, error)
+}
+
+// SceneImporter loads the scene into the board room.
+type SceneImporter interface {
+	ImportScene(boardID, userID, elements, appState string, files []*models.File) error
+}
+
+// RoomReader returns the board room, including the rooms evicted to the snapshots.
+type RoomReader interface {
+	PeekRoom(boardID string) *models.Room
+}
+
+// RoomLocker locks the board room for the new users.
+type RoomLocker interface {
+	LockRoom(boardID, userID string, locked bool) error
+}
+
+// Export formats of the board scene.
+const (
+	exportFormatExcalidraw = "excalidraw"
+	exportFormatJSON       = "json"
+	exportFormatSVG        = "svg"
+	exportFormatPNG        = "png"
+)
+
+// boardsHandler serves the /boards endpoints.
+type boardsHandler struct {
+	validator     Validator
+	importer      SceneImporter
+	locker        RoomLocker
+	rooms         RoomReader
+	filesStorage  file.Storage
+	png           *scene.PNGRenderer
+	jwtHeaderName string
+	logger        *zap.Logger
+}
+
+func newBoardsHandler(
+	validator Validator,
+	importer SceneImporter,
+	locker RoomLocker,
+	rooms RoomReader,
+	filesStorage file.Storage,
+	jwtHeaderName string,
+	logger *zap.Logger,
+) *boardsHandler {
+	return &boardsHandler{
+		validator:     validator,
+		importer:      importer,
+		locker:        locker,
+		rooms:         rooms,
+		filesStorage:  filesStorage,
+		png:           scene.NewPNGRenderer(),
+		jwtHeaderName: jwtHeaderName,
+		logger:        logger,
+	}
+}
+
+// authorize validates the JWT token of the request against the board and writes the error response on failure.
+func (h *boardsHandler) authorize(w http.ResponseWriter, r *http.Request, boardID string) (string, bool) {
+	return h.check(w, r, boardID, h.validator.ValidateAccess)
+}
+
+// authorizeOwner is the same as authorize, but only the owners and the admins of the board are allowed.
+func (h *boardsHandler) authorizeOwner(w http.ResponseWriter, r *http.Request, boardID string) (string, bool) {
+	return h.check(w, r, boardID, h.validator.ValidateOwner)
+}
+
+func (h *boardsHandler) check(
+	w http.ResponseWriter,
+	r *http.Request,
+	boardID string,
+	validate func(ctx context.Context, jwt, boardID string) (string, error),
+) (string, bool) {
+	jwt := r.Header.Get(h.jwtHeaderName)
+	if jwt == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return "", false
+	}
+
+	userID, err := validate(r.Context(), jwt, boardID)
+	switch {
+	case err == nil:
+		return userID, true
+	case errors.Is(err, ws.ErrNoBoardAccess), errors.Is(err, ws.ErrNotBoardOwner):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, ws.ErrInvalidJWT), errors.Is(err, ws.ErrValidatingJWT):
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	default:
+		h.logger.Error("Failed to validate access", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+	return "", false
+}
+
+// getFile serves the file of the board. The file is sent as an attachment in a sandbox,
+// so the uploaded content can't run scripts in the origin of the server.
+func (h *boardsHandler) getFile(w http.ResponseWriter, r *http.Request) {
+	boardID := chi.URLParam(r, "boardID")
+	fileID := chi.URLParam(r, "fileID")
+
+	if _, ok := h.authorize(w, r, boardID); !ok {
+		return
+	}
+
+	f, err := h.filesStorage.Get(boardID, fileID)
+	if errors.Is(err, file.ErrFileNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get file", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	mimeType := f.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(f.Data)))
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	setSandboxHeaders(w)
+	if _, err = w.Write(f.Data); err != nil {
+		h.logger.Debug("Failed to write file", zap.Error(err))
+	}
+}
+
+// export serves the current scene of the board room in the requested format.
+func (h *boardsHandler) export(w http.ResponseWriter, r *http.Request) {
+	boardID := chi.URLParam(r, "boardID")
+
+	if _, ok := h.authorize(w, r, boardID); !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatExcalidraw
+	}
+	scale, ok := parseScale(r.URL.Query().Get("scale"))
+	if !ok {
+		http.Error(w, "invalid scale", http.StatusBadRequest)
+		return
+	}
+
+	// The idle rooms evicted over the memory budget are read from their snapshots
+	currentRoom := h.rooms.PeekRoom(boardID)
+	if currentRoom == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if currentRoom.Encrypted {
+		http.Error(w, "encrypted boards can't be exported", http.StatusConflict)
+		return
+	}
+
+	elementsJSON, appStateJSON := currentRoom.GetElements(), currentRoom.GetAppState()
+	var body bytes.Buffer
+	var contentType string
+	var err error
+	switch format {
+	case exportFormatExcalidraw:
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": boardID + ".excalidraw",
+		}))
+		contentType, err = h.exportExcalidraw(&body, boardID, elementsJSON, appStateJSON)
+	case exportFormatJSON:
+		contentType, err = exportJSON(&body, elementsJSON, appStateJSON)
+	case exportFormatSVG:
+		setSandboxHeaders(w)
+		contentType, err = h.exportSVG(&body, boardID, elementsJSON, appStateJSON)
+	case exportFormatPNG:
+		contentType, err = h.exportPNG(&body, boardID, elementsJSON, appStateJSON, scale)
+	default:
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to export board", zap.String("boardID", boardID), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err = body.WriteTo(w); err != nil {
+		h.logger.Debug("Failed to write export", zap.Error(err))
+	}
+}
+
+// parseScale parses the scale of the exported image, the empty scale is 1.
+func parseScale(v string) (float64, bool) {
+	if v == "" {
+		return 1, true
+	}
+	scale, err := strconv.ParseFloat(v, 64)
+	if err != nil || scale <= 0 || scale > scene.MaxScale {
+		return 0, false
+	}
+	return scale, true
+}
+
+// exportExcalidraw encodes the scene with its files as an .excalidraw document and returns its content type.
+func (h *boardsHandler) exportExcalidraw(
+	body *bytes.Buffer,
+	boardID, elementsJSON, appStateJSON string,
+) (string, error) {
+	elements, err := scene.ParseElements(elementsJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse elements: %w", err)
+	}
+	files := h.boardFiles(boardID, scene.FileIDs(elements))
+	filesList := make([]*models.File, 0, len(files))
+	for _, f := range files {
+		filesList = append(filesList, f)
+	}
+
+	document, err := scene.NewDocument(elementsJSON, appStateJSON, filesList)
+	if err != nil {
+		return "", fmt.Errorf("failed to create document: %w", err)
+	}
+	if err := json.NewEncoder(body).Encode(document); err != nil {
+		return "", fmt.Errorf("failed to encode document: %w", err)
+	}
+	return "application/vnd.excalidraw+json", nil
+}
+
+// exportJSON encodes the elements and the app state of the scene as JSON and returns its content type.
+func exportJSON(body *bytes.Buffer, elementsJSON, appStateJSON string) (string, error) {
+	document, err := scene.NewDocument(elementsJSON, appStateJSON, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create document: %w", err)
+	}
+	err = json.NewEncoder(body).Encode(map[string]json.RawMessage{
+		"elements": document.Elements,
+		"appState": document.AppState,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode document: %w", err)
+	}
+	return "application/json", nil
+}
+
+// exportSVG renders the scene as an SVG image and returns its content type.
+func (h *boardsHandler) exportSVG(body *bytes.Buffer, boardID, elementsJSON, appStateJSON string) (string, error) {
+	elements, appState, files, err := h.parseScene(boardID, elementsJSON, appStateJSON)
+	if err != nil {
+		return "", err
+	}
+	if err := scene.RenderSVG(body, elements, appState, files); err != nil {
+		return "", fmt.Errorf("failed to render SVG: %w", err)
+	}
+	return "image/svg+xml", nil
+}
+
+// exportPNG renders the scene as a PNG image at the scale and returns its content type.
+func (h *boardsHandler) exportPNG(
+	body *bytes.Buffer,
+	boardID, elementsJSON, appStateJSON string,
+	scale float64,
+) (string, error) {
+	elements, appState, files, err := h.parseScene(boardID, elementsJSON, appStateJSON)
+	if err != nil {
+		return "", err
+	}
+	if err := h.png.RenderPNG(body, elements, appState, files, scale); err != nil {
+		return "", fmt.Errorf("failed to render PNG: %w", err)
+	}
+	return "image/png", nil
+}
+
+// parseScene parses the elements and the app state of the scene and reads the files of its image elements.
+func (h *boardsHandler) parseScene(
+	boardID, elementsJSON, appStateJSON string,
+) ([]scene.Element, scene.AppState, map[string]*models.File, error) {
+	elements, err := scene.ParseElements(elementsJSON)
+	if err != nil {
+		return nil, scene.AppState{}, nil, fmt.Errorf("failed to parse elements: %w", err)
+	}
+	appState, err := scene.ParseAppState(appStateJSON)
+	if err != nil {
+		return nil, scene.AppState{}, nil, fmt.Errorf("failed to parse app state: %w", err)
+	}
+	return elements, appState, h.boardFiles(boardID, scene.FileIDs(elements)), nil
+}
+
+// importScene loads the .excalidraw document into the board room and sends it to the connected users.
+func (h *boardsHandler) importScene(w http.ResponseWriter, r *http.Request) {
+	boardID := chi.URLParam(r, "boardID")
+
+	userID, ok := h.authorizeOwner(w, r, boardID)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	imported, err := scene.ParseDocument(boardID, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.importer.ImportScene(boardID, userID, imported.Elements, imported.AppState, imported.Files)
+	switch {
+	case errors.Is(err, ws.ErrSceneTooLarge),
+		errors.Is(err, file.ErrFileTooLarge),
+		errors.Is(err, file.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ws.ErrRoomEncrypted):
+		http.Error(w, "encrypted boards can't be imported", http.StatusConflict)
+		return
+	case errors.Is(err, scene.ErrInvalidScene):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Error("Failed to import scene", zap.String("boardID", boardID), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// boardFiles returns a map of the stored files of the board by id, the missing files are skipped.
+func (h *boardsHandler) boardFiles(boardID string, fileIDs []string) map[string]*models.File {
+	files := make(map[string]*models.File, len(fileIDs))
+	for _, id := range fileIDs {
+		f, err := h.filesStorage.Get(boardID, id)
+		if err != nil {
+			continue
+		}
+		files[id] = f
+	}
+	return files
+}
+
+// setSandboxHeaders forbids the browsers to sniff the content type and to run the scripts of the user content.
+func setSandboxHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox")
+}
+
+// lockRoom locks the board room, so no new users can join it.
+func (h *boardsHandler) lockRoom(w http.ResponseWriter, r *http.Request) {
+	h.setLock(w, r, true)
+}
+
+// unlockRoom unlocks the board room.
+func (h *boardsHandler) unlockRoom(w http.ResponseWriter, r *http.Request) {
+	h.setLock(w, r, false)
+}
+
+func (h *boardsHandler) setLock(w http.ResponseWriter, r *http.Request, locked bool) {
+	boardID := chi.URLParam(r, "boardID")
+
+	userID, ok := h.authorizeOwner(w, r, boardID)
+	if !ok {
+		return
+	}
+
+	if err := h.locker.LockRoom(boardID, userID, locked); err != nil {
+		h.logger.Error("Failed to lock room", zap.String("boardID", boardID), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
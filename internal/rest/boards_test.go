@@ -0,0 +1,302 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/rest/ws"
+	"github.com/Icerzack/excaliroom/internal/scene"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+	inmemFile "github.com/Icerzack/excaliroom/internal/storage/file/inmemory"
+	"github.com/Icerzack/excaliroom/internal/storage/room"
+	inmemRoom "github.com/Icerzack/excaliroom/internal/storage/room/inmemory"
+)
+
+const (
+	testJwtHeader = "Authorization"
+	testBoardID   = "board-1"
+	testUserID    = "user-1"
+
+	testImportTarget = "/boards/" + testBoardID + "/scene"
+	testLockTarget   = "/boards/" + testBoardID + "/lock"
+
+	// testForbiddenJwt is the token without the access to the boards
+	testForbiddenJwt = "forbidden"
+)
+
+// testValidator accepts any token except testForbiddenJwt, the token is the user id.
+type testValidator struct{}
+
+func (testValidator) ValidateAccess(_ context.Context, jwt, _ string) (string, error) {
+	if jwt == testForbiddenJwt {
+		return "", ws.ErrNoBoardAccess
+	}
+	return jwt, nil
+}
+
+func (v testValidator) ValidateOwner(ctx context.Context, jwt, boardID string) (string, error) {
+	return v.ValidateAccess(ctx, jwt, boardID)
+}
+
+// testImporter records the imported scene and returns err.
+type testImporter struct {
+	err      error
+	elements string
+}
+
+func (i *testImporter) ImportScene(_, _, elements, _ string, _ []*models.File) error {
+	i.elements = elements
+	return i.err
+}
+
+// testLocker records the last lock of the board.
+type testLocker struct {
+	locks map[string]bool
+}
+
+func (l *testLocker) LockRoom(boardID, _ string, locked bool) error {
+	l.locks[boardID] = locked
+	return nil
+}
+
+// testRooms reads the rooms from the storage.
+type testRooms struct {
+	room.Storage
+}
+
+func (r testRooms) PeekRoom(boardID string) *models.Room {
+	currentRoom, _ := r.Get(boardID)
+	return currentRoom
+}
+
+// testBoards serves the /boards endpoints with the in-memory storages.
+type testBoards struct {
+	router   http.Handler
+	importer *testImporter
+	locker   *testLocker
+	rooms    room.Storage
+	files    file.Storage
+}
+
+func newTestBoards(t *testing.T) *testBoards {
+	t.Helper()
+	logger := zap.NewNop()
+	b := &testBoards{
+		importer: &testImporter{},
+		locker:   &testLocker{locks: make(map[string]bool)},
+		rooms:    inmemRoom.NewStorage(logger),
+		files:    inmemFile.NewStorage(file.Quota{}, logger),
+	}
+	h := newBoardsHandler(testValidator{}, b.importer, b.locker, testRooms{b.rooms}, b.files, testJwtHeader, logger)
+	router := chi.NewRouter()
+	router.Get("/boards/{boardID}/export", h.export)
+	router.Put("/boards/{boardID}/scene", h.importScene)
+	router.Put("/boards/{boardID}/lock", h.lockRoom)
+	router.Delete("/boards/{boardID}/lock", h.unlockRoom)
+	b.router = router
+	return b
+}
+
+// do sends the request with the token and returns the response.
+func (b *testBoards) do(method, target, jwt string) *httptest.ResponseRecorder {
+	return b.doWithBody(method, target, jwt, "")
+}
+
+// doWithBody sends the request with the token and the body and returns the response.
+func (b *testBoards) doWithBody(method, target, jwt, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if jwt != "" {
+		r.Header.Set(testJwtHeader, jwt)
+	}
+	w := httptest.NewRecorder()
+	b.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestExport(t *testing.T) {
+	b := newTestBoards(t)
+	currentRoom := models.NewRoom(testBoardID)
+	// The numbers accepted by the sanitizer can be fractional, e.g. the font family and the roundness type
+	currentRoom.SetElements(`[` +
+		`{"id":"a","type":"text","x":0,"y":0,"width":100,"height":30,"text":"hi","fontFamily":1.5},` +
+		`{"id":"b","type":"rectangle","x":0,"y":40,"width":100,"height":50,"roundness":{"type":2.5}}` +
+		`]`)
+	_ = b.rooms.Set(testBoardID, currentRoom)
+
+	tests := []struct {
+		format          string
+		wantContentType string
+	}{
+		{format: exportFormatExcalidraw, wantContentType: "application/vnd.excalidraw+json"},
+		{format: exportFormatJSON, wantContentType: "application/json"},
+		{format: exportFormatSVG, wantContentType: "image/svg+xml"},
+		{format: exportFormatPNG, wantContentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			w := b.do(http.MethodGet, "/boards/"+testBoardID+"/export?format="+tt.format, testUserID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestExportRejects(t *testing.T) {
+	b := newTestBoards(t)
+	encrypted := models.NewRoom("encrypted")
+	encrypted.Encrypted = true
+	_ = b.rooms.Set(encrypted.BoardID, encrypted)
+	_ = b.rooms.Set(testBoardID, models.NewRoom(testBoardID))
+
+	tests := []struct {
+		name       string
+		target     string
+		jwt        string
+		wantStatus int
+	}{
+		{name: "no token", target: "/boards/" + testBoardID + "/export", wantStatus: http.StatusUnauthorized},
+		{
+			name:       "no access",
+			target:     "/boards/" + testBoardID + "/export",
+			jwt:        testForbiddenJwt,
+			wantStatus: http.StatusForbidden,
+		},
+		{name: "unknown board", target: "/boards/unknown/export", jwt: testUserID, wantStatus: http.StatusNotFound},
+		{name: "encrypted board", target: "/boards/encrypted/export", jwt: testUserID, wantStatus: http.StatusConflict},
+		{
+			name:       "unknown format",
+			target:     "/boards/" + testBoardID + "/export?format=pdf",
+			jwt:        testUserID,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid scale",
+			target:     "/boards/" + testBoardID + "/export?format=png&scale=0",
+			jwt:        testUserID,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := b.do(http.MethodGet, tt.target, tt.jwt); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	b := newTestBoards(t)
+	document := `{"type":"excalidraw","elements":[{"id":"a","type":"rectangle"}],"appState":{}}`
+
+	w := b.doWithBody(http.MethodPut, testImportTarget, testUserID, document)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if want := `[{"id":"a","type":"rectangle"}]`; b.importer.elements != want {
+		t.Errorf("imported %s, want %s", b.importer.elements, want)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	const document = `{"elements":[]}`
+	tests := []struct {
+		name        string
+		jwt         string
+		document    string
+		importerErr error
+		wantStatus  int
+	}{
+		{name: "no token", document: document, wantStatus: http.StatusUnauthorized},
+		{name: "no access", jwt: testForbiddenJwt, document: document, wantStatus: http.StatusForbidden},
+		{name: "invalid document", jwt: testUserID, document: `{"elements":{}}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "document too large",
+			jwt:        testUserID,
+			document:   `{"elements":[],"source":"` + strings.Repeat("a", maxImportSize) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "scene too large",
+			jwt:         testUserID,
+			document:    document,
+			importerErr: ws.ErrSceneTooLarge,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "files over the quota",
+			jwt:         testUserID,
+			document:    document,
+			importerErr: file.ErrQuotaExceeded,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "encrypted board",
+			jwt:         testUserID,
+			document:    document,
+			importerErr: ws.ErrRoomEncrypted,
+			wantStatus:  http.StatusConflict,
+		},
+		{
+			name:        "invalid scene",
+			jwt:         testUserID,
+			document:    document,
+			importerErr: scene.ErrInvalidScene,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "importer failure",
+			jwt:         testUserID,
+			document:    document,
+			importerErr: errors.New("failure"),
+			wantStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBoards(t)
+			b.importer.err = tt.importerErr
+			if w := b.doWithBody(http.MethodPut, testImportTarget, tt.jwt, tt.document); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	b := newTestBoards(t)
+
+	if w := b.do(http.MethodPut, testLockTarget, testUserID); w.Code != http.StatusNoContent {
+		t.Fatalf("lock status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if !b.locker.locks[testBoardID] {
+		t.Error("board not locked")
+	}
+	if w := b.do(http.MethodDelete, testLockTarget, testUserID); w.Code != http.StatusNoContent {
+		t.Fatalf("unlock status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if b.locker.locks[testBoardID] {
+		t.Error("board not unlocked")
+	}
+
+	// Only the owners lock the boards
+	if w := b.do(http.MethodPut, testLockTarget, testForbiddenJwt); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if b.locker.locks[testBoardID] {
+		t.Error("board locked without the access")
+	}
+}
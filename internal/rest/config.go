@@ -17,17 +17,221 @@ type Config struct {
 	// BoardValidationURL is the URL which returns the board based on the board id
 	BoardValidationURL string
 
+	// ValidationTimeout is the time allowed for a request to the validation URLs in seconds
+	ValidationTimeout int64
+
+	// ValidationDeadline is the time allowed for a validation request including the retries in seconds
+	ValidationDeadline int64
+
+	// ValidationMaxIdleConns is the number of the idle connections to the validation URLs kept per host
+	ValidationMaxIdleConns int
+
+	// ValidationMaxRetries is the number of the retries of the failed validation requests, negative disables them
+	ValidationMaxRetries int
+
+	// ValidationRetryBackoff is the delay before the first retry of a validation request in milliseconds
+	ValidationRetryBackoff int64
+
+	// ValidationBreakerThreshold is the number of the consecutive failed validation requests that open the circuit,
+	// negative disables the circuit breaker
+	ValidationBreakerThreshold int
+
+	// ValidationBreakerCooldown is the time the circuit stays open in seconds
+	ValidationBreakerCooldown int64
+
+	// ValidationFallback is the policy while the validation URLs are unavailable, "deny" or "cache"
+	ValidationFallback string
+
+	// ValidationFallbackTTL is the time the last decisions are kept for the "cache" fallback in seconds
+	ValidationFallbackTTL int64
+
+	// AllowedOrigins is the list of origins allowed to open a websocket connection
+	AllowedOrigins []string
+
+	// SocketIOEnabled enables the Socket.IO endpoint compatible with the Excalidraw collaboration client
+	SocketIOEnabled bool
+
+	// SocketIOAllowAnonymous allows the Socket.IO clients without the JWT token to join the rooms
+	SocketIOAllowAnonymous bool
+
+	// SocketIOPingInterval is the interval between Engine.IO pings in seconds
+	SocketIOPingInterval int64
+
+	// SocketIOPingTimeout is the time allowed to answer an Engine.IO ping in seconds
+	SocketIOPingTimeout int64
+
+	// WebhookURL is the URL the room events are posted to, empty disables the webhooks
+	WebhookURL string
+
+	// WebhookSecret is the key of the webhook payloads signature
+	WebhookSecret string
+
+	// WebhookEvents is the list of the event types sent to the webhook, empty means all
+	WebhookEvents []string
+
+	// WebhookQueueSize is the number of the events waiting for the delivery
+	WebhookQueueSize int
+
+	// WebhookWorkers is the number of the concurrent webhook deliveries
+	WebhookWorkers int
+
+	// WebhookMaxRetries is the number of the delivery retries
+	WebhookMaxRetries int
+
+	// WebhookTimeout is the time allowed for a delivery attempt in seconds
+	WebhookTimeout int64
+
+	// WebhookDebounce is the time the scene updates are collected into a single event in seconds
+	WebhookDebounce int64
+
+	// AdminToken is the bearer token of the /access and /stats endpoints, empty disables them
+	AdminToken string
+
+	// EventsToken is the bearer token of the /events stream, empty disables the stream
+	EventsToken string
+
+	// EventsBufferSize is the number of the events buffered per /events client
+	EventsBufferSize int
+
+	// EventsKeepAlive is the interval between the keep-alive comments of the /events stream in seconds
+	EventsKeepAlive int64
+
+	// ShutdownTimeout is the time allowed to drain the connections and deliver the webhooks on stop in seconds
+	ShutdownTimeout int64
+
+	// ShutdownReconnectAfter is the minimum delay before the clients reconnect after the shutdown in seconds
+	ShutdownReconnectAfter int64
+
+	// SnapshotsStorageType is the type of the storage of the room snapshots, empty disables the snapshots
+	SnapshotsStorageType string
+
+	// SnapshotsStoragePath is the directory of the disk snapshots storage
+	SnapshotsStoragePath string
+
 	// UsersStorageType is the type of the storage that will be used
 	UsersStorageType string
 
 	// RoomsStorageType is the type of the storage that will be used
 	RoomsStorageType string
 
+	// FilesStorageType is the type of the storage that will be used for the board files
+	FilesStorageType string
+
+	// FilesStoragePath is the directory of the disk files storage
+	FilesStoragePath string
+
+	// MaxFileSize is the maximum size of a single board file in bytes
+	MaxFileSize int64
+
+	// MaxBoardFilesSize is the maximum size of all the files of a board in bytes
+	MaxBoardFilesSize int64
+
+	// MaxFilesSize is the maximum size of all the stored files in bytes
+	MaxFilesSize int64
+
+	// FilesTTL is the time the files are kept after they were last stored or read in seconds, negative disables it
+	FilesTTL int64
+
 	// CacheType is the type of the cache that will be used
 	CacheType string
 
 	// CacheTTL is the time to live of the cache
 	CacheTTL int64
 
+	// CacheAccessTTL is the time to live of the cached access of the users to the boards in seconds
+	CacheAccessTTL int64
+
+	// CacheNegativeTTL is the time to live of the cached rejections in seconds, negative disables them
+	CacheNegativeTTL int64
+
+	// CacheMaxEntries is the capacity of the cache, the least recently used items are evicted beyond it
+	CacheMaxEntries int
+
+	// CacheNegativeMaxEntries is the capacity of the cache of the rejections
+	CacheNegativeMaxEntries int
+
+	// CacheFallbackMaxEntries is the capacity of the cache of the decisions kept for the "cache" fallback
+	CacheFallbackMaxEntries int
+
+	// CacheCleanupInterval is the interval between the removals of the expired cache items in seconds
+	CacheCleanupInterval int64
+
+	// MaxScenesSize is the memory budget of the room scenes in bytes, zero means unlimited
+	MaxScenesSize int64
+
+	// MessageQueueSize is the number of inbound messages buffered per websocket connection
+	MessageQueueSize int
+
+	// MaxConcurrentHandlers is the number of websocket messages processed at the same time
+	MaxConcurrentHandlers int
+
+	// PingInterval is the interval between websocket pings in seconds
+	PingInterval int64
+
+	// PongWait is the time allowed to receive a pong before the connection is closed in seconds
+	PongWait int64
+
+	// WriteTimeout is the time allowed to write a websocket message in seconds
+	WriteTimeout int64
+
+	// MaxMessageSize is the maximum size of an inbound websocket message in bytes
+	MaxMessageSize int64
+
+	// MaxSceneSize is the maximum size of a room scene in bytes
+	MaxSceneSize int
+
+	// MaxElements is the maximum number of the elements in a room scene
+	MaxElements int
+
+	// MaxTextLength is the maximum length of the text of an element in characters
+	MaxTextLength int
+
+	// RateLimits is a map of per connection rate limits by event type
+	RateLimits map[string]RateLimit
+
+	// MaxViolations is the number of limit violations within ViolationWindow after which the connection is dropped
+	MaxViolations int
+
+	// ViolationWindow is the time the limit violations are counted for in seconds
+	ViolationWindow int64
+
+	// EnableCompression enables the permessage-deflate websocket extension
+	EnableCompression bool
+
+	// CompressionLevel is the flate compression level of the websocket messages, zero is the default level
+	CompressionLevel int
+
+	// CompressionThreshold is the minimum size of a websocket message that is compressed in bytes
+	CompressionThreshold int
+
+	// KeepEncryptedScenes keeps the last encrypted scene of the room for the new users
+	KeepEncryptedScenes bool
+
+	// MaxRoomUsers is the maximum number of the participants of a room, zero means unlimited
+	MaxRoomUsers int
+
+	// RoomOverflow is the policy of the users joining a full room, "reject" or "spectate"
+	RoomOverflow string
+
+	// ResumeGracePeriod is the time a disconnected user can resume the session in seconds, negative disables it
+	ResumeGracePeriod int64
+
+	// ResumeHistorySize is the number of the last events of a room kept for the resumed sessions
+	ResumeHistorySize int
+
+	// ChatHistorySize is the number of the last chat messages of a room sent to the new users
+	ChatHistorySize int
+
+	// MaxChatMessageLength is the maximum length of a chat message in characters
+	MaxChatMessageLength int
+
 	Logger *zap.Logger
 }
+
+type RateLimit struct {
+	// Rate is the number of events allowed per second
+	Rate float64
+
+	// Burst is the maximum number of events allowed at once
+	Burst int
+}
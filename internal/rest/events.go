@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/events"
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// defaultEventsKeepAlive is the interval between the keep-alive comments of the event stream
+const defaultEventsKeepAlive = 15 * time.Second
+
+// eventsHandler streams the room events as Server-Sent Events.
+type eventsHandler struct {
+	hub       *events.Hub
+	token     string
+	keepAlive time.Duration
+	logger    *zap.Logger
+}
+
+// streamEvent is the room event with the optional full scene.
+type streamEvent struct {
+	models.RoomEvent
+	Scene *streamScene `json:"scene,omitempty"`
+}
+
+type streamScene struct {
+	Elements json.RawMessage `json:"elements"`
+	AppState json.RawMessage `json:"app_state"`
+}
+
+func newEventsHandler(hub *events.Hub, token string, keepAlive int64, logger *zap.Logger) *eventsHandler {
+	interval := time.Duration(keepAlive) * time.Second
+	if interval <= 0 {
+		interval = defaultEventsKeepAlive
+	}
+	return &eventsHandler{
+		hub:       hub,
+		token:     token,
+		keepAlive: interval,
+		logger:    logger,
+	}
+}
+
+// stream sends the room events until the client disconnects. The events can be filtered
+// with the board_id query parameters, and scene=full adds the scene to the scene updates.
+func (h *eventsHandler) stream(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.token) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	fullScene := r.URL.Query().Get("scene") == "full"
+	subscription := h.hub.Subscribe(r.URL.Query()["board_id"])
+	if subscription == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.hub.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event, fullScene); err != nil {
+				h.logger.Debug("Failed to write event", zap.Error(err))
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.RoomEvent, fullScene bool) error {
+	payload := streamEvent{RoomEvent: event}
+	if fullScene && event.Elements != "" {
+		appState := event.AppState
+		if appState == "" {
+			appState = "{}"
+		}
+		payload.Scene = &streamScene{
+			Elements: json.RawMessage(event.Elements),
+			AppState: json.RawMessage(appState),
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if _, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/events"
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// testStreamTimeout is the time the tests wait for the streamed events
+const testStreamTimeout = 5 * time.Second
+
+// testStream reads the Server-Sent Events of the response.
+type testStream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+// openStream requests the event stream with the query and waits until it is connected.
+func openStream(t *testing.T, hub *events.Hub, query string) *testStream {
+	t.Helper()
+	h := newEventsHandler(hub, testAdminToken, 0, zap.NewNop())
+	server := httptest.NewServer(http.HandlerFunc(h.stream))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testStreamTimeout)
+	t.Cleanup(cancel)
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+query, nil)
+	setAdminToken(r, testAdminToken)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Do() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %s, want text/event-stream", got)
+	}
+
+	s := &testStream{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+	if line := s.line(t); line != ": connected" {
+		t.Fatalf("first line %s, want the connected comment", line)
+	}
+	return s
+}
+
+// line returns the next non-empty line of the stream.
+func (s *testStream) line(t *testing.T) string {
+	t.Helper()
+	for s.scanner.Scan() {
+		if line := s.scanner.Text(); line != "" {
+			return line
+		}
+	}
+	t.Fatalf("stream ended: %v", s.scanner.Err())
+	return ""
+}
+
+// event reads the next event and decodes its data.
+func (s *testStream) event(t *testing.T) (string, map[string]json.RawMessage) {
+	t.Helper()
+	s.line(t)
+	eventType := strings.TrimPrefix(s.line(t), "event: ")
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(s.line(t), "data: ")), &data); err != nil {
+		t.Fatalf("Unmarshal() unexpected error: %v", err)
+	}
+	return eventType, data
+}
+
+func TestEventsStream(t *testing.T) {
+	hub := events.NewHub(0, zap.NewNop())
+	stream := openStream(t, hub, "?board_id="+testBoardID)
+
+	// The events of the other boards are skipped
+	room := models.NewRoom(testBoardID)
+	hub.Notify(models.NewRoomEvent(models.RoomEventRoomCreated, models.NewRoom("board-2"), ""))
+	hub.Notify(models.NewRoomEvent(models.RoomEventRoomCreated, room, ""))
+	hub.Notify(models.NewSceneUpdatedEvent(room, testUserID, `[]`, ""))
+
+	eventType, data := stream.event(t)
+	if eventType != models.RoomEventRoomCreated || string(data["board_id"]) != `"`+testBoardID+`"` {
+		t.Errorf("got %s of the board %s, want %s of %s",
+			eventType, data["board_id"], models.RoomEventRoomCreated, testBoardID)
+	}
+	// The scene is sent only on request
+	if eventType, data = stream.event(t); eventType != models.RoomEventSceneUpdated || data["scene"] != nil {
+		t.Errorf("got %s with the scene %s, want %s without the scene", eventType, data["scene"],
+			models.RoomEventSceneUpdated)
+	}
+}
+
+func TestEventsStreamFullScene(t *testing.T) {
+	hub := events.NewHub(0, zap.NewNop())
+	stream := openStream(t, hub, "?scene=full")
+
+	hub.Notify(models.NewSceneUpdatedEvent(models.NewRoom(testBoardID), testUserID, `[{"id":"a"}]`, ""))
+
+	_, data := stream.event(t)
+	var scene streamScene
+	if err := json.Unmarshal(data["scene"], &scene); err != nil {
+		t.Fatalf("Unmarshal() unexpected error: %v", err)
+	}
+	if string(scene.Elements) != `[{"id":"a"}]` || string(scene.AppState) != "{}" {
+		t.Errorf("scene = %s and %s, want the elements and the empty app state", scene.Elements, scene.AppState)
+	}
+}
+
+func TestEventsStreamEndsWithHub(t *testing.T) {
+	hub := events.NewHub(0, zap.NewNop())
+	stream := openStream(t, hub, "")
+
+	hub.Close()
+	for stream.scanner.Scan() {
+		if line := stream.scanner.Text(); line != "" && !strings.HasPrefix(line, ":") {
+			t.Fatalf("got line %s, want the end of the stream", line)
+		}
+	}
+}
+
+func TestEventsStreamRejects(t *testing.T) {
+	closed := events.NewHub(0, zap.NewNop())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		hub        *events.Hub
+		token      string
+		wantStatus int
+	}{
+		{name: "no token", hub: events.NewHub(0, zap.NewNop()), wantStatus: http.StatusUnauthorized},
+		{
+			name:       "invalid token",
+			hub:        events.NewHub(0, zap.NewNop()),
+			token:      "invalid",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{name: "closed hub", hub: closed, token: testAdminToken, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newEventsHandler(tt.hub, testAdminToken, 0, zap.NewNop())
+			r := httptest.NewRequest(http.MethodGet, "/events", nil)
+			setAdminToken(r, tt.token)
+			w := httptest.NewRecorder()
+			h.stream(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -3,25 +3,64 @@ package rest
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
 	"github.com/Icerzack/excaliroom/internal/cache"
 	"github.com/Icerzack/excaliroom/internal/cache/inmemory"
+	"github.com/Icerzack/excaliroom/internal/events"
+	"github.com/Icerzack/excaliroom/internal/rest/socketio"
 	"github.com/Icerzack/excaliroom/internal/rest/ws"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+	diskFile "github.com/Icerzack/excaliroom/internal/storage/file/disk"
+	inmemFile "github.com/Icerzack/excaliroom/internal/storage/file/inmemory"
 	"github.com/Icerzack/excaliroom/internal/storage/room"
 	inmemRoom "github.com/Icerzack/excaliroom/internal/storage/room/inmemory"
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot"
+	diskSnapshot "github.com/Icerzack/excaliroom/internal/storage/snapshot/disk"
 	"github.com/Icerzack/excaliroom/internal/storage/user"
 	inmemUser "github.com/Icerzack/excaliroom/internal/storage/user/inmemory"
+	"github.com/Icerzack/excaliroom/internal/upstream"
+	"github.com/Icerzack/excaliroom/internal/webhook"
 )
 
+// defaultFilesStoragePath is the directory of the disk files storage if none is configured
+const defaultFilesStoragePath = "files"
+
+// defaultSnapshotsStoragePath is the directory of the disk snapshots storage if none is configured
+const defaultSnapshotsStoragePath = "snapshots"
+
+// defaultShutdownTimeout is the time allowed to drain the connections and deliver the webhooks on stop
+const defaultShutdownTimeout = 30 * time.Second
+
+// defaultNegativeCacheMaxEntries is the capacity of the cache of the rejections.
+const defaultNegativeCacheMaxEntries = 10000
+
 type Rest struct {
 	config *Config
 
 	server *http.Server
+
+	// wsServer is the websocket handler, its hijacked connections are drained on stop
+	wsServer *ws.WebSocketHandler
+
+	// sioServer is the Socket.IO handler, nil if it is disabled
+	sioServer *socketio.Handler
+
+	// webhooks is the dispatcher of the webhooks, nil if they are disabled
+	webhooks *webhook.Dispatcher
+
+	// events is the hub of the /events stream, nil if it is disabled
+	events *events.Hub
+
+	// caches keep the validation results, the rejections and the decisions for the fallback,
+	// empty until the server starts
+	caches []cache.Cache
 }
 
 func NewRest(config *Config) *Rest {
@@ -31,6 +70,55 @@ func NewRest(config *Config) *Rest {
 }
 
 func (rest *Rest) Start() {
+	usersStorage, roomsStorage := rest.defineStorage()
+	filesStorage, err := rest.defineFileStorage()
+	if err != nil {
+		rest.config.Logger.Error("failed to create files storage", zap.Error(err))
+		return
+	}
+	snapshotsStorage, err := rest.defineSnapshotStorage()
+	if err != nil {
+		rest.config.Logger.Error("failed to create snapshots storage", zap.Error(err))
+		return
+	}
+	caches := rest.defineCaches()
+	notifiers := rest.defineNotifiers()
+
+	rest.wsServer = ws.NewWebSocketHandler(
+		usersStorage,
+		roomsStorage,
+		filesStorage,
+		caches.validation,
+		rest.newWebSocketConfig(caches, notifiers, snapshotsStorage),
+	)
+	if err := rest.wsServer.RestoreSnapshots(); err != nil {
+		rest.config.Logger.Error("failed to restore snapshots", zap.Error(err))
+	}
+	if rest.config.SocketIOEnabled {
+		// The Socket.IO rooms are separate from the websocket rooms of the same boards,
+		// so they have their own storages
+		sioUsersStorage, sioRoomsStorage := rest.defineStorage()
+		rest.sioServer = socketio.NewHandler(
+			sioUsersStorage,
+			sioRoomsStorage,
+			rest.wsServer,
+			rest.newSocketIOConfig(notifiers),
+		)
+	}
+
+	rest.server = &http.Server{
+		Addr:              ":" + strconv.Itoa(rest.config.Port),
+		Handler:           rest.registerRoutes(filesStorage, caches),
+		ReadHeaderTimeout: 0,
+	}
+	if err := rest.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		rest.config.Logger.Error("server error", zap.Error(err))
+		return
+	}
+}
+
+// registerRoutes returns the router serving the endpoints of the handlers.
+func (rest *Rest) registerRoutes(filesStorage file.Storage, caches validationCaches) http.Handler {
 	router := chi.NewRouter()
 
 	// Define the /ping endpoint
@@ -42,35 +130,181 @@ func (rest *Rest) Start() {
 	})
 
 	// Define the /ws endpoint
-	usersStorage, roomsStorage := rest.defineStorage()
-	selectedCache := rest.defineCache()
+	router.HandleFunc("/ws", rest.wsServer.Handle)
 
-	wsServer := ws.NewWebSocketHandler(
-		usersStorage,
-		roomsStorage,
-		selectedCache,
-		rest.config.CacheTTL,
+	// Define the /boards endpoints
+	boards := newBoardsHandler(
+		rest.wsServer,
+		rest.wsServer,
+		rest.wsServer,
+		rest.wsServer,
+		filesStorage,
 		rest.config.JwtHeaderName,
-		rest.config.JwtValidationURL,
-		rest.config.BoardValidationURL,
 		rest.config.Logger,
 	)
-	router.HandleFunc("/ws", wsServer.Handle)
+	router.Get("/boards/{boardID}/files/{fileID}", boards.getFile)
+	router.Get("/boards/{boardID}/export", boards.export)
+	router.Put("/boards/{boardID}/scene", boards.importScene)
+	router.Put("/boards/{boardID}/lock", boards.lockRoom)
+	router.Delete("/boards/{boardID}/lock", boards.unlockRoom)
 
-	rest.server = &http.Server{
-		Addr:              ":" + strconv.Itoa(rest.config.Port),
-		Handler:           router,
-		ReadHeaderTimeout: 0,
+	// Define the /events endpoint
+	if rest.events != nil {
+		eventsServer := newEventsHandler(
+			rest.events,
+			rest.config.EventsToken,
+			rest.config.EventsKeepAlive,
+			rest.config.Logger,
+		)
+		router.Get("/events", eventsServer.stream)
 	}
-	if err := rest.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		rest.config.Logger.Error("server error", zap.Error(err))
-		return
+
+	// Define the /socket.io/ endpoint
+	if rest.sioServer != nil {
+		router.HandleFunc("/socket.io/", rest.sioServer.Handle)
+	}
+
+	// Define the /access and /stats endpoints
+	if rest.config.AdminToken != "" {
+		invalidators := AccessInvalidators{rest.wsServer}
+		if rest.sioServer != nil {
+			invalidators = append(invalidators, rest.sioServer)
+		}
+		accessServer := newAccessHandler(invalidators, rest.config.AdminToken, rest.config.Logger)
+		router.Post("/access/invalidate", accessServer.invalidate)
+
+		// Define the /stats endpoint
+		statsServer := newStatsHandler(
+			rest.wsServer,
+			map[string]cache.Cache{
+				"validation": caches.validation,
+				"negative":   caches.negative,
+				"fallback":   caches.fallback,
+			},
+			rest.config.AdminToken,
+			rest.config.Logger,
+		)
+		router.Get("/stats", statsServer.stats)
+	}
+
+	return router
+}
+
+// newWebSocketConfig returns the config of the websocket handler.
+func (rest *Rest) newWebSocketConfig(
+	caches validationCaches,
+	notifiers ws.Notifiers,
+	snapshotsStorage snapshot.Storage,
+) *ws.Config {
+	return &ws.Config{
+		JwtHeaderName:         rest.config.JwtHeaderName,
+		JwtValidationURL:      rest.config.JwtValidationURL,
+		BoardValidationURL:    rest.config.BoardValidationURL,
+		AllowedOrigins:        rest.config.AllowedOrigins,
+		Upstream:              rest.defineUpstream(),
+		ValidationFallback:    rest.config.ValidationFallback,
+		FallbackCacheTTL:      rest.config.ValidationFallbackTTL,
+		CacheTTL:              rest.config.CacheTTL,
+		NegativeCacheTTL:      rest.config.CacheNegativeTTL,
+		NegativeCache:         caches.negative,
+		FallbackCache:         caches.fallback,
+		AccessCacheTTL:        rest.config.CacheAccessTTL,
+		MessageQueueSize:      rest.config.MessageQueueSize,
+		MaxConcurrentHandlers: rest.config.MaxConcurrentHandlers,
+		PingInterval:          rest.config.PingInterval,
+		PongWait:              rest.config.PongWait,
+		WriteTimeout:          rest.config.WriteTimeout,
+		MaxMessageSize:        rest.config.MaxMessageSize,
+		MaxSceneSize:          rest.config.MaxSceneSize,
+		MaxElements:           rest.config.MaxElements,
+		MaxTextLength:         rest.config.MaxTextLength,
+		RateLimits:            rest.defineRateLimits(),
+		MaxViolations:         rest.config.MaxViolations,
+		ViolationWindow:       rest.config.ViolationWindow,
+		EnableCompression:     rest.config.EnableCompression,
+		CompressionLevel:      rest.config.CompressionLevel,
+		CompressionThreshold:  rest.config.CompressionThreshold,
+		KeepEncryptedScenes:   rest.config.KeepEncryptedScenes,
+		MaxRoomUsers:          rest.config.MaxRoomUsers,
+		RoomOverflow:          rest.config.RoomOverflow,
+		ResumeGracePeriod:     rest.config.ResumeGracePeriod,
+		ResumeHistorySize:     rest.config.ResumeHistorySize,
+		ChatHistorySize:       rest.config.ChatHistorySize,
+		MaxScenesSize:         rest.config.MaxScenesSize,
+		FilesTTL:              rest.config.FilesTTL,
+		MaxChatMessageLength:  rest.config.MaxChatMessageLength,
+		Notifier:              notifiers,
+		SnapshotStorage:       snapshotsStorage,
+		ReconnectAfter:        rest.config.ShutdownReconnectAfter,
+		Logger:                rest.config.Logger,
 	}
 }
 
+// newSocketIOConfig returns the config of the Socket.IO handler, it checks the origins like the websocket handler.
+func (rest *Rest) newSocketIOConfig(notifiers ws.Notifiers) *socketio.Config {
+	return &socketio.Config{
+		JwtHeaderName:    rest.config.JwtHeaderName,
+		AllowAnonymous:   rest.config.SocketIOAllowAnonymous,
+		CheckOrigin:      rest.wsServer.CheckOrigin,
+		PingInterval:     rest.config.SocketIOPingInterval,
+		PingTimeout:      rest.config.SocketIOPingTimeout,
+		WriteTimeout:     rest.config.WriteTimeout,
+		MaxMessageSize:   rest.config.MaxMessageSize,
+		MessageQueueSize: rest.config.MessageQueueSize,
+		RateLimits:       rest.defineRateLimits(),
+		MaxViolations:    rest.config.MaxViolations,
+		ViolationWindow:  rest.config.ViolationWindow,
+		Notifier:         notifiers,
+		Logger:           rest.config.Logger,
+	}
+}
+
+// Stop shuts the server down: the listener is closed, the websocket clients are told to reconnect
+// and their connections are drained, then the queued webhooks are delivered. The whole sequence
+// is bounded by the shutdown timeout.
 func (rest *Rest) Stop() {
-	if err := rest.server.Shutdown(context.Background()); err != nil {
-		rest.config.Logger.Error("server error", zap.Error(err))
+	timeout := defaultShutdownTimeout
+	if rest.config.ShutdownTimeout > 0 {
+		timeout = time.Duration(rest.config.ShutdownTimeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The event streams never end by themselves, so they are closed before the server waits for the handlers
+	if rest.events != nil {
+		rest.events.Close()
+	}
+
+	// The server doesn't track the hijacked connections, so they are drained while it waits for the other requests
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		if rest.server == nil {
+			return
+		}
+		if err := rest.server.Shutdown(ctx); err != nil {
+			rest.config.Logger.Error("server error", zap.Error(err))
+		}
+	}()
+	if rest.wsServer != nil {
+		if err := rest.wsServer.Shutdown(ctx); err != nil {
+			rest.config.Logger.Error("websocket shutdown error", zap.Error(err))
+		}
+	}
+	if rest.sioServer != nil {
+		if err := rest.sioServer.Shutdown(ctx); err != nil {
+			rest.config.Logger.Error("socket.io shutdown error", zap.Error(err))
+		}
+	}
+	<-serverDone
+
+	if rest.webhooks != nil {
+		if err := rest.webhooks.Close(ctx); err != nil {
+			rest.config.Logger.Error("webhooks error", zap.Error(err))
+		}
+	}
+	for _, c := range rest.caches {
+		_ = c.Close()
 	}
 }
 
@@ -98,17 +332,142 @@ func (rest *Rest) defineStorage() (user.Storage, room.Storage) {
 	return usersStorage, roomsStorage
 }
 
-func (rest *Rest) defineCache() cache.Cache {
+func (rest *Rest) defineFileStorage() (file.Storage, error) {
+	quota := file.Quota{
+		MaxFileSize:  rest.config.MaxFileSize,
+		MaxBoardSize: rest.config.MaxBoardFilesSize,
+		MaxTotalSize: rest.config.MaxFilesSize,
+	}
+
+	switch rest.config.FilesStorageType {
+	case file.DiskStorageType:
+		path := rest.config.FilesStoragePath
+		if path == "" {
+			path = defaultFilesStoragePath
+		}
+		rest.config.Logger.Info("Using disk storage for files", zap.String("path", path))
+		s, err := diskFile.NewStorage(path, quota, rest.config.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create disk storage: %w", err)
+		}
+		return s, nil
+	default:
+		rest.config.Logger.Info("Using in-memory storage for files")
+		return inmemFile.NewStorage(quota, rest.config.Logger), nil
+	}
+}
+
+func (rest *Rest) defineSnapshotStorage() (snapshot.Storage, error) {
+	switch rest.config.SnapshotsStorageType {
+	case snapshot.DiskStorageType:
+		path := rest.config.SnapshotsStoragePath
+		if path == "" {
+			path = defaultSnapshotsStoragePath
+		}
+		rest.config.Logger.Info("Using disk storage for snapshots", zap.String("path", path))
+		s, err := diskSnapshot.NewStorage(path, rest.config.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create disk storage: %w", err)
+		}
+		return s, nil
+	default:
+		rest.config.Logger.Info("Room snapshots are disabled")
+		return nil, nil
+	}
+}
+
+func (rest *Rest) defineCache(maxEntries int) cache.Cache {
 	var c cache.Cache
 
 	switch rest.config.CacheType {
 	case room.InMemoryStorageType:
 		rest.config.Logger.Info("Using in-memory cache")
-		c = inmemory.NewCache(rest.config.Logger)
+		c = inmemory.NewCache(&inmemory.Config{
+			MaxEntries:      maxEntries,
+			CleanupInterval: rest.config.CacheCleanupInterval,
+			Logger:          rest.config.Logger,
+		})
 	default:
 		rest.config.Logger.Info("Using in-memory cache")
-		c = inmemory.NewCache(rest.config.Logger)
+		c = inmemory.NewCache(&inmemory.Config{
+			MaxEntries:      maxEntries,
+			CleanupInterval: rest.config.CacheCleanupInterval,
+			Logger:          rest.config.Logger,
+		})
 	}
 
 	return c
 }
+
+// validationCaches are the caches of the validation results, the rejections and the decisions for the fallback.
+type validationCaches struct {
+	validation cache.Cache
+	negative   cache.Cache
+	fallback   cache.Cache
+}
+
+// defineCaches creates the caches closed on stop. The rejections and the decisions for the fallback have their
+// own capacity, so a flood of invalid tokens doesn't evict the valid identities.
+func (rest *Rest) defineCaches() validationCaches {
+	negativeMaxEntries := rest.config.CacheNegativeMaxEntries
+	if negativeMaxEntries <= 0 {
+		negativeMaxEntries = defaultNegativeCacheMaxEntries
+	}
+	caches := validationCaches{
+		validation: rest.defineCache(rest.config.CacheMaxEntries),
+		negative:   rest.defineCache(negativeMaxEntries),
+		fallback:   rest.defineCache(rest.config.CacheFallbackMaxEntries),
+	}
+	rest.caches = []cache.Cache{caches.validation, caches.negative, caches.fallback}
+	return caches
+}
+
+// defineNotifiers creates the webhooks dispatcher and the events hub if they are enabled
+// and returns the notifiers of the room events.
+func (rest *Rest) defineNotifiers() ws.Notifiers {
+	notifiers := make(ws.Notifiers, 0)
+	if rest.config.WebhookURL != "" {
+		rest.config.Logger.Info("Sending room events to the webhook", zap.String("url", rest.config.WebhookURL))
+		rest.webhooks = webhook.NewDispatcher(&webhook.Config{
+			URL:        rest.config.WebhookURL,
+			Secret:     rest.config.WebhookSecret,
+			Events:     rest.config.WebhookEvents,
+			QueueSize:  rest.config.WebhookQueueSize,
+			Workers:    rest.config.WebhookWorkers,
+			MaxRetries: rest.config.WebhookMaxRetries,
+			Timeout:    rest.config.WebhookTimeout,
+			Debounce:   rest.config.WebhookDebounce,
+			Logger:     rest.config.Logger,
+		})
+		notifiers = append(notifiers, rest.webhooks)
+	}
+	if rest.config.EventsToken != "" {
+		rest.events = events.NewHub(rest.config.EventsBufferSize, rest.config.Logger)
+		notifiers = append(notifiers, rest.events)
+	}
+	return notifiers
+}
+
+// defineRateLimits returns the rate limits of the events.
+func (rest *Rest) defineRateLimits() map[string]ws.RateLimit {
+	rateLimits := make(map[string]ws.RateLimit, len(rest.config.RateLimits))
+	for event, limit := range rest.config.RateLimits {
+		rateLimits[event] = ws.RateLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	return rateLimits
+}
+
+// defineUpstream creates the client of the validation URLs. They share the pool of the connections,
+// every host has its own circuit breaker.
+func (rest *Rest) defineUpstream() *upstream.Client {
+	return upstream.NewClient(&upstream.Config{
+		Timeout:          rest.config.ValidationTimeout,
+		Deadline:         rest.config.ValidationDeadline,
+		MaxIdleConns:     rest.config.ValidationMaxIdleConns,
+		MaxRetries:       rest.config.ValidationMaxRetries,
+		RetryBackoff:     rest.config.ValidationRetryBackoff,
+		BreakerThreshold: rest.config.ValidationBreakerThreshold,
+		BreakerCooldown:  rest.config.ValidationBreakerCooldown,
+		Logger:           rest.config.Logger,
+	})
+}
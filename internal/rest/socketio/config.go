@@ -0,0 +1,113 @@
+package socketio
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/ratelimit"
+)
+
+const (
+	defaultPingInterval     = 25
+	defaultPingTimeout      = 20
+	defaultWriteTimeout     = 10
+	defaultMaxMessageSize   = 10 << 20
+	defaultMessageQueueSize = 64
+	defaultMaxViolations    = 10
+	defaultViolationWindow  = 60
+)
+
+// Notifier receives the room events, e.g. to send them to the webhooks. Notify must not block.
+type Notifier interface {
+	Notify(event models.RoomEvent)
+}
+
+// noopNotifier discards the events.
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(models.RoomEvent) {}
+
+type Config struct {
+	// JwtHeaderName is the name of the header of the handshake request with the JWT token
+	JwtHeaderName string
+
+	// AllowAnonymous allows the clients without the JWT token to join the rooms
+	AllowAnonymous bool
+
+	// CheckOrigin reports whether the handshake request comes from an allowed origin
+	CheckOrigin func(r *http.Request) bool
+
+	// PingInterval is the interval between pings sent to the client in seconds
+	PingInterval int64
+
+	// PingTimeout is the time allowed to answer a ping in seconds
+	PingTimeout int64
+
+	// WriteTimeout is the time allowed to write a message to the client in seconds
+	WriteTimeout int64
+
+	// MaxMessageSize is the maximum size of an inbound message in bytes
+	MaxMessageSize int64
+
+	// MessageQueueSize is the number of inbound messages buffered per connection
+	MessageQueueSize int
+
+	// RateLimits is a map of rate limits by event type, it overrides the default limits
+	RateLimits map[string]ratelimit.Limit
+
+	// MaxViolations is the number of limit violations within ViolationWindow after which the connection is dropped
+	MaxViolations int
+
+	// ViolationWindow is the time the limit violations are counted for in seconds
+	ViolationWindow int64
+
+	// Notifier receives the room events, nil discards them
+	Notifier Notifier
+
+	Logger *zap.Logger
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults.
+func (c Config) withDefaults() Config {
+	if c.PingInterval <= 0 {
+		c.PingInterval = defaultPingInterval
+	}
+	if c.PingTimeout <= 0 {
+		c.PingTimeout = defaultPingTimeout
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = defaultWriteTimeout
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.MessageQueueSize <= 0 {
+		c.MessageQueueSize = defaultMessageQueueSize
+	}
+	rateLimits := defaultRateLimits()
+	for event, limit := range c.RateLimits {
+		rateLimits[event] = limit
+	}
+	c.RateLimits = rateLimits
+	if c.MaxViolations <= 0 {
+		c.MaxViolations = defaultMaxViolations
+	}
+	if c.ViolationWindow <= 0 {
+		c.ViolationWindow = defaultViolationWindow
+	}
+	if c.Notifier == nil {
+		c.Notifier = noopNotifier{}
+	}
+	return c
+}
+
+// defaultRateLimits returns the limits used for the events that are not configured.
+func defaultRateLimits() map[string]ratelimit.Limit {
+	return map[string]ratelimit.Limit{
+		EventJoinRoom:                {Rate: 1, Burst: 5},
+		EventServerBroadcast:         {Rate: 30, Burst: 60},
+		EventServerVolatileBroadcast: {Rate: 60, Burst: 120},
+	}
+}
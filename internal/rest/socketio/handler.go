@@ -0,0 +1,684 @@
+package socketio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/codec"
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/ratelimit"
+	"github.com/Icerzack/excaliroom/internal/storage/room"
+	"github.com/Icerzack/excaliroom/internal/storage/user"
+)
+
+// Events of the excalidraw-room protocol.
+const (
+	EventInitRoom                = "init-room"
+	EventJoinRoom                = "join-room"
+	EventFirstInRoom             = "first-in-room"
+	EventNewUser                 = "new-user"
+	EventRoomUserChange          = "room-user-change"
+	EventServerBroadcast         = "server-broadcast"
+	EventServerVolatileBroadcast = "server-volatile-broadcast"
+	EventClientBroadcast         = "client-broadcast"
+)
+
+// accessRevokedReason is the reason of the close frame sent to the clients whose access was invalidated.
+const accessRevokedReason = "access revoked"
+
+// Validator checks the access of the JWT token to the board and returns the user id.
+type Validator interface {
+	ValidateAccess(ctx context.Context, jwt, boardID string) (string, error)
+}
+
+// Handler implements the excalidraw-room Socket.IO protocol used by the Excalidraw collaboration client.
+// Only the websocket transport of Engine.IO v4 is supported.
+type Handler struct {
+	// upgrader is used to upgrade the HTTP connection to a WebSocket connection
+	upgrader *websocket.Upgrader
+
+	// userStorage is used to store the clients by their Socket.IO ids, it must not be shared with the websocket handler
+	userStorage user.Storage
+
+	// roomStorage is used to store the rooms by their ids, it must not be shared with the websocket handler
+	roomStorage room.Storage
+
+	// validator is used to check the access to the rooms
+	validator Validator
+
+	// jwtHeaderName is the name of the header of the handshake request with the JWT token
+	jwtHeaderName string
+
+	// allowAnonymous allows the clients without the JWT token to join the rooms
+	allowAnonymous bool
+
+	// pingInterval is the interval between pings sent to the client
+	pingInterval time.Duration
+
+	// pingTimeout is the time allowed to answer a ping
+	pingTimeout time.Duration
+
+	// writeTimeout is the time allowed to write a message to the client
+	writeTimeout time.Duration
+
+	// maxMessageSize is the maximum size of an inbound message in bytes
+	maxMessageSize int64
+
+	// messageQueueSize is the number of inbound messages buffered per connection
+	messageQueueSize int
+
+	// rateLimits is a map of rate limits by event type
+	rateLimits map[string]ratelimit.Limit
+
+	// maxViolations is the number of limit violations within violationWindow after which the connection is dropped
+	maxViolations int
+
+	// violationWindow is the time the limit violations are counted for
+	violationWindow time.Duration
+
+	// notifier receives the room events
+	notifier Notifier
+
+	// roomsMtx serializes the creation and the removal of the rooms
+	roomsMtx *sync.Mutex
+
+	// userIDs maps the ids of the room users to the validated user ids, so their access can be revoked
+	userIDs map[string]string
+
+	// userIDsMtx guards userIDs
+	userIDsMtx *sync.Mutex
+
+	// connections is a set of the open connections, they are drained on shutdown
+	connections *models.Connections
+
+	// draining is true once the shutdown started, the new connections are rejected
+	draining *atomic.Bool
+
+	logger *zap.Logger
+}
+
+// session is the state of a single Socket.IO client.
+// It is used only by the goroutine processing the connection messages.
+type session struct {
+	// sid is the Socket.IO id of the client, other clients see it in the room events
+	sid string
+
+	conn *models.Connection
+
+	// ctx is canceled when the connection is closed, it limits the validations of the client
+	ctx context.Context
+
+	// limiter tracks the rate limits and the violations of the client
+	limiter *ratelimit.Limiter
+
+	// jwt is the JWT token from the handshake request or the connect packet
+	jwt string
+
+	// userID is the id of the user returned by the validation, it is empty for the anonymous clients
+	userID string
+
+	// connected is true after the client connected to the default namespace
+	connected bool
+
+	// roomID is the id of the room the client joined
+	roomID string
+
+	// pending is the binary event waiting for its attachments
+	pending *packet
+
+	// attachments is the binary frames received for the pending event
+	attachments [][]byte
+}
+
+func NewHandler(
+	usersStorage user.Storage,
+	roomsStorage room.Storage,
+	validator Validator,
+	config *Config,
+) *Handler {
+	cfg := config.withDefaults()
+	return &Handler{
+		upgrader: &websocket.Upgrader{
+			CheckOrigin: cfg.CheckOrigin,
+		},
+		userStorage:      usersStorage,
+		roomStorage:      roomsStorage,
+		validator:        validator,
+		jwtHeaderName:    cfg.JwtHeaderName,
+		allowAnonymous:   cfg.AllowAnonymous,
+		pingInterval:     time.Duration(cfg.PingInterval) * time.Second,
+		pingTimeout:      time.Duration(cfg.PingTimeout) * time.Second,
+		writeTimeout:     time.Duration(cfg.WriteTimeout) * time.Second,
+		maxMessageSize:   cfg.MaxMessageSize,
+		messageQueueSize: cfg.MessageQueueSize,
+		rateLimits:       cfg.RateLimits,
+		maxViolations:    cfg.MaxViolations,
+		violationWindow:  time.Duration(cfg.ViolationWindow) * time.Second,
+		notifier:         cfg.Notifier,
+		roomsMtx:         &sync.Mutex{},
+		userIDs:          make(map[string]string),
+		userIDsMtx:       &sync.Mutex{},
+		connections:      models.NewConnections(),
+		draining:         &atomic.Bool{},
+		logger:           cfg.Logger,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("EIO") != "4" || query.Get("transport") != "websocket" {
+		http.Error(w, `{"code":0,"message":"Transport unknown"}`, http.StatusBadRequest)
+		return
+	}
+	if h.draining.Load() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	wsConn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Error("Failed to upgrade Socket.IO connection", zap.Error(err))
+		return
+	}
+	s := &session{
+		sid:     generateID(),
+		conn:    models.NewConnection(wsConn, codec.NewJSON(), h.writeTimeout, 0),
+		limiter: ratelimit.NewLimiter(h.rateLimits, h.maxViolations, h.violationWindow),
+	}
+	if h.jwtHeaderName != "" {
+		s.jwt = r.Header.Get(h.jwtHeaderName)
+	}
+	defer s.conn.Close()
+	h.connections.Add(s.conn)
+	defer h.connections.Remove(s.conn)
+	h.logger.Info("Socket.IO connection upgraded successfully", zap.String("sid", s.sid))
+
+	// The shutdown could start while the connection was upgraded
+	if h.draining.Load() {
+		_ = s.conn.CloseWithCode(websocket.CloseServiceRestart, "server shutdown")
+		return
+	}
+
+	if err := h.open(s); err != nil {
+		h.logger.Debug("Failed to open Engine.IO session", zap.Error(err))
+		return
+	}
+
+	s.conn.SetReadLimit(h.maxMessageSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(h.pingInterval + h.pingTimeout))
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go h.heartbeat(s.conn, stopHeartbeat)
+
+	// Messages of a single connection are processed in order by one worker,
+	// when the queue is full the read loop blocks like on the websocket endpoint
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s.ctx = ctx
+	queue := make(chan models.Frame, h.messageQueueSize)
+	done := make(chan struct{})
+	go h.processMessages(s, queue, done)
+
+	for {
+		mt, msg, err := s.conn.ReadMessage()
+		if err != nil || mt == websocket.CloseMessage {
+			break
+		}
+		// Any message proves the client is alive
+		_ = s.conn.SetReadDeadline(time.Now().Add(h.pingInterval + h.pingTimeout))
+
+		queue <- models.Frame{Type: mt, Data: msg}
+	}
+
+	cancel()
+	close(queue)
+	<-done
+	h.leaveRoom(s)
+	h.logger.Info("Socket.IO connection closed", zap.String("sid", s.sid))
+}
+
+// Shutdown stops accepting the connections and closes them with the 1012 Service Restart code,
+// the Socket.IO clients reconnect by themselves. It returns when all the clients left their rooms;
+// the connections still open when the context is done are closed abruptly.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+	conns := h.connections.GetAll()
+	h.logger.Info("Draining Socket.IO connections", zap.Int("connections", len(conns)))
+
+	for _, conn := range conns {
+		go func(conn *models.Connection) {
+			_ = conn.CloseWithCode(websocket.CloseServiceRestart, "server shutdown")
+		}(conn)
+	}
+	if err := h.connections.Wait(ctx); err != nil {
+		for _, conn := range h.connections.GetAll() {
+			_ = conn.Close()
+		}
+		return fmt.Errorf("failed to drain Socket.IO connections: %w", err)
+	}
+	return nil
+}
+
+// open sends the Engine.IO handshake.
+func (h *Handler) open(s *session) error {
+	handshake, err := json.Marshal(map[string]interface{}{
+		"sid":          generateID(),
+		"upgrades":     []string{},
+		"pingInterval": h.pingInterval.Milliseconds(),
+		"pingTimeout":  h.pingTimeout.Milliseconds(),
+		"maxPayload":   h.maxMessageSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal handshake: %w", err)
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, append([]byte{engineOpen}, handshake...))
+}
+
+// heartbeat pings the client until stop is closed or the ping can't be written.
+func (h *Handler) heartbeat(conn *models.Connection, stop <-chan struct{}) {
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte{enginePing}); err != nil {
+				// Closing the connection unblocks the read loop which leaves the room
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// processMessages handles the queued messages of the session one by one.
+func (h *Handler) processMessages(s *session, queue <-chan models.Frame, done chan<- struct{}) {
+	defer close(done)
+	closed := false
+	for msg := range queue {
+		if closed {
+			continue
+		}
+		if msg.Type == websocket.BinaryMessage {
+			h.handleAttachment(s, msg.Data)
+			continue
+		}
+		if !h.handleEnginePacket(s, msg.Data) {
+			// Closing the connection unblocks the read loop which leaves the room
+			closed = true
+			_ = s.conn.Close()
+		}
+	}
+}
+
+// handleEnginePacket handles the Engine.IO packet and reports whether the session continues.
+func (h *Handler) handleEnginePacket(s *session, msg []byte) bool {
+	if len(msg) == 0 {
+		return true
+	}
+	switch msg[0] {
+	case engineClose:
+		return false
+	case enginePing:
+		_ = s.conn.WriteMessage(websocket.TextMessage, []byte{enginePong})
+	case engineMessage:
+		h.handlePacket(s, msg[1:])
+	case enginePong, engineNoop:
+	}
+	return true
+}
+
+func (h *Handler) handlePacket(s *session, msg []byte) {
+	p, err := decodePacket(msg)
+	if err != nil {
+		h.logger.Debug("Failed to decode Socket.IO packet", zap.Error(err))
+		return
+	}
+	if p.Namespace != defaultNamespace {
+		h.connectError(s, p.Namespace, "Invalid namespace")
+		return
+	}
+
+	switch p.Type {
+	case packetConnect:
+		h.connect(s, p)
+	case packetDisconnect:
+		h.leaveRoom(s)
+		s.connected = false
+	case packetEvent:
+		if s.connected {
+			h.handleEvent(s, p, nil)
+		}
+	case packetBinaryEvent:
+		if !s.connected {
+			return
+		}
+		if p.Attachments == 0 {
+			h.handleEvent(s, p, nil)
+			return
+		}
+		s.pending = p
+		s.attachments = make([][]byte, 0, p.Attachments)
+	}
+}
+
+// handleAttachment collects the binary frames of the pending event.
+func (h *Handler) handleAttachment(s *session, data []byte) {
+	if s.pending == nil {
+		return
+	}
+	s.attachments = append(s.attachments, data)
+	if len(s.attachments) < s.pending.Attachments {
+		return
+	}
+	p, attachments := s.pending, s.attachments
+	s.pending, s.attachments = nil, nil
+	h.handleEvent(s, p, attachments)
+}
+
+func (h *Handler) connect(s *session, p *packet) {
+	// The token can be passed in the auth payload of the connect packet
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if len(p.Data) > 0 && json.Unmarshal(p.Data, &auth) == nil && auth.Token != "" {
+		s.jwt = auth.Token
+	}
+	if s.jwt == "" && !h.allowAnonymous {
+		h.connectError(s, defaultNamespace, "Unauthorized")
+		return
+	}
+
+	s.connected = true
+	data, _ := json.Marshal(map[string]string{"sid": s.sid})
+	_ = s.conn.WriteMessage(websocket.TextMessage, encodePacket(packetConnect, data))
+	_ = h.emit(s.conn, EventInitRoom, nil, nil)
+}
+
+func (h *Handler) connectError(s *session, namespace, message string) {
+	data, _ := json.Marshal(map[string]string{"message": message})
+	if namespace != defaultNamespace {
+		data = append([]byte(namespace+","), data...)
+	}
+	_ = s.conn.WriteMessage(websocket.TextMessage, encodePacket(packetConnectError, data))
+}
+
+func (h *Handler) handleEvent(s *session, p *packet, attachments [][]byte) {
+	event, args, err := p.Event()
+	if err != nil {
+		h.logger.Debug("Failed to decode Socket.IO event", zap.Error(err))
+		return
+	}
+	if !s.limiter.Allow(event) {
+		h.reportViolation(s, event)
+		return
+	}
+	var roomID string
+	if len(args) > 0 {
+		_ = json.Unmarshal(args[0], &roomID)
+	}
+
+	switch event {
+	case EventJoinRoom:
+		h.joinRoom(s, roomID)
+	case EventServerBroadcast, EventServerVolatileBroadcast:
+		// The payload is encrypted by the clients, it is relayed as is
+		if roomID == "" || roomID != s.roomID {
+			return
+		}
+		currentRoom, _ := h.roomStorage.Get(roomID)
+		if currentRoom == nil {
+			return
+		}
+		h.emitToRoom(currentRoom, s.conn, EventClientBroadcast, args[1:], attachments)
+		if event == EventServerBroadcast {
+			sceneEvent := h.roomEvent(models.RoomEventSceneUpdated, currentRoom, s)
+			sceneEvent.SceneSize = payloadSize(args[1:], attachments)
+			sceneEvent.Encrypted = true
+			h.notifier.Notify(sceneEvent)
+		}
+	default:
+		h.logger.Debug("Unsupported Socket.IO event", zap.String("event", event))
+	}
+}
+
+func (h *Handler) joinRoom(s *session, roomID string) {
+	if roomID == "" || roomID == s.roomID {
+		return
+	}
+	userID := ""
+	if s.jwt != "" || !h.allowAnonymous {
+		var err error
+		if userID, err = h.validator.ValidateAccess(s.ctx, s.jwt, roomID); err != nil {
+			h.logger.Info("Socket.IO client can't join the room", zap.String("roomID", roomID), zap.Error(err))
+			_ = s.conn.WriteMessage(websocket.TextMessage, encodePacket(packetDisconnect, nil))
+			s.connected = false
+			return
+		}
+	}
+	h.leaveRoom(s)
+	s.userID = userID
+
+	// Create a room if it doesn't exist
+	currentRoom := h.acquireRoom(s, roomID)
+	defer currentRoom.Unpin()
+
+	// Store the user
+	newUser := &models.User{
+		ID:     s.sid,
+		RoomID: currentRoom.BoardID,
+		Conn:   s.conn,
+	}
+	if err := h.userStorage.Set(newUser.ID, newUser); err != nil {
+		return
+	}
+	h.userIDsMtx.Lock()
+	h.userIDs[newUser.ID] = userID
+	h.userIDsMtx.Unlock()
+	currentRoom.AddUser(newUser)
+	s.roomID = roomID
+	h.notifier.Notify(h.roomEvent(models.RoomEventUserJoined, currentRoom, s))
+
+	if len(currentRoom.GetUsers()) == 1 {
+		_ = h.emit(s.conn, EventFirstInRoom, nil, nil)
+	} else {
+		sid, _ := json.Marshal(s.sid)
+		h.emitToRoom(currentRoom, s.conn, EventNewUser, []json.RawMessage{sid}, nil)
+	}
+	h.sendRoomUserChange(currentRoom)
+
+	h.logger.Info("Socket.IO user joined the room", zap.String("sid", s.sid), zap.String("roomID", roomID))
+}
+
+func (h *Handler) leaveRoom(s *session) {
+	if s.roomID == "" {
+		return
+	}
+	roomID := s.roomID
+	s.roomID = ""
+
+	_ = h.userStorage.Delete(s.sid)
+	h.userIDsMtx.Lock()
+	delete(h.userIDs, s.sid)
+	h.userIDsMtx.Unlock()
+	currentRoom, _ := h.roomStorage.Get(roomID)
+	if currentRoom == nil {
+		return
+	}
+	currentRoom.RemoveUser(s.sid)
+	h.notifier.Notify(h.roomEvent(models.RoomEventUserLeft, currentRoom, s))
+
+	// Check if the room is empty
+	if h.releaseRoom(s, currentRoom) {
+		return
+	}
+	h.sendRoomUserChange(currentRoom)
+}
+
+// InvalidateAccess disconnects the clients of the user from the room of the board, or all the clients of the room
+// if userID is empty. The cached access is dropped by the validator, the clients are checked again when they join.
+// It returns the number of the disconnected clients.
+func (h *Handler) InvalidateAccess(boardID, userID string, disconnect bool) int {
+	if !disconnect {
+		return 0
+	}
+	currentRoom, _ := h.roomStorage.Get(boardID)
+	if currentRoom == nil {
+		return 0
+	}
+
+	disconnected := 0
+	for _, u := range currentRoom.GetUsers() {
+		h.userIDsMtx.Lock()
+		roomUserID := h.userIDs[u.ID]
+		h.userIDsMtx.Unlock()
+		if userID != "" && roomUserID != userID {
+			continue
+		}
+		// Closing the connection unblocks the read loop which leaves the room
+		_ = u.Conn.WriteMessage(websocket.TextMessage, encodePacket(packetDisconnect, nil))
+		_ = u.Conn.CloseWithCode(websocket.ClosePolicyViolation, accessRevokedReason)
+		disconnected++
+	}
+	return disconnected
+}
+
+// acquireRoom returns the room pinned against the removal, the caller must unpin it.
+func (h *Handler) acquireRoom(s *session, roomID string) *models.Room {
+	h.roomsMtx.Lock()
+	defer h.roomsMtx.Unlock()
+
+	currentRoom, _ := h.roomStorage.Get(roomID)
+	if currentRoom == nil {
+		currentRoom = models.NewRoom(roomID)
+		_ = h.roomStorage.Set(roomID, currentRoom)
+		h.notifier.Notify(h.roomEvent(models.RoomEventRoomCreated, currentRoom, s))
+	}
+	currentRoom.Pin()
+	return currentRoom
+}
+
+// releaseRoom removes the room if it has no users and nobody is joining it. It reports whether the room was removed.
+func (h *Handler) releaseRoom(s *session, currentRoom *models.Room) bool {
+	h.roomsMtx.Lock()
+	defer h.roomsMtx.Unlock()
+
+	if !currentRoom.IsIdle() {
+		return false
+	}
+	_ = h.roomStorage.Delete(currentRoom.BoardID)
+	h.notifier.Notify(h.roomEvent(models.RoomEventRoomClosed, currentRoom, s))
+	return true
+}
+
+// reportViolation drops the connection if it keeps violating the limits.
+// The protocol has no error event, so the rejected message is dropped silently.
+func (h *Handler) reportViolation(s *session, event string) {
+	h.logger.Debug("Socket.IO limit violated", zap.String("sid", s.sid), zap.String("event", event))
+	if s.limiter.Violate() {
+		h.logger.Info("Socket.IO connection dropped for violating the limits", zap.String("sid", s.sid))
+		// Closing the connection unblocks the read loop which leaves the room
+		_ = s.conn.CloseWithCode(websocket.ClosePolicyViolation, "too many violations")
+	}
+}
+
+// roomEvent creates the event of the room caused by the client. The events carry the room id as the board id
+// and the Socket.IO ids of the clients as the user ids; the user id of the client is the validated one if any.
+func (h *Handler) roomEvent(eventType string, currentRoom *models.Room, s *session) models.RoomEvent {
+	userID := s.userID
+	if userID == "" {
+		userID = s.sid
+	}
+	return models.NewRoomEvent(eventType, currentRoom, userID)
+}
+
+// sendRoomUserChange sends the ids of the users in the room to all of them.
+func (h *Handler) sendRoomUserChange(currentRoom *models.Room) {
+	sids := make([]string, 0)
+	for _, u := range currentRoom.GetUsers() {
+		sids = append(sids, u.ID)
+	}
+	data, _ := json.Marshal(sids)
+	h.emitToRoom(currentRoom, nil, EventRoomUserChange, []json.RawMessage{data}, nil)
+}
+
+// emit sends the event to a single connection.
+func (h *Handler) emit(conn *models.Connection, event string, args []json.RawMessage, attachments [][]byte) error {
+	frames, err := eventFrames(event, args, attachments)
+	if err != nil {
+		return err
+	}
+	return conn.WriteFrames(frames...)
+}
+
+// emitToRoom sends the event to all the users in the room except the sender.
+func (h *Handler) emitToRoom(
+	currentRoom *models.Room,
+	sender *models.Connection,
+	event string,
+	args []json.RawMessage,
+	attachments [][]byte,
+) {
+	frames, err := eventFrames(event, args, attachments)
+	if err != nil {
+		h.logger.Error("Failed to encode Socket.IO event", zap.Error(err))
+		return
+	}
+	for _, u := range currentRoom.GetUsers() {
+		if u.Conn == sender {
+			continue
+		}
+		if err := u.Conn.WriteFrames(frames...); err != nil {
+			// Close the connection, the read loop will remove the user from the room
+			_ = u.Conn.Close()
+		}
+	}
+}
+
+// payloadSize returns the size of the event arguments and attachments in bytes.
+func payloadSize(args []json.RawMessage, attachments [][]byte) int {
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	for _, attachment := range attachments {
+		size += len(attachment)
+	}
+	return size
+}
+
+func eventFrames(event string, args []json.RawMessage, attachments [][]byte) ([]models.Frame, error) {
+	data, err := encodeEvent(event, args, len(attachments))
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]models.Frame, 0, len(attachments)+1)
+	frames = append(frames, models.Frame{Type: websocket.TextMessage, Data: data})
+	for _, attachment := range attachments {
+		frames = append(frames, models.Frame{Type: websocket.BinaryMessage, Data: attachment})
+	}
+	return frames, nil
+}
+
+// generateID generates a random id for the Engine.IO and Socket.IO sessions.
+func generateID() string {
+	const idLength = 15
+
+	b := make([]byte, idLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
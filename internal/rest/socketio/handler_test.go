@@ -0,0 +1,323 @@
+package socketio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	inmemRoom "github.com/Icerzack/excaliroom/internal/storage/room/inmemory"
+	inmemUser "github.com/Icerzack/excaliroom/internal/storage/user/inmemory"
+)
+
+const (
+	// testTimeout is the time the tests wait for a packet
+	testTimeout = 5 * time.Second
+
+	testRoomID = "room-1"
+
+	testUserID = "user-1"
+
+	testOtherUserID = "user-2"
+
+	// testForbiddenRoom is the room the test validator denies the access to
+	testForbiddenRoom = "forbidden"
+)
+
+var errNoAccess = errors.New("no access")
+
+// testValidator accepts any token for any room except testForbiddenRoom, the token is the user id.
+type testValidator struct{}
+
+func (testValidator) ValidateAccess(_ context.Context, jwt, boardID string) (string, error) {
+	if boardID == testForbiddenRoom {
+		return "", errNoAccess
+	}
+	return jwt, nil
+}
+
+// newTestServer serves the Socket.IO endpoint of the handler and returns it with the endpoint URL.
+func newTestServer(t *testing.T, cfg Config) (*Handler, string) {
+	t.Helper()
+	logger := zap.NewNop()
+	cfg.Logger = logger
+	h := NewHandler(inmemUser.NewStorage(logger), inmemRoom.NewStorage(logger), testValidator{}, &cfg)
+	server := httptest.NewServer(http.HandlerFunc(h.Handle))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		_ = h.Shutdown(ctx)
+		server.Close()
+	})
+	return h, "ws" + strings.TrimPrefix(server.URL, "http") + "/socket.io/?EIO=4&transport=websocket"
+}
+
+// testClient is a Socket.IO client reading the text packets in the background.
+type testClient struct {
+	conn    *websocket.Conn
+	packets chan string
+
+	// err is the error that ended the reading, it is set when packets is closed
+	err error
+}
+
+// dialTest opens the connection and reads the Engine.IO handshake.
+func dialTest(t *testing.T, url string) *testClient {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+	c := &testClient{conn: conn, packets: make(chan string, 64)}
+	go func() {
+		defer close(c.packets)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				c.err = err
+				return
+			}
+			c.packets <- string(msg)
+		}
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if open := c.next(t); open[0] != engineOpen {
+		t.Fatalf("first packet %s, want the open packet", open)
+	}
+	return c
+}
+
+// closed waits until the connection is closed and returns the error that closed it.
+func (c *testClient) closed(t *testing.T) error {
+	t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case _, ok := <-c.packets:
+			if !ok {
+				return c.err
+			}
+		case <-deadline:
+			t.Fatal("connection not closed in time")
+		}
+	}
+}
+
+// send writes the text packet.
+func (c *testClient) send(t *testing.T, packet string) {
+	t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(packet)); err != nil {
+		t.Fatalf("WriteMessage() unexpected error: %v", err)
+	}
+}
+
+// next returns the next packet, the pings are skipped.
+func (c *testClient) next(t *testing.T) string {
+	t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case packet, ok := <-c.packets:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if packet != string(enginePing) {
+				return packet
+			}
+		case <-deadline:
+			t.Fatal("packet not received in time")
+		}
+	}
+}
+
+// expect checks that the next packet is the expected one.
+func (c *testClient) expect(t *testing.T, want string) {
+	t.Helper()
+	if got := c.next(t); got != want {
+		t.Fatalf("got packet %s, want %s", got, want)
+	}
+}
+
+// connect connects to the default namespace with the token and returns the Socket.IO id.
+func (c *testClient) connect(t *testing.T, token string) string {
+	t.Helper()
+	c.send(t, `40{"token":"`+token+`"}`)
+	var connected struct {
+		Sid string `json:"sid"`
+	}
+	packet := c.next(t)
+	if !strings.HasPrefix(packet, "40") || json.Unmarshal([]byte(packet[2:]), &connected) != nil {
+		t.Fatalf("got packet %s, want the connect packet", packet)
+	}
+	c.expect(t, `42["init-room"]`)
+	return connected.Sid
+}
+
+// join sends the join-room event.
+func (c *testClient) join(t *testing.T, roomID string) {
+	t.Helper()
+	c.send(t, `42["join-room","`+roomID+`"]`)
+}
+
+func TestHandleRejectsUnsupportedTransports(t *testing.T) {
+	h, _ := newTestServer(t, Config{})
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "Engine.IO v3", query: "?EIO=3&transport=websocket"},
+		{name: "polling", query: "?EIO=4&transport=polling"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest(http.MethodGet, "/socket.io/"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestConnectWithoutToken(t *testing.T) {
+	_, url := newTestServer(t, Config{})
+	client := dialTest(t, url)
+
+	client.send(t, "40")
+	client.expect(t, `44{"message":"Unauthorized"}`)
+}
+
+func TestRoomRelay(t *testing.T) {
+	h, url := newTestServer(t, Config{})
+	first := dialTest(t, url)
+	second := dialTest(t, url)
+	firstSid := first.connect(t, testUserID)
+	secondSid := second.connect(t, testOtherUserID)
+
+	first.join(t, testRoomID)
+	first.expect(t, `42["first-in-room"]`)
+	first.expect(t, `42["room-user-change",["`+firstSid+`"]]`)
+
+	second.join(t, testRoomID)
+	first.expect(t, `42["new-user","`+secondSid+`"]`)
+	users := first.next(t)
+	if !strings.Contains(users, firstSid) || !strings.Contains(users, secondSid) {
+		t.Fatalf("got packet %s, want the room users", users)
+	}
+
+	// The rooms are stored by their ids in the storage of the handler
+	currentRoom, _ := h.roomStorage.Get(testRoomID)
+	if currentRoom == nil || len(currentRoom.GetUsers()) != 2 {
+		t.Fatalf("room %s not stored with its users", testRoomID)
+	}
+
+	// The encrypted scene is relayed to the other users only
+	second.send(t, `42["server-broadcast","`+testRoomID+`","scene","iv"]`)
+	first.expect(t, `42["client-broadcast","scene","iv"]`)
+
+	// The last user leaving removes the room
+	_ = first.conn.Close()
+	_ = second.conn.Close()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if currentRoom, _ := h.roomStorage.Get(testRoomID); currentRoom == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s not removed", testRoomID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJoinForbiddenRoom(t *testing.T) {
+	_, url := newTestServer(t, Config{})
+	client := dialTest(t, url)
+	client.connect(t, testUserID)
+
+	client.join(t, testForbiddenRoom)
+	client.expect(t, "41")
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	h, url := newTestServer(t, Config{})
+	client := dialTest(t, url)
+	client.connect(t, testUserID)
+	client.join(t, testRoomID)
+	client.expect(t, `42["first-in-room"]`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() unexpected error: %v", err)
+	}
+
+	// The clients leave their rooms and reconnect by themselves after the 1012 close code
+	if err := client.closed(t); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("connection closed with %v, want %d", err, websocket.CloseServiceRestart)
+	}
+	if currentRoom, _ := h.roomStorage.Get(testRoomID); currentRoom != nil {
+		t.Errorf("room %s not removed", testRoomID)
+	}
+
+	// The new connections are rejected
+	w := httptest.NewRecorder()
+	h.Handle(w, httptest.NewRequest(http.MethodGet, "/socket.io/?EIO=4&transport=websocket", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestInvalidateAccess(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           string
+		disconnect       bool
+		wantDisconnected int
+	}{
+		{name: "without disconnect", userID: testUserID},
+		{name: "user", userID: testUserID, disconnect: true, wantDisconnected: 1},
+		{name: "room", disconnect: true, wantDisconnected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, url := newTestServer(t, Config{})
+			first := dialTest(t, url)
+			second := dialTest(t, url)
+			first.connect(t, testUserID)
+			secondSid := second.connect(t, testOtherUserID)
+			first.join(t, testRoomID)
+			first.expect(t, `42["first-in-room"]`)
+			first.next(t)
+			second.join(t, testRoomID)
+			first.expect(t, `42["new-user","`+secondSid+`"]`)
+			second.next(t)
+
+			if got := h.InvalidateAccess(testRoomID, tt.userID, tt.disconnect); got != tt.wantDisconnected {
+				t.Errorf("InvalidateAccess() = %d, want %d", got, tt.wantDisconnected)
+			}
+			if !tt.disconnect {
+				return
+			}
+
+			// The clients of the user are disconnected, the others stay in the room
+			if err := first.closed(t); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("connection closed with %v, want %d", err, websocket.ClosePolicyViolation)
+			}
+			if tt.userID != "" {
+				second.expect(t, `42["room-user-change",["`+secondSid+`"]]`)
+			}
+		})
+	}
+}
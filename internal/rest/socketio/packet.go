@@ -0,0 +1,126 @@
+package socketio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidPacket = errors.New("invalid packet")
+
+// Engine.IO v4 packet types.
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+	engineNoop    = '6'
+)
+
+// Socket.IO v5 packet types.
+const (
+	packetConnect      = '0'
+	packetDisconnect   = '1'
+	packetEvent        = '2'
+	packetConnectError = '4'
+	packetBinaryEvent  = '5'
+)
+
+const defaultNamespace = "/"
+
+// maxAttachments is the maximum number of binary frames of a single event.
+const maxAttachments = 16
+
+// packet is a decoded Socket.IO packet.
+type packet struct {
+	// Type is the Socket.IO packet type
+	Type byte
+
+	// Namespace is the namespace of the packet, "/" by default
+	Namespace string
+
+	// Attachments is the number of binary frames following a binary packet
+	Attachments int
+
+	// Data is the JSON payload of the packet
+	Data json.RawMessage
+}
+
+// decodePacket decodes the Socket.IO packet carried by the Engine.IO message packet.
+// The Engine.IO packet type must be stripped by the caller.
+func decodePacket(data []byte) (*packet, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidPacket
+	}
+	p := &packet{Type: data[0], Namespace: defaultNamespace}
+	data = data[1:]
+
+	if p.Type == packetBinaryEvent {
+		i := bytes.IndexByte(data, '-')
+		if i < 0 {
+			return nil, fmt.Errorf("missing attachments count: %w", ErrInvalidPacket)
+		}
+		n, err := strconv.Atoi(string(data[:i]))
+		if err != nil || n < 0 || n > maxAttachments {
+			return nil, fmt.Errorf("invalid attachments count: %w", ErrInvalidPacket)
+		}
+		p.Attachments = n
+		data = data[i+1:]
+	}
+
+	if len(data) > 0 && data[0] == '/' {
+		i := bytes.IndexByte(data, ',')
+		if i < 0 {
+			p.Namespace = string(data)
+			return p, nil
+		}
+		p.Namespace = string(data[:i])
+		data = data[i+1:]
+	}
+
+	// Skip the acknowledgement id, the server doesn't acknowledge the events
+	i := 0
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	p.Data = data[i:]
+	return p, nil
+}
+
+// Event returns the event name and the arguments of an event packet.
+func (p *packet) Event() (string, []json.RawMessage, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(p.Data, &args); err != nil || len(args) == 0 {
+		return "", nil, fmt.Errorf("invalid event payload: %w", ErrInvalidPacket)
+	}
+	var event string
+	if err := json.Unmarshal(args[0], &event); err != nil {
+		return "", nil, fmt.Errorf("invalid event name: %w", ErrInvalidPacket)
+	}
+	return event, args[1:], nil
+}
+
+// encodePacket encodes the Socket.IO packet as an Engine.IO message packet.
+func encodePacket(packetType byte, data []byte) []byte {
+	return append([]byte{engineMessage, packetType}, data...)
+}
+
+// encodeEvent encodes the event with the arguments, attachments is the number of binary frames that follow it.
+func encodeEvent(event string, args []json.RawMessage, attachments int) ([]byte, error) {
+	name, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event name: %w", err)
+	}
+	payload, err := json.Marshal(append([]json.RawMessage{name}, args...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if attachments == 0 {
+		return encodePacket(packetEvent, payload), nil
+	}
+	header := strconv.Itoa(attachments) + "-"
+	return encodePacket(packetBinaryEvent, append([]byte(header), payload...)), nil
+}
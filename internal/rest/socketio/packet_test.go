@@ -0,0 +1,120 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDecodePacket(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		wantType        byte
+		wantNamespace   string
+		wantAttachments int
+		wantData        string
+	}{
+		{name: "connect", data: "0", wantType: packetConnect, wantNamespace: defaultNamespace},
+		{
+			name:          "connect with auth",
+			data:          `0{"token":"jwt"}`,
+			wantType:      packetConnect,
+			wantNamespace: defaultNamespace,
+			wantData:      `{"token":"jwt"}`,
+		},
+		{name: "namespace without data", data: "0/admin", wantType: packetConnect, wantNamespace: "/admin"},
+		{
+			name:          "event with namespace",
+			data:          `2/admin,["join-room","room"]`,
+			wantType:      packetEvent,
+			wantNamespace: "/admin",
+			wantData:      `["join-room","room"]`,
+		},
+		{
+			name:          "event with acknowledgement id",
+			data:          `212["join-room","room"]`,
+			wantType:      packetEvent,
+			wantNamespace: defaultNamespace,
+			wantData:      `["join-room","room"]`,
+		},
+		{
+			name:            "binary event",
+			data:            `52-["server-broadcast","room",{"_placeholder":true,"num":0}]`,
+			wantType:        packetBinaryEvent,
+			wantNamespace:   defaultNamespace,
+			wantAttachments: 2,
+			wantData:        `["server-broadcast","room",{"_placeholder":true,"num":0}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := decodePacket([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("decodePacket() unexpected error: %v", err)
+			}
+			if p.Type != tt.wantType || p.Namespace != tt.wantNamespace ||
+				p.Attachments != tt.wantAttachments || string(p.Data) != tt.wantData {
+				t.Errorf("decodePacket() = type %c, namespace %s, attachments %d, data %s, "+
+					"want type %c, namespace %s, attachments %d, data %s",
+					p.Type, p.Namespace, p.Attachments, p.Data,
+					tt.wantType, tt.wantNamespace, tt.wantAttachments, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestDecodePacketRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "binary event without attachments count", data: `5["server-broadcast"]`},
+		{name: "binary event with invalid attachments count", data: `5x-["server-broadcast"]`},
+		{name: "binary event with too many attachments", data: `517-["server-broadcast"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePacket([]byte(tt.data)); !errors.Is(err, ErrInvalidPacket) {
+				t.Errorf("decodePacket() error = %v, want %v", err, ErrInvalidPacket)
+			}
+		})
+	}
+}
+
+func TestEncodeEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		attachments int
+		want        string
+	}{
+		{name: "text event", want: `42["client-broadcast","data"]`},
+		{name: "binary event", attachments: 1, want: `451-["client-broadcast","data"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeEvent(EventClientBroadcast, []json.RawMessage{json.RawMessage(`"data"`)}, tt.attachments)
+			if err != nil {
+				t.Fatalf("encodeEvent() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encodeEvent() = %s, want %s", got, tt.want)
+			}
+
+			// The encoded event decodes back to the same event
+			p, err := decodePacket(got[1:])
+			if err != nil {
+				t.Fatalf("decodePacket() unexpected error: %v", err)
+			}
+			event, args, err := p.Event()
+			if err != nil || event != EventClientBroadcast || len(args) != 1 || p.Attachments != tt.attachments {
+				t.Errorf("Event() = %s with %d args, %v, want %s with 1 arg",
+					event, len(args), err, EventClientBroadcast)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/cache"
+	"github.com/Icerzack/excaliroom/internal/rest/ws"
+)
+
+// WebSocketStats returns the counters of the websocket endpoint.
+type WebSocketStats interface {
+	Stats() ws.Stats
+}
+
+// statsHandler serves the counters of the server to the backend.
+type statsHandler struct {
+	websocket WebSocketStats
+
+	// caches is a map of the caches by their name in the response
+	caches map[string]cache.Cache
+
+	token  string
+	logger *zap.Logger
+}
+
+//nolint:tagliatelle
+type statsResponse struct {
+	Connections     int                   `json:"connections"`
+	RejectedOrigins uint64                `json:"rejected_origins"`
+	Caches          map[string]cacheStats `json:"caches"`
+}
+
+type cacheStats struct {
+	Items       int    `json:"items"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+func newStatsHandler(
+	websocket WebSocketStats,
+	caches map[string]cache.Cache,
+	token string,
+	logger *zap.Logger,
+) *statsHandler {
+	return &statsHandler{
+		websocket: websocket,
+		caches:    caches,
+		token:     token,
+		logger:    logger,
+	}
+}
+
+// stats responds with the counters of the websocket endpoint and of the caches.
+func (h *statsHandler) stats(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.token) {
+		return
+	}
+
+	wsStats := h.websocket.Stats()
+	response := statsResponse{
+		Connections:     wsStats.Connections,
+		RejectedOrigins: wsStats.RejectedOrigins,
+		Caches:          make(map[string]cacheStats, len(h.caches)),
+	}
+	for name, c := range h.caches {
+		s := c.Stats()
+		response.Caches[name] = cacheStats{
+			Items:       c.Len(),
+			Hits:        s.Hits,
+			Misses:      s.Misses,
+			Evictions:   s.Evictions,
+			Expirations: s.Expirations,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Debug("Failed to write the stats", zap.Error(err))
+	}
+}
@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/cache"
+	"github.com/Icerzack/excaliroom/internal/cache/inmemory"
+	"github.com/Icerzack/excaliroom/internal/rest/ws"
+)
+
+// testAdminToken is the bearer token of the admin endpoints in the tests
+const testAdminToken = "secret"
+
+// setAdminToken sets the bearer token of the admin endpoints, an empty token is not set.
+func setAdminToken(r *http.Request, token string) {
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+type fakeWebSocketStats ws.Stats
+
+func (f fakeWebSocketStats) Stats() ws.Stats {
+	return ws.Stats(f)
+}
+
+func TestStatsHandler(t *testing.T) {
+	c := inmemory.NewCache(&inmemory.Config{Logger: zap.NewNop()})
+	t.Cleanup(func() { _ = c.Close() })
+	_ = c.Set("key", "value")
+	_, _ = c.Get("key")
+	_, _ = c.Get("missing")
+
+	h := newStatsHandler(
+		fakeWebSocketStats{Connections: 2, RejectedOrigins: 3},
+		map[string]cache.Cache{"validation": c},
+		testAdminToken,
+		zap.NewNop(),
+	)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "valid token", token: testAdminToken, wantStatus: http.StatusOK},
+		{name: "invalid token", token: "invalid", wantStatus: http.StatusUnauthorized},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			setAdminToken(r, tt.token)
+			w := httptest.NewRecorder()
+			h.stats(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response statsResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			want := statsResponse{
+				Connections:     2,
+				RejectedOrigins: 3,
+				Caches:          map[string]cacheStats{"validation": {Items: 1, Hits: 1, Misses: 1}},
+			}
+			if response.Connections != want.Connections || response.RejectedOrigins != want.RejectedOrigins ||
+				response.Caches["validation"] != want.Caches["validation"] {
+				t.Errorf("stats = %+v, want %+v", response, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// maxEmojiLength is the maximum length of a reaction in bytes, enough for the emoji ZWJ sequences.
+const maxEmojiLength = 64
+
+// chatLength returns the length of the text carried by the message in characters. The length of the encrypted
+// text is unknown, its payload counts as the characters of utf8.UTFMax bytes.
+func chatLength(message EventMessage) int {
+	v, ok := message.(MessageChatRequest)
+	if !ok {
+		return 0
+	}
+	if v.Data != nil {
+		return len(v.Data.Payload) / utf8.UTFMax
+	}
+	return utf8.RuneCountInString(v.Text)
+}
+
+// sendChatMessage keeps the message in the chat history of the room and relays it to the users in the room.
+func (ws *WebSocketHandler) sendChatMessage(ctx context.Context, conn *models.Connection, request MessageChatRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	// Check if user belongs to the room
+	u, _ := ws.userStorage.Get(userID)
+	if u == nil || u.RoomID != request.BoardID {
+		return
+	}
+
+	// Get the room
+	currentRoom, _ := ws.roomStorage.Get(request.BoardID)
+	if currentRoom == nil {
+		return
+	}
+
+	// The server never sees the plain text of the encrypted rooms, their messages are relayed as is
+	var message models.ChatMessage
+	if currentRoom.Encrypted {
+		if request.Text != "" || request.Data == nil || len(request.Data.Payload) == 0 || len(request.Data.IV) == 0 {
+			ws.sendError(conn, ErrorCodeInvalidPayload, "encrypted message payload and iv are required")
+			return
+		}
+		message = models.NewEncryptedChatMessage(userID, &models.EncryptedText{
+			Payload: request.Data.Payload,
+			IV:      request.Data.IV,
+		})
+	} else {
+		if request.Data != nil || strings.TrimSpace(request.Text) == "" || !utf8.ValidString(request.Text) {
+			ws.sendError(conn, ErrorCodeInvalidPayload, "text must be a non-empty UTF-8 string")
+			return
+		}
+		message = models.NewChatMessage(userID, request.Text)
+	}
+	currentRoom.AddChatMessage(message, ws.chatHistorySize)
+
+	ws.logger.Debug("Chat message sent", zap.String("userID", userID), zap.String("boardID", request.BoardID))
+
+	ws.broadcastToRoom(currentRoom, MessageChatResponse{
+		Message: Message{
+			Event: EventChatMessage,
+		},
+		BoardID:   request.BoardID,
+		ID:        message.ID,
+		UserID:    message.UserID,
+		Text:      message.Text,
+		Data:      request.Data,
+		Timestamp: message.Timestamp,
+	})
+}
+
+// sendReaction relays the reaction to the users in the room, the reactions are not kept.
+func (ws *WebSocketHandler) sendReaction(ctx context.Context, conn *models.Connection, request MessageReactionRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	// Check if user belongs to the room
+	u, _ := ws.userStorage.Get(userID)
+	if u == nil || u.RoomID != request.BoardID {
+		return
+	}
+
+	// Get the room
+	currentRoom, _ := ws.roomStorage.Get(request.BoardID)
+	if currentRoom == nil {
+		return
+	}
+
+	if request.Emoji == "" || len(request.Emoji) > maxEmojiLength || !utf8.ValidString(request.Emoji) {
+		ws.sendError(conn, ErrorCodeInvalidPayload, "emoji must be a non-empty UTF-8 string")
+		return
+	}
+
+	ws.broadcastToRoom(currentRoom, MessageReactionResponse{
+		Message: Message{
+			Event: EventReaction,
+		},
+		BoardID:   request.BoardID,
+		UserID:    userID,
+		Emoji:     request.Emoji,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// sendChatHistory sends the recent chat messages of the room to the user.
+func (ws *WebSocketHandler) sendChatHistory(conn *models.Connection, currentRoom *models.Room) {
+	messages := currentRoom.GetChatMessages()
+	if len(messages) == 0 {
+		return
+	}
+	_ = conn.Send(MessageChatHistoryResponse{
+		Message: Message{
+			Event: EventChatHistory,
+		},
+		BoardID:  currentRoom.BoardID,
+		Messages: messages,
+	})
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testEmoji is the reaction sent in the tests
+const testEmoji = "👍"
+
+func newChatRequest(userID, boardID, text string) MessageChatRequest {
+	return MessageChatRequest{
+		Message: Message{Event: EventChatMessage},
+		BoardID: boardID,
+		Jwt:     userID,
+		Text:    text,
+	}
+}
+
+func newReactionRequest(userID, boardID, emoji string) MessageReactionRequest {
+	return MessageReactionRequest{
+		Message: Message{Event: EventReaction},
+		BoardID: boardID,
+		Jwt:     userID,
+		Emoji:   emoji,
+	}
+}
+
+func TestChatLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		message EventMessage
+		want    int
+	}{
+		{name: "plain text", message: newChatRequest(testUserID, testBoardID, "héllo"), want: 5},
+		{
+			name: "encrypted text",
+			message: MessageChatRequest{
+				Message: Message{Event: EventChatMessage},
+				Data:    &EncryptedData{Payload: make([]byte, 20)},
+			},
+			want: 5,
+		},
+		{name: "other message", message: newReactionRequest(testUserID, testBoardID, testEmoji), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chatLength(tt.message); got != tt.want {
+				t.Errorf("chatLength() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatRelay(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{ChatHistorySize: 2})
+	url := newTestServer(t, ws)
+	sender := dialTest(t, url, nil)
+	other := dialTest(t, url, nil)
+	sender.connect(t, testUserID, testBoardID)
+	other.connect(t, testOtherUserID, testBoardID)
+
+	texts := []string{"first", "second", "third"}
+	for _, text := range texts {
+		sender.send(t, newChatRequest(testUserID, testBoardID, text))
+		var response MessageChatResponse
+		other.expect(t, EventChatMessage, &response)
+		if response.Text != text || response.UserID != testUserID || response.ID == "" {
+			t.Errorf("got %q from %s, want %q from %s", response.Text, response.UserID, text, testUserID)
+		}
+	}
+
+	// The users joining later get the last messages of the history
+	late := dialTest(t, url, nil)
+	late.connect(t, testThirdUserID, testBoardID)
+	var history MessageChatHistoryResponse
+	late.expect(t, EventChatHistory, &history)
+	if len(history.Messages) != 2 || history.Messages[0].Text != texts[1] || history.Messages[1].Text != texts[2] {
+		t.Errorf("history %+v, want the last 2 messages", history.Messages)
+	}
+}
+
+func TestChatEncrypted(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	sender := dialTest(t, url, nil)
+	other := dialTest(t, url, nil)
+	sender.connectEncrypted(t, testUserID, testBoardID)
+	other.connectEncrypted(t, testOtherUserID, testBoardID)
+
+	// The encrypted text is relayed as is
+	data := &EncryptedData{Payload: []byte{0, 1, 2, 0xff}, IV: []byte("iv")}
+	request := newChatRequest(testUserID, testBoardID, "")
+	request.Data = data
+	sender.send(t, request)
+	var response MessageChatResponse
+	other.expect(t, EventChatMessage, &response)
+	if response.Data == nil || !bytes.Equal(response.Data.Payload, data.Payload) || response.Text != "" {
+		t.Errorf("got %q with %v, want the encrypted payload only", response.Text, response.Data)
+	}
+
+	// The plain text can't be sent to the encrypted room
+	sender.send(t, newChatRequest(testUserID, testBoardID, "plain"))
+	sender.expectError(t, ErrorCodeInvalidPayload)
+}
+
+func TestChatRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  interface{}
+		wantCode string
+	}{
+		{name: "empty text", request: newChatRequest(testUserID, testBoardID, " "), wantCode: ErrorCodeInvalidPayload},
+		{
+			name:     "text too long",
+			request:  newChatRequest(testUserID, testBoardID, strings.Repeat("a", 6)),
+			wantCode: ErrorCodeMessageTooLong,
+		},
+		{
+			name:     "empty reaction",
+			request:  newReactionRequest(testUserID, testBoardID, ""),
+			wantCode: ErrorCodeInvalidPayload,
+		},
+		{
+			name:     "reaction too long",
+			request:  newReactionRequest(testUserID, testBoardID, strings.Repeat(testEmoji, 17)),
+			wantCode: ErrorCodeInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), Config{MaxChatMessageLength: 5})
+			client := dialTest(t, newTestServer(t, ws), nil)
+			client.connect(t, testUserID, testBoardID)
+			client.send(t, tt.request)
+			client.expectError(t, tt.wantCode)
+		})
+	}
+}
+
+func TestReaction(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	sender := dialTest(t, url, nil)
+	other := dialTest(t, url, nil)
+	sender.connect(t, testUserID, testBoardID)
+	other.connect(t, testOtherUserID, testBoardID)
+
+	sender.send(t, newReactionRequest(testUserID, testBoardID, testEmoji))
+	var response MessageReactionResponse
+	other.expect(t, EventReaction, &response)
+	if response.Emoji != testEmoji || response.UserID != testUserID {
+		t.Errorf("got %s from %s, want %s from %s", response.Emoji, response.UserID, testEmoji, testUserID)
+	}
+}
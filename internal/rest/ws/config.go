@@ -0,0 +1,298 @@
+package ws
+
+import (
+	"compress/flate"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/cache"
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot"
+	"github.com/Icerzack/excaliroom/internal/upstream"
+)
+
+// Policies of the validation while the validation URLs are unavailable.
+const (
+	ValidationFallbackDeny  = "deny"
+	ValidationFallbackCache = "cache"
+)
+
+// Policies of the users joining a full room.
+const (
+	RoomOverflowReject   = "reject"
+	RoomOverflowSpectate = "spectate"
+)
+
+const (
+	defaultMessageQueueSize      = 64
+	defaultMaxConcurrentHandlers = 1024
+	defaultPongWait              = 60
+	defaultWriteTimeout          = 10
+	defaultMaxMessageSize        = 10 << 20
+	defaultMaxSceneSize          = 8 << 20
+	defaultMaxViolations         = 10
+	defaultViolationWindow       = 60
+	defaultCompressionLevel      = 1
+	defaultCompressionThreshold  = 1024
+	defaultReconnectAfter        = 5
+	defaultResumeGracePeriod     = 30
+	defaultResumeHistorySize     = 256
+	defaultNegativeCacheTTL      = 5
+	defaultAccessCacheTTL        = 60
+	defaultFallbackCacheTTL      = 3600
+	defaultFilesTTL              = 7 * 24 * 3600
+	defaultChatHistorySize       = 50
+	defaultMaxChatMessageLength  = 2000
+)
+
+type Config struct {
+	// JwtHeaderName is the name of the header that will be used to pass the JWT token
+	JwtHeaderName string
+
+	// JwtValidationURL is the URL that will be used to validate the JWT token
+	JwtValidationURL string
+
+	// BoardValidationURL is the URL that will be used to validate the board access
+	BoardValidationURL string
+
+	// AllowedOrigins is the list of origins allowed to open a websocket connection,
+	// "*.example.com" allows any subdomain of example.com; empty allows the same origin only
+	AllowedOrigins []string
+
+	// CacheTTL is the time to live of the cache in seconds
+	CacheTTL int64
+
+	// AccessCacheTTL is the time to live of the cached access of the users to the boards in seconds
+	AccessCacheTTL int64
+
+	// Upstream is the client of the validation URLs, nil creates a client with the default settings
+	Upstream *upstream.Client
+
+	// ValidationFallback is the policy of the validation while the validation URLs are unavailable,
+	// ValidationFallbackDeny or ValidationFallbackCache
+	ValidationFallback string
+
+	// FallbackCacheTTL is the time the last decisions are kept for ValidationFallbackCache in seconds
+	FallbackCacheTTL int64
+
+	// NegativeCacheTTL is the time to live of the cached rejections in seconds, a negative value disables them
+	NegativeCacheTTL int64
+
+	// NegativeCache keeps the rejections apart from the valid identities, so a flood of invalid tokens
+	// doesn't evict them; nil keeps the rejections in the cache of the handler
+	NegativeCache cache.Cache
+
+	// FallbackCache keeps the decisions for ValidationFallbackCache apart from the cached validation results,
+	// nil keeps them in the cache of the handler
+	FallbackCache cache.Cache
+
+	// MessageQueueSize is the number of inbound messages buffered per connection
+	// before the server stops reading from the socket
+	MessageQueueSize int
+
+	// MaxConcurrentHandlers is the number of messages processed at the same time across the server
+	MaxConcurrentHandlers int
+
+	// PingInterval is the interval between pings sent to the peer in seconds
+	PingInterval int64
+
+	// PongWait is the time allowed to read the next pong message from the peer in seconds
+	PongWait int64
+
+	// WriteTimeout is the time allowed to write a message to the peer in seconds
+	WriteTimeout int64
+
+	// MaxMessageSize is the maximum size of an inbound message in bytes
+	MaxMessageSize int64
+
+	// MaxSceneSize is the maximum size of the room scene (elements and app state) in bytes
+	MaxSceneSize int
+
+	// MaxElements is the maximum number of the elements in the room scene
+	MaxElements int
+
+	// MaxTextLength is the maximum length of the text of an element in characters
+	MaxTextLength int
+
+	// RateLimits is a map of rate limits by event type, it overrides the default limits
+	RateLimits map[string]RateLimit
+
+	// MaxViolations is the number of limit violations within ViolationWindow after which the connection is dropped
+	MaxViolations int
+
+	// ViolationWindow is the time the limit violations are counted for in seconds
+	ViolationWindow int64
+
+	// EnableCompression enables the permessage-deflate negotiation with the peers
+	EnableCompression bool
+
+	// CompressionLevel is the flate compression level from -2 to 9, zero is the default level,
+	// so the messages are sent uncompressed by disabling EnableCompression instead of flate.NoCompression
+	CompressionLevel int
+
+	// CompressionThreshold is the minimum size of an outbound message in bytes that is compressed
+	CompressionThreshold int
+
+	// KeepEncryptedScenes keeps the last encrypted scene of the room and sends it to the new users
+	KeepEncryptedScenes bool
+
+	// MaxRoomUsers is the maximum number of the participants of a room, zero means unlimited.
+	// The board validation response can set the limit of the board.
+	MaxRoomUsers int
+
+	// RoomOverflow is the policy of the users joining a full room, RoomOverflowReject or RoomOverflowSpectate
+	RoomOverflow string
+
+	// ResumeGracePeriod is the time a disconnected user keeps the membership and the leadership
+	// waiting for the session resume in seconds, a negative value disables the resume
+	ResumeGracePeriod int64
+
+	// ResumeHistorySize is the number of the last events of a room kept for the resumed sessions
+	ResumeHistorySize int
+
+	// ChatHistorySize is the number of the last chat messages of a room sent to the new users
+	ChatHistorySize int
+
+	// MaxChatMessageLength is the maximum length of a chat message in characters
+	MaxChatMessageLength int
+
+	// MaxScenesSize is the memory budget of the room scenes across the server in bytes, zero means unlimited.
+	// The idle rooms are evicted, the least recently active first, when the budget is exceeded.
+	MaxScenesSize int64
+
+	// FilesTTL is the time the files are kept after they were last stored or read in seconds,
+	// a negative value disables the expiry
+	FilesTTL int64
+
+	// Notifier receives the room events, nil discards them
+	Notifier Notifier
+
+	// SnapshotStorage keeps the room scenes between the restarts, nil disables the snapshots
+	SnapshotStorage snapshot.Storage
+
+	// ReconnectAfter is the minimum delay before the clients reconnect after the shutdown in seconds
+	ReconnectAfter int64
+
+	Logger *zap.Logger
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults.
+func (c Config) withDefaults() Config {
+	c.setCacheDefaults()
+	c.setHeartbeatDefaults()
+	c.setLimitsDefaults()
+	c.setHistoryDefaults()
+	c.setFilesDefaults()
+	if c.Notifier == nil {
+		c.Notifier = noopNotifier{}
+	}
+	return c
+}
+
+// setCacheDefaults sets the defaults of the validations and of their caches.
+func (c *Config) setCacheDefaults() {
+	if c.AccessCacheTTL <= 0 {
+		c.AccessCacheTTL = defaultAccessCacheTTL
+	}
+	if c.Upstream == nil {
+		c.Upstream = upstream.NewClient(&upstream.Config{Logger: c.Logger})
+	}
+	if c.ValidationFallback != ValidationFallbackCache {
+		c.ValidationFallback = ValidationFallbackDeny
+	}
+	if c.FallbackCacheTTL <= 0 {
+		c.FallbackCacheTTL = defaultFallbackCacheTTL
+	}
+	if c.NegativeCacheTTL < 0 {
+		c.NegativeCacheTTL = 0
+	} else if c.NegativeCacheTTL == 0 {
+		c.NegativeCacheTTL = defaultNegativeCacheTTL
+	}
+}
+
+// setHeartbeatDefaults sets the defaults of the connections: the queues, the heartbeat, the writes
+// and the reconnection after the shutdown.
+func (c *Config) setHeartbeatDefaults() {
+	if c.MessageQueueSize <= 0 {
+		c.MessageQueueSize = defaultMessageQueueSize
+	}
+	if c.MaxConcurrentHandlers <= 0 {
+		c.MaxConcurrentHandlers = defaultMaxConcurrentHandlers
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = defaultPongWait
+	}
+	// Pings must be sent more often than pongs are awaited
+	if c.PingInterval <= 0 || c.PingInterval >= c.PongWait {
+		c.PingInterval = max(c.PongWait*9/10, 1)
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = defaultWriteTimeout
+	}
+	if c.CompressionLevel == flate.NoCompression ||
+		c.CompressionLevel < flate.HuffmanOnly || c.CompressionLevel > flate.BestCompression {
+		c.CompressionLevel = defaultCompressionLevel
+	}
+	if c.CompressionThreshold <= 0 {
+		c.CompressionThreshold = defaultCompressionThreshold
+	}
+	if c.ReconnectAfter <= 0 {
+		c.ReconnectAfter = defaultReconnectAfter
+	}
+}
+
+// setLimitsDefaults sets the defaults of the limits of the messages and of the rooms.
+func (c *Config) setLimitsDefaults() {
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.MaxSceneSize <= 0 {
+		c.MaxSceneSize = defaultMaxSceneSize
+	}
+	rateLimits := defaultRateLimits()
+	for event, limit := range c.RateLimits {
+		rateLimits[event] = limit
+	}
+	c.RateLimits = rateLimits
+	if c.MaxViolations <= 0 {
+		c.MaxViolations = defaultMaxViolations
+	}
+	if c.ViolationWindow <= 0 {
+		c.ViolationWindow = defaultViolationWindow
+	}
+	if c.MaxRoomUsers < 0 {
+		c.MaxRoomUsers = 0
+	}
+	if c.RoomOverflow != RoomOverflowSpectate {
+		c.RoomOverflow = RoomOverflowReject
+	}
+	if c.MaxChatMessageLength <= 0 {
+		c.MaxChatMessageLength = defaultMaxChatMessageLength
+	}
+}
+
+// setHistoryDefaults sets the defaults of the session resume and of the chat history.
+func (c *Config) setHistoryDefaults() {
+	if c.ResumeGracePeriod < 0 {
+		c.ResumeGracePeriod = 0
+	} else if c.ResumeGracePeriod == 0 {
+		c.ResumeGracePeriod = defaultResumeGracePeriod
+	}
+	if c.ResumeHistorySize <= 0 {
+		c.ResumeHistorySize = defaultResumeHistorySize
+	}
+	if c.ChatHistorySize <= 0 {
+		c.ChatHistorySize = defaultChatHistorySize
+	}
+}
+
+// setFilesDefaults sets the defaults of the board files and of the memory budget of the scenes.
+func (c *Config) setFilesDefaults() {
+	if c.MaxScenesSize < 0 {
+		c.MaxScenesSize = 0
+	}
+	if c.FilesTTL < 0 {
+		c.FilesTTL = 0
+	} else if c.FilesTTL == 0 {
+		c.FilesTTL = defaultFilesTTL
+	}
+}
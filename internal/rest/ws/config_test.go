@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"compress/flate"
+	"testing"
+)
+
+func TestConfigPingInterval(t *testing.T) {
+	tests := []struct {
+		name         string
+		pingInterval int64
+		pongWait     int64
+		wantInterval int64
+		wantWait     int64
+	}{
+		{name: "defaults", wantInterval: 54, wantWait: defaultPongWait},
+		{name: "configured", pingInterval: 10, pongWait: 30, wantInterval: 10, wantWait: 30},
+		{name: "interval not shorter than the wait", pingInterval: 30, pongWait: 30, wantInterval: 27, wantWait: 30},
+		{name: "short wait", pongWait: 1, wantInterval: 1, wantWait: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{PingInterval: tt.pingInterval, PongWait: tt.pongWait}.withDefaults()
+			if cfg.PingInterval != tt.wantInterval || cfg.PongWait != tt.wantWait {
+				t.Errorf("withDefaults() = interval %d, wait %d, want interval %d, wait %d",
+					cfg.PingInterval, cfg.PongWait, tt.wantInterval, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestConfigCompressionLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		want  int
+	}{
+		{name: "default", level: 0, want: defaultCompressionLevel},
+		{name: "huffman only", level: flate.HuffmanOnly, want: flate.HuffmanOnly},
+		{name: "default compression", level: flate.DefaultCompression, want: flate.DefaultCompression},
+		{name: "best compression", level: flate.BestCompression, want: flate.BestCompression},
+		{name: "too low", level: -3, want: defaultCompressionLevel},
+		{name: "too high", level: 10, want: defaultCompressionLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Config{CompressionLevel: tt.level}).withDefaults().CompressionLevel; got != tt.want {
+				t.Errorf("withDefaults() = level %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
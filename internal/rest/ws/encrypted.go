@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// sendEncryptedDataToRoom relays the scene encrypted by the leader to all the users in the room.
+// The server doesn't read the payload, the access is checked the same way as for the plain scenes.
+func (ws *WebSocketHandler) sendEncryptedDataToRoom(ctx context.Context, request MessageNewEncryptedDataRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	// Check if user belongs to the room
+	u, _ := ws.userStorage.Get(userID)
+	if u == nil || u.RoomID != request.BoardID {
+		return
+	}
+
+	// Get the room
+	currentRoom, _ := ws.roomStorage.Get(request.BoardID)
+	if currentRoom == nil {
+		return
+	}
+
+	currentRoom.RoomMutex.Lock()
+	defer currentRoom.RoomMutex.Unlock()
+
+	// Check if the user is the leader
+	if currentRoom.LeaderID != userID {
+		return
+	}
+
+	// The plain rooms accept only the plain scenes
+	if !currentRoom.Encrypted {
+		ws.sendError(u.Conn, ErrorCodeRoomModeMismatch, "the room accepts only plain data")
+		return
+	}
+	if len(request.Data.Payload) == 0 || len(request.Data.IV) == 0 {
+		ws.sendError(u.Conn, ErrorCodeInvalidPayload, "payload and iv are required")
+		return
+	}
+
+	// Keep the scene for the users connecting later
+	if ws.keepEncryptedScenes {
+		currentRoom.SetEncryptedScene(&models.EncryptedScene{
+			Payload: request.Data.Payload,
+			IV:      request.Data.IV,
+		})
+	}
+
+	revision := currentRoom.NextRevision()
+
+	ws.logger.Debug("Encrypted data relayed", zap.String("userID", userID), zap.String("boardID", currentRoom.BoardID))
+	ws.notifier.Notify(models.NewEncryptedSceneUpdatedEvent(currentRoom, userID, &models.EncryptedScene{
+		Payload: request.Data.Payload,
+		IV:      request.Data.IV,
+	}))
+
+	// Send the encrypted data to all the users in the room
+	ws.broadcastToRoom(currentRoom, MessageNewEncryptedDataResponse{
+		Message: Message{
+			Event: EventNewEncryptedData,
+		},
+		BoardID:  currentRoom.BoardID,
+		Data:     request.Data,
+		Revision: revision,
+	})
+	ws.checkMemoryBudget()
+}
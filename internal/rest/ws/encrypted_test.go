@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// connectEncrypted joins the encrypted room of the board as the user and waits until the user is connected.
+func (c *testClient) connectEncrypted(t *testing.T, userID, boardID string) {
+	t.Helper()
+	c.send(t, MessageConnectRequest{
+		Message:   Message{Event: EventConnect},
+		BoardID:   boardID,
+		Jwt:       userID,
+		Encrypted: true,
+	})
+	c.expect(t, EventUserConnected, nil)
+}
+
+func newEncryptedDataRequest(userID, boardID string, data EncryptedData) MessageNewEncryptedDataRequest {
+	return MessageNewEncryptedDataRequest{
+		Message: Message{Event: EventNewEncryptedData},
+		BoardID: boardID,
+		Jwt:     userID,
+		Data:    data,
+	}
+}
+
+func TestEncryptedRoomRelay(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	leader := dialTest(t, url, nil)
+	other := dialTest(t, url, nil)
+	leader.connectEncrypted(t, testUserID, testBoardID)
+	other.connectEncrypted(t, testOtherUserID, testBoardID)
+
+	// The payload is relayed as is, the server can't read it
+	data := EncryptedData{Payload: []byte{0, 1, 2, 0xff}, IV: []byte("iv")}
+	leader.setLeader(t, testUserID, testBoardID)
+	leader.send(t, newEncryptedDataRequest(testUserID, testBoardID, data))
+
+	var response MessageNewEncryptedDataResponse
+	other.expect(t, EventNewEncryptedData, &response)
+	if !bytes.Equal(response.Data.Payload, data.Payload) || !bytes.Equal(response.Data.IV, data.IV) ||
+		response.Revision != 1 {
+		t.Errorf("got %v with revision %d, want %v with revision 1", response.Data, response.Revision, data)
+	}
+
+	// The scene isn't kept by default, so the users connecting later wait for the next update
+	late := dialTest(t, url, nil)
+	late.connectEncrypted(t, testThirdUserID, testBoardID)
+	late.expectNone(t, EventNewEncryptedData, 100*time.Millisecond)
+}
+
+func TestKeepEncryptedScenes(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{KeepEncryptedScenes: true})
+	url := newTestServer(t, ws)
+	leader := dialTest(t, url, nil)
+	leader.connectEncrypted(t, testUserID, testBoardID)
+
+	data := EncryptedData{Payload: []byte("scene"), IV: []byte("iv")}
+	leader.setLeader(t, testUserID, testBoardID)
+	leader.send(t, newEncryptedDataRequest(testUserID, testBoardID, data))
+	leader.expect(t, EventNewEncryptedData, nil)
+
+	late := dialTest(t, url, nil)
+	late.connectEncrypted(t, testOtherUserID, testBoardID)
+	var response MessageNewEncryptedDataResponse
+	late.expect(t, EventNewEncryptedData, &response)
+	if !bytes.Equal(response.Data.Payload, data.Payload) || !bytes.Equal(response.Data.IV, data.IV) {
+		t.Errorf("got %v, want %v", response.Data, data)
+	}
+}
+
+func TestEncryptedRoomRejects(t *testing.T) {
+	scene := EncryptedData{Payload: []byte("scene"), IV: []byte("iv")}
+	tests := []struct {
+		name      string
+		encrypted bool
+		request   interface{}
+		wantCode  string
+	}{
+		{
+			name:      "plain data to the encrypted room",
+			encrypted: true,
+			request:   newDataRequest(testUserID, testBoardID, `[]`),
+			wantCode:  ErrorCodeRoomModeMismatch,
+		},
+		{
+			name:     "encrypted data to the plain room",
+			request:  newEncryptedDataRequest(testUserID, testBoardID, scene),
+			wantCode: ErrorCodeRoomModeMismatch,
+		},
+		{
+			name:      "encrypted data without iv",
+			encrypted: true,
+			request:   newEncryptedDataRequest(testUserID, testBoardID, EncryptedData{Payload: scene.Payload}),
+			wantCode:  ErrorCodeInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+			client := dialTest(t, newTestServer(t, ws), nil)
+			if tt.encrypted {
+				client.connectEncrypted(t, testUserID, testBoardID)
+			} else {
+				client.connect(t, testUserID, testBoardID)
+			}
+			client.setLeader(t, testUserID, testBoardID)
+			client.send(t, tt.request)
+
+			var response MessageErrorResponse
+			client.expect(t, EventError, &response)
+			if response.Code != tt.wantCode {
+				t.Errorf("error code %s, want %s", response.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestConnectRoomModeMismatch(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	encrypted := dialTest(t, url, nil)
+	encrypted.connectEncrypted(t, testUserID, testBoardID)
+
+	// The first user chose the encrypted room, so the plain client can't join it
+	plain := dialTest(t, url, nil)
+	plain.send(t, MessageConnectRequest{Message: Message{Event: EventConnect}, BoardID: testBoardID, Jwt: "plain"})
+	var response MessageErrorResponse
+	plain.expect(t, EventError, &response)
+	if response.Code != ErrorCodeRoomModeMismatch {
+		t.Errorf("error code %s, want %s", response.Code, ErrorCodeRoomModeMismatch)
+	}
+}
@@ -0,0 +1,30 @@
+package ws
+
+import (
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// Notifier receives the room events, e.g. to send them to the webhooks.
+// Notify is called on the websocket path, so it must not block.
+type Notifier interface {
+	Notify(event models.RoomEvent)
+}
+
+// Notifiers sends the events to all the notifiers.
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(event models.RoomEvent) {
+	for _, notifier := range n {
+		notifier.Notify(event)
+	}
+}
+
+// noopNotifier discards the events.
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(models.RoomEvent) {}
+
+// notify reports the event of the room caused by the user.
+func (ws *WebSocketHandler) notify(eventType string, currentRoom *models.Room, userID string) {
+	ws.notifier.Notify(models.NewRoomEvent(eventType, currentRoom, userID))
+}
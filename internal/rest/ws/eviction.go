@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// acquireRoom returns the room of the board pinned against the eviction, the caller must unpin it.
+// A missing room is created from the snapshot of the evicted room if there is one,
+// otherwise encrypted sets the mode of the new room. It reports whether the room is new.
+func (ws *WebSocketHandler) acquireRoom(boardID, userID string, encrypted bool) (*models.Room, bool) {
+	ws.roomsMtx.Lock()
+	defer ws.roomsMtx.Unlock()
+
+	if currentRoom, _ := ws.roomStorage.Get(boardID); currentRoom != nil {
+		currentRoom.Pin()
+		return currentRoom, false
+	}
+
+	currentRoom := models.NewRoom(boardID)
+	currentRoom.Encrypted = encrypted
+	if ws.snapshotStorage != nil {
+		value, err := ws.snapshotStorage.Take(boardID)
+		if err != nil {
+			ws.logger.Error("Failed to take snapshot", zap.String("boardID", boardID), zap.Error(err))
+		}
+		if value != nil {
+			currentRoom = roomFromSnapshot(value)
+			ws.logger.Info("Room restored", zap.String("boardID", boardID))
+			ws.checkMemoryBudget()
+		}
+	}
+	// The lock of the board outlives its rooms, the lock of a restored room is kept unless it was changed since
+	if locked, ok := ws.locks[boardID]; ok {
+		currentRoom.SetLocked(locked)
+	} else if currentRoom.IsLocked() {
+		ws.locks[boardID] = true
+	}
+	currentRoom.Pin()
+	_ = ws.roomStorage.Set(boardID, currentRoom)
+	ws.notify(models.RoomEventRoomCreated, currentRoom, userID)
+	return currentRoom, true
+}
+
+// PeekRoom returns the room of the board, or the room of its snapshot if the room was evicted,
+// without restoring it. It returns nil if the board has no room.
+func (ws *WebSocketHandler) PeekRoom(boardID string) *models.Room {
+	ws.roomsMtx.Lock()
+	defer ws.roomsMtx.Unlock()
+
+	if currentRoom, _ := ws.roomStorage.Get(boardID); currentRoom != nil {
+		return currentRoom
+	}
+	if ws.snapshotStorage == nil {
+		return nil
+	}
+	value, err := ws.snapshotStorage.Peek(boardID)
+	if err != nil {
+		ws.logger.Error("Failed to peek snapshot", zap.String("boardID", boardID), zap.Error(err))
+	}
+	if value == nil {
+		return nil
+	}
+	return roomFromSnapshot(value)
+}
+
+// closeRoom removes the room left by its last user unless somebody is joining it. With the memory budget,
+// the room with a scene stays idle until it is evicted, so the users coming back find the scene.
+// It reports whether the room is idle, i.e. there is nobody left to notify.
+func (ws *WebSocketHandler) closeRoom(currentRoom *models.Room, userID string) bool {
+	ws.roomsMtx.Lock()
+	defer ws.roomsMtx.Unlock()
+	if !currentRoom.IsIdle() {
+		return false
+	}
+	if ws.maxScenesSize > 0 && currentRoom.SceneSize() > 0 {
+		ws.checkMemoryBudget()
+		return true
+	}
+	_ = ws.roomStorage.Delete(currentRoom.BoardID)
+	ws.collectFiles(currentRoom)
+	ws.notify(models.RoomEventRoomClosed, currentRoom, userID)
+	return true
+}
+
+// checkMemoryBudget asks the eviction loop to check the size of the room scenes.
+// The checks requested while one is running are coalesced.
+func (ws *WebSocketHandler) checkMemoryBudget() {
+	if ws.maxScenesSize <= 0 {
+		return
+	}
+	select {
+	case ws.evictionCheck <- struct{}{}:
+	default:
+	}
+}
+
+// evictionLoop enforces the memory budget until the shutdown.
+func (ws *WebSocketHandler) evictionLoop() {
+	for {
+		select {
+		case <-ws.evictionCheck:
+			ws.enforceMemoryBudget()
+		case <-ws.loopsDone:
+			return
+		}
+	}
+}
+
+// enforceMemoryBudget evicts the idle rooms, the least recently active first,
+// until the scenes of the rooms fit into the budget.
+func (ws *WebSocketHandler) enforceMemoryBudget() {
+	rooms, err := ws.roomStorage.GetAll()
+	if err != nil {
+		ws.logger.Error("Failed to get rooms", zap.Error(err))
+		return
+	}
+
+	var total int64
+	idle := make([]*models.Room, 0)
+	for _, currentRoom := range rooms {
+		size := int64(currentRoom.SceneSize())
+		total += size
+		if size > 0 && currentRoom.IsIdle() {
+			idle = append(idle, currentRoom)
+		}
+	}
+	if total <= ws.maxScenesSize {
+		return
+	}
+
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].GetLastActive().Before(idle[j].GetLastActive())
+	})
+	evicted := 0
+	for _, currentRoom := range idle {
+		if total <= ws.maxScenesSize || ws.draining.Load() {
+			break
+		}
+		size := int64(currentRoom.SceneSize())
+		if ws.evictRoom(currentRoom) {
+			total -= size
+			evicted++
+		}
+	}
+
+	ws.logger.Info("Idle rooms evicted", zap.Int("rooms", evicted), zap.Int64("scenesSize", total))
+	if total > ws.maxScenesSize {
+		ws.logger.Warn(
+			"Room scenes exceed the memory budget, no idle rooms left to evict",
+			zap.Int64("scenesSize", total),
+			zap.Int64("budget", ws.maxScenesSize),
+		)
+	}
+}
+
+// evictRoom saves the snapshot of the idle room if the snapshots are enabled and removes the room.
+// The room is kept if a user joined it meanwhile or the snapshot can't be saved.
+func (ws *WebSocketHandler) evictRoom(currentRoom *models.Room) bool {
+	ws.roomsMtx.Lock()
+	defer ws.roomsMtx.Unlock()
+	if !currentRoom.IsIdle() {
+		return false
+	}
+
+	if ws.snapshotStorage != nil {
+		value := snapshotOf(currentRoom)
+		value.Evicted = true
+		if err := ws.snapshotStorage.Save(value); err != nil {
+			ws.logger.Error("Failed to save snapshot", zap.String("boardID", currentRoom.BoardID), zap.Error(err))
+			return false
+		}
+	}
+	_ = ws.roomStorage.Delete(currentRoom.BoardID)
+	ws.collectFiles(currentRoom)
+	ws.notify(models.RoomEventRoomClosed, currentRoom, "")
+
+	ws.logger.Debug("Room evicted", zap.String("boardID", currentRoom.BoardID))
+	return true
+}
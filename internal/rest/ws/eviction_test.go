@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot"
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot/disk"
+)
+
+// testScene is a scene larger than the memory budget of the eviction tests
+const testScene = `[{"id":"a","type":"rectangle"}]`
+
+// leaveScene joins the board, sets the scene and leaves the board, so the room is idle.
+func leaveScene(t *testing.T, url string) {
+	t.Helper()
+	client := dialTest(t, url, nil)
+	client.connect(t, testUserID, testBoardID)
+	client.setLeader(t, testUserID, testBoardID)
+	client.send(t, newDataRequest(testUserID, testBoardID, testScene))
+	client.expect(t, EventNewData, nil)
+	_ = client.conn.Close()
+}
+
+// newTestSnapshots creates the disk snapshots storage in a temporary directory.
+func newTestSnapshots(t *testing.T) snapshot.Storage {
+	t.Helper()
+	snapshots, err := disk.NewStorage(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStorage() unexpected error: %v", err)
+	}
+	return snapshots
+}
+
+func TestEvictIdleRooms(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{MaxScenesSize: 16, SnapshotStorage: newTestSnapshots(t)})
+	url := newTestServer(t, ws)
+	leaveScene(t, url)
+
+	// The idle room over the budget is evicted to its snapshot
+	waitFor(t, func() bool {
+		currentRoom, _ := ws.roomStorage.Get(testBoardID)
+		return currentRoom == nil
+	})
+
+	// The evicted room is read from the snapshot without restoring it
+	peeked := ws.PeekRoom(testBoardID)
+	if peeked == nil || peeked.GetElements() != testScene {
+		t.Fatalf("PeekRoom() = %v, want the room with the scene %s", peeked, testScene)
+	}
+	if currentRoom, _ := ws.roomStorage.Get(testBoardID); currentRoom != nil {
+		t.Error("room restored by PeekRoom()")
+	}
+
+	// The user coming back finds the scene
+	client := dialTest(t, url, nil)
+	client.connect(t, testOtherUserID, testBoardID)
+	var response MessageNewDataResponse
+	client.expect(t, EventNewData, &response)
+	if response.Data.Elements != testScene {
+		t.Errorf("restored %s, want %s", response.Data.Elements, testScene)
+	}
+}
+
+func TestPeekRoom(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshots snapshot.Storage
+		wantRoom  bool
+	}{
+		{name: "without snapshots", wantRoom: false},
+		{name: "with snapshots", snapshots: newTestSnapshots(t), wantRoom: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), Config{MaxScenesSize: 16, SnapshotStorage: tt.snapshots})
+			url := newTestServer(t, ws)
+			if ws.PeekRoom(testBoardID) != nil {
+				t.Fatal("PeekRoom() of the unknown board = room, want nil")
+			}
+
+			leaveScene(t, url)
+			waitFor(t, func() bool {
+				currentRoom, _ := ws.roomStorage.Get(testBoardID)
+				return currentRoom == nil
+			})
+			if got := ws.PeekRoom(testBoardID) != nil; got != tt.wantRoom {
+				t.Errorf("PeekRoom() of the evicted board found the room %t, want %t", got, tt.wantRoom)
+			}
+		})
+	}
+}
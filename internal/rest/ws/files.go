@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/scene"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+// filesExpiryInterval is the interval between the removals of the expired files.
+const filesExpiryInterval = 10 * time.Minute
+
+// uploadFile stores the file of the board and notifies the users in the room about it.
+func (ws *WebSocketHandler) uploadFile(ctx context.Context, conn *models.Connection, request MessageUploadFileRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	// Check if user belongs to the room
+	u, _ := ws.userStorage.Get(userID)
+	if u == nil || u.RoomID != request.BoardID {
+		return
+	}
+
+	// Get the room
+	currentRoom, _ := ws.roomStorage.Get(request.BoardID)
+	if currentRoom == nil {
+		return
+	}
+
+	// The spectators only watch the room
+	if currentRoom.IsSpectator(userID) {
+		ws.sendError(conn, ErrorCodeSpectator, "the spectators can't upload files")
+		return
+	}
+
+	if request.File.ID == "" || len(request.File.ID) > models.MaxFileIDLength || len(request.File.Data) == 0 {
+		ws.sendError(conn, ErrorCodeInvalidPayload, "file id and data are required")
+		return
+	}
+
+	created := request.File.Created
+	if created == 0 {
+		created = time.Now().UnixMilli()
+	}
+	err = ws.fileStorage.Set(&models.File{
+		ID:       request.File.ID,
+		BoardID:  request.BoardID,
+		MimeType: request.File.MimeType,
+		Data:     request.File.Data,
+		Created:  created,
+	})
+	switch {
+	case errors.Is(err, file.ErrFileTooLarge):
+		ws.sendError(conn, ErrorCodeFileTooLarge, "file exceeds the size limit")
+		return
+	case errors.Is(err, file.ErrQuotaExceeded):
+		ws.sendError(conn, ErrorCodeQuotaExceeded, "board files exceed the quota")
+		return
+	case err != nil:
+		ws.logger.Error("Failed to store file", zap.Error(err))
+		return
+	}
+
+	ws.logger.Debug("File uploaded", zap.String("userID", userID), zap.String("fileID", request.File.ID))
+
+	// Let the users in the room know that they can fetch the file
+	ws.broadcastToRoom(currentRoom, MessageFileUploadedResponse{
+		Message: Message{
+			Event: EventFileUploaded,
+		},
+		BoardID:  request.BoardID,
+		FileID:   request.File.ID,
+		MimeType: request.File.MimeType,
+	})
+}
+
+// getFile sends the file of the board to the user.
+func (ws *WebSocketHandler) getFile(ctx context.Context, conn *models.Connection, request MessageGetFileRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	// Check if user belongs to the room
+	u, _ := ws.userStorage.Get(userID)
+	if u == nil || u.RoomID != request.BoardID {
+		return
+	}
+
+	f, err := ws.fileStorage.Get(request.BoardID, request.FileID)
+	if errors.Is(err, file.ErrFileNotFound) {
+		ws.sendError(conn, ErrorCodeFileNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		ws.logger.Error("Failed to get file", zap.Error(err))
+		return
+	}
+
+	_ = conn.Send(MessageFileResponse{
+		Message: Message{
+			Event: EventFile,
+		},
+		BoardID: request.BoardID,
+		File: FileData{
+			ID:       f.ID,
+			MimeType: f.MimeType,
+			Data:     f.Data,
+			Created:  f.Created,
+		},
+	})
+}
+
+// collectFiles removes the files of the closed room that its scene doesn't use. The scenes of the encrypted
+// rooms can't be read, so their files are only removed by the expiry.
+func (ws *WebSocketHandler) collectFiles(currentRoom *models.Room) {
+	elements := currentRoom.GetElements()
+	if currentRoom.Encrypted || elements == "" {
+		return
+	}
+	parsed, err := scene.ParseElements(elements)
+	if err != nil {
+		return
+	}
+	keep := make(map[string]bool)
+	for _, fileID := range scene.FileIDs(parsed) {
+		keep[fileID] = true
+	}
+	if err := ws.fileStorage.Retain(currentRoom.BoardID, keep); err != nil {
+		ws.logger.Error("Failed to remove unused files", zap.String("boardID", currentRoom.BoardID), zap.Error(err))
+	}
+}
+
+// filesExpiryLoop removes the files that were not used for the files TTL until the shutdown.
+func (ws *WebSocketHandler) filesExpiryLoop() {
+	ticker := time.NewTicker(filesExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.fileStorage.Expire(time.Now().Add(-ws.filesTTL)); err != nil {
+				ws.logger.Error("Failed to remove expired files", zap.Error(err))
+			}
+		case <-ws.loopsDone:
+			return
+		}
+	}
+}
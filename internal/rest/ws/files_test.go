@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+	inmemFile "github.com/Icerzack/excaliroom/internal/storage/file/inmemory"
+)
+
+func newUploadFileRequest(userID, boardID, fileID string, data []byte) MessageUploadFileRequest {
+	return MessageUploadFileRequest{
+		Message: Message{Event: EventUploadFile},
+		BoardID: boardID,
+		Jwt:     userID,
+		File:    FileData{ID: fileID, MimeType: "image/png", Data: data},
+	}
+}
+
+func newGetFileRequest(userID, boardID, fileID string) MessageGetFileRequest {
+	return MessageGetFileRequest{
+		Message: Message{Event: EventGetFile},
+		BoardID: boardID,
+		Jwt:     userID,
+		FileID:  fileID,
+	}
+}
+
+func TestFileSharing(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	uploader := dialTest(t, url, nil)
+	other := dialTest(t, url, nil)
+	uploader.connect(t, testUserID, testBoardID)
+	other.connect(t, testOtherUserID, testBoardID)
+
+	// The users of the room learn about the file and fetch it
+	data := []byte{0x89, 'P', 'N', 'G'}
+	uploader.send(t, newUploadFileRequest(testUserID, testBoardID, "file-1", data))
+	var uploaded MessageFileUploadedResponse
+	other.expect(t, EventFileUploaded, &uploaded)
+	if uploaded.FileID != "file-1" || uploaded.MimeType != "image/png" {
+		t.Fatalf("got uploaded file %s of %s, want file-1 of image/png", uploaded.FileID, uploaded.MimeType)
+	}
+
+	other.send(t, newGetFileRequest(testOtherUserID, testBoardID, uploaded.FileID))
+	var response MessageFileResponse
+	other.expect(t, EventFile, &response)
+	if !bytes.Equal(response.File.Data, data) || response.File.Created == 0 {
+		t.Errorf("got file %v created at %d, want %v with the creation time",
+			response.File.Data, response.File.Created, data)
+	}
+
+	other.send(t, newGetFileRequest(testOtherUserID, testBoardID, "missing"))
+	var missing MessageErrorResponse
+	other.expect(t, EventError, &missing)
+	if missing.Code != ErrorCodeFileNotFound {
+		t.Errorf("error code %s, want %s", missing.Code, ErrorCodeFileNotFound)
+	}
+}
+
+func TestFileUploadRejects(t *testing.T) {
+	files := inmemFile.NewStorage(file.Quota{MaxFileSize: 4, MaxBoardSize: 6}, zap.NewNop())
+	ws := newTestHandlerWithFiles(t, newTestBackend(t, nil), Config{}, files)
+	client := dialTest(t, newTestServer(t, ws), nil)
+	client.connect(t, testUserID, testBoardID)
+	client.send(t, newUploadFileRequest(testUserID, testBoardID, "stored", make([]byte, 4)))
+	client.expect(t, EventFileUploaded, nil)
+
+	tests := []struct {
+		name     string
+		fileID   string
+		size     int
+		wantCode string
+	}{
+		{name: "no data", fileID: "empty", wantCode: ErrorCodeInvalidPayload},
+		{name: "no id", size: 1, wantCode: ErrorCodeInvalidPayload},
+		{name: "file too large", fileID: "large", size: 5, wantCode: ErrorCodeFileTooLarge},
+		{name: "board quota exceeded", fileID: "over", size: 3, wantCode: ErrorCodeQuotaExceeded},
+	}
+	for _, tt := range tests {
+		client.send(t, newUploadFileRequest(testUserID, testBoardID, tt.fileID, make([]byte, tt.size)))
+		var response MessageErrorResponse
+		client.expect(t, EventError, &response)
+		if response.Code != tt.wantCode {
+			t.Errorf("%s: error code %s, want %s", tt.name, response.Code, tt.wantCode)
+		}
+	}
+}
@@ -5,32 +5,88 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/Icerzack/excaliroom/internal/cache"
+	"github.com/Icerzack/excaliroom/internal/codec"
 	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/ratelimit"
+	"github.com/Icerzack/excaliroom/internal/scene"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
 	"github.com/Icerzack/excaliroom/internal/storage/room"
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot"
 	"github.com/Icerzack/excaliroom/internal/storage/user"
+	"github.com/Icerzack/excaliroom/internal/upstream"
 )
 
 var (
 	ErrInvalidMessage = errors.New("invalid message")
 	ErrValidatingJWT  = errors.New("failed to validate jwt")
 	ErrInvalidJWT     = errors.New("invalid jwt")
+	ErrNoBoardAccess  = errors.New("no access to the board")
+	ErrNotBoardOwner  = errors.New("not an owner of the board")
+	ErrRoomEncrypted  = errors.New("room is encrypted")
+	ErrSceneTooLarge  = errors.New("scene too large")
 )
 
 const (
+	EventHello            = "hello"
+	EventWelcome          = "welcome"
 	EventConnect          = "connect"
 	EventUserConnected    = "userConnected"
 	EventUserDisconnected = "userDisconnected"
 	EventSetLeader        = "setLeader"
 	EventNewData          = "newData"
+	EventNewEncryptedData = "newEncryptedData"
+	EventUploadFile       = "uploadFile"
+	EventFileUploaded     = "fileUploaded"
+	EventGetFile          = "getFile"
+	EventFile             = "file"
+	EventServerShutdown   = "serverShutdown"
+	EventSession          = "session"
+	EventResume           = "resume"
+	EventResumed          = "resumed"
+	EventAck              = "ack"
+	EventRoomLocked       = "roomLocked"
+	EventChatMessage      = "chatMessage"
+	EventChatHistory      = "chatHistory"
+	EventReaction         = "reaction"
+	EventError            = "error"
 )
 
+const (
+	ErrorCodeRateLimited         = "rateLimited"
+	ErrorCodeSceneTooLarge       = "sceneTooLarge"
+	ErrorCodeUnsupportedProtocol = "unsupportedProtocol"
+	ErrorCodeRoomModeMismatch    = "roomModeMismatch"
+	ErrorCodeInvalidPayload      = "invalidPayload"
+	ErrorCodeInvalidElements     = "invalidElements"
+	ErrorCodeFileTooLarge        = "fileTooLarge"
+	ErrorCodeQuotaExceeded       = "quotaExceeded"
+	ErrorCodeFileNotFound        = "fileNotFound"
+	ErrorCodeResumeFailed        = "resumeFailed"
+	ErrorCodeRoomFull            = "roomFull"
+	ErrorCodeRoomLocked          = "roomLocked"
+	ErrorCodeSpectator           = "spectator"
+	ErrorCodeMessageTooLong      = "messageTooLong"
+	ErrorCodeAccessRevoked       = "accessRevoked"
+)
+
+// EventMessage is an inbound message of any type.
+type EventMessage interface {
+	GetEvent() string
+}
+
 type WebSocketHandler struct {
 	// upgrader is used to upgrade the HTTP connection to a WebSocket connection
 	upgrader *websocket.Upgrader
@@ -44,89 +100,496 @@ type WebSocketHandler struct {
 	// boardValidationURL is the URL that will be used to validate the board access
 	boardValidationURL string
 
+	// origins checks the Origin header of the upgrade requests and counts the rejected ones
+	origins *originChecker
+
 	// userStorage is used to store the clients
 	userStorage user.Storage
 
 	// roomStorage is used to store the rooms
 	roomStorage room.Storage
 
+	// fileStorage is used to store the files of the boards
+	fileStorage file.Storage
+
 	// cache is used to store the validation results
 	cache cache.Cache
 
 	// cacheTTLInSeconds is the time to live of the cache
 	cacheTTLInSeconds int64
 
+	// messageQueueSize is the number of inbound messages buffered per connection
+	messageQueueSize int
+
+	// handlerSlots limits the number of messages processed at the same time across the server
+	handlerSlots chan struct{}
+
+	// pingInterval is the interval between pings sent to the peer
+	pingInterval time.Duration
+
+	// pongWait is the time allowed to read the next pong message from the peer
+	pongWait time.Duration
+
+	// writeTimeout is the time allowed to write a message to the peer
+	writeTimeout time.Duration
+
+	// maxMessageSize is the maximum size of an inbound message in bytes
+	maxMessageSize int64
+
+	// maxSceneSize is the maximum size of the room scene in bytes
+	maxSceneSize int
+
+	// sceneLimits restricts the number of the elements and the length of their text
+	sceneLimits scene.Limits
+
+	// rateLimits is a map of rate limits by event type
+	rateLimits map[string]RateLimit
+
+	// maxViolations is the number of limit violations within violationWindow after which the connection is dropped
+	maxViolations int
+
+	// violationWindow is the time the limit violations are counted for
+	violationWindow time.Duration
+
+	// compressionLevel is the flate compression level of the outbound messages
+	compressionLevel int
+
+	// compressionThreshold is the minimum size of an outbound message that is compressed
+	compressionThreshold int
+
+	// keepEncryptedScenes is true if the last encrypted scene is kept and sent to the new users
+	keepEncryptedScenes bool
+
+	// notifier receives the room events
+	notifier Notifier
+
+	// snapshotStorage keeps the room scenes between the restarts, nil if the snapshots are disabled
+	snapshotStorage snapshot.Storage
+
+	// reconnectAfter is the minimum delay before the clients reconnect after the shutdown
+	reconnectAfter time.Duration
+
+	// maxRoomUsers is the maximum number of the participants of a room, zero means unlimited
+	maxRoomUsers int
+
+	// spectateOverflow is true if the users joining a full room become spectators instead of being rejected
+	spectateOverflow bool
+
+	// resumeGracePeriod is the time a disconnected user waits for the session resume, zero disables the resume
+	resumeGracePeriod time.Duration
+
+	// resumeHistorySize is the number of the last events of a room kept for the resumed sessions
+	resumeHistorySize int
+
+	// chatHistorySize is the number of the last chat messages of a room sent to the new users
+	chatHistorySize int
+
+	// maxChatMessageLength is the maximum length of a chat message in characters
+	maxChatMessageLength int
+
+	// maxScenesSize is the memory budget of the room scenes in bytes, zero means unlimited
+	maxScenesSize int64
+
+	// roomsMtx serializes the creation and the eviction of the rooms and guards locks
+	roomsMtx *sync.Mutex
+
+	// locks is a map of the locks of the boards changed with LockRoom, they outlive the rooms
+	locks map[string]bool
+
+	// evictionCheck wakes the eviction loop up to check the memory budget
+	evictionCheck chan struct{}
+
+	// filesTTL is the time the files are kept after they were last stored or read, zero disables the expiry
+	filesTTL time.Duration
+
+	// loopsDone is closed on shutdown to stop the eviction and the files expiry loops
+	loopsDone chan struct{}
+
+	// upstream is the client of the validation URLs
+	upstream *upstream.Client
+
+	// fallbackToCache is true if the last cached decisions are served while the validation URLs are unavailable
+	fallbackToCache bool
+
+	// fallbackCacheTTL is the time the last decisions are kept for the fallback in seconds
+	fallbackCacheTTL int64
+
+	// negativeCacheTTL is the time to live of the cached rejections in seconds, zero disables them
+	negativeCacheTTL int64
+
+	// negativeCache is used to store the rejections
+	negativeCache cache.Cache
+
+	// fallbackCache is used to store the decisions for the fallback
+	fallbackCache cache.Cache
+
+	// accessCacheTTL is the time to live of the cached access of the users to the boards in seconds
+	accessCacheTTL int64
+
+	// accessGenerations is a map of the generations of the cached access by board and by user, see accessKey
+	accessGenerations map[string]accessGeneration
+
+	// lastAccessGeneration is the last generation given out, the generations are never reused
+	lastAccessGeneration uint64
+
+	// accessGenerationTTL is the time the generations are kept after the invalidation, see pruneAccessGenerations
+	accessGenerationTTL time.Duration
+
+	// accessPruned is the time accessGenerations was last pruned
+	accessPruned time.Time
+
+	// accessMtx guards accessGenerations, lastAccessGeneration and accessPruned
+	accessMtx *sync.Mutex
+
+	// validations coalesces the concurrent validations of the same token and board
+	validations *singleflight.Group
+
+	// sessions is a map of the resumable sessions by user id
+	sessions map[string]*session
+
+	// sessionsMtx guards sessions and the connections of the users
+	sessionsMtx *sync.Mutex
+
+	// connections is a set of the open connections, they are drained on shutdown
+	connections *models.Connections
+
+	// draining is true once the shutdown started, the new connections and messages are rejected
+	draining *atomic.Bool
+
 	logger *zap.Logger
 }
 
 func NewWebSocketHandler(
 	clientsStorage user.Storage,
 	roomStorage room.Storage,
+	fileStorage file.Storage,
 	cache cache.Cache,
-	cacheTTLInSeconds int64,
-	jwtHeaderName string,
-	jwtValidationURL string,
-	boardValidationURL string,
-	logger *zap.Logger,
+	config *Config,
 ) *WebSocketHandler {
-	return &WebSocketHandler{
+	cfg := config.withDefaults()
+	origins := newOriginChecker(cfg.AllowedOrigins, cfg.Logger)
+	sceneLimits := scene.Limits{MaxElements: cfg.MaxElements, MaxTextLength: cfg.MaxTextLength}.WithDefaults()
+	ws := &WebSocketHandler{
 		upgrader: &websocket.Upgrader{
-			CheckOrigin: func(_ *http.Request) bool {
-				return true
-			},
+			CheckOrigin:       origins.Check,
+			EnableCompression: cfg.EnableCompression,
+			Subprotocols:      codec.Subprotocols(),
 		},
-		userStorage:        clientsStorage,
-		roomStorage:        roomStorage,
-		jwtHeaderName:      jwtHeaderName,
-		jwtValidationURL:   jwtValidationURL,
-		boardValidationURL: boardValidationURL,
-		cache:              cache,
-		cacheTTLInSeconds:  cacheTTLInSeconds,
-		logger:             logger,
+		origins:              origins,
+		userStorage:          clientsStorage,
+		roomStorage:          roomStorage,
+		fileStorage:          fileStorage,
+		jwtHeaderName:        cfg.JwtHeaderName,
+		jwtValidationURL:     cfg.JwtValidationURL,
+		boardValidationURL:   cfg.BoardValidationURL,
+		cache:                cache,
+		cacheTTLInSeconds:    cfg.CacheTTL,
+		upstream:             cfg.Upstream,
+		fallbackToCache:      cfg.ValidationFallback == ValidationFallbackCache,
+		fallbackCacheTTL:     cfg.FallbackCacheTTL,
+		negativeCacheTTL:     cfg.NegativeCacheTTL,
+		negativeCache:        cache,
+		fallbackCache:        cache,
+		accessCacheTTL:       cfg.AccessCacheTTL,
+		accessGenerations:    make(map[string]accessGeneration),
+		accessGenerationTTL:  accessGenerationTTL(&cfg),
+		accessMtx:            &sync.Mutex{},
+		validations:          &singleflight.Group{},
+		messageQueueSize:     cfg.MessageQueueSize,
+		handlerSlots:         make(chan struct{}, cfg.MaxConcurrentHandlers),
+		pingInterval:         time.Duration(cfg.PingInterval) * time.Second,
+		pongWait:             time.Duration(cfg.PongWait) * time.Second,
+		writeTimeout:         time.Duration(cfg.WriteTimeout) * time.Second,
+		maxMessageSize:       cfg.MaxMessageSize,
+		maxSceneSize:         cfg.MaxSceneSize,
+		sceneLimits:          sceneLimits,
+		rateLimits:           cfg.RateLimits,
+		maxViolations:        cfg.MaxViolations,
+		violationWindow:      time.Duration(cfg.ViolationWindow) * time.Second,
+		compressionLevel:     cfg.CompressionLevel,
+		compressionThreshold: cfg.CompressionThreshold,
+		keepEncryptedScenes:  cfg.KeepEncryptedScenes,
+		notifier:             cfg.Notifier,
+		snapshotStorage:      cfg.SnapshotStorage,
+		reconnectAfter:       time.Duration(cfg.ReconnectAfter) * time.Second,
+		maxRoomUsers:         cfg.MaxRoomUsers,
+		spectateOverflow:     cfg.RoomOverflow == RoomOverflowSpectate,
+		resumeGracePeriod:    time.Duration(cfg.ResumeGracePeriod) * time.Second,
+		resumeHistorySize:    cfg.ResumeHistorySize,
+		chatHistorySize:      cfg.ChatHistorySize,
+		maxChatMessageLength: cfg.MaxChatMessageLength,
+		maxScenesSize:        cfg.MaxScenesSize,
+		roomsMtx:             &sync.Mutex{},
+		locks:                make(map[string]bool),
+		evictionCheck:        make(chan struct{}, 1),
+		filesTTL:             time.Duration(cfg.FilesTTL) * time.Second,
+		loopsDone:            make(chan struct{}),
+		sessions:             make(map[string]*session),
+		sessionsMtx:          &sync.Mutex{},
+		connections:          models.NewConnections(),
+		draining:             &atomic.Bool{},
+		logger:               cfg.Logger,
+	}
+	if cfg.NegativeCache != nil {
+		ws.negativeCache = cfg.NegativeCache
+	}
+	if cfg.FallbackCache != nil {
+		ws.fallbackCache = cfg.FallbackCache
+	}
+	if ws.maxScenesSize > 0 {
+		go ws.evictionLoop()
 	}
+	if ws.filesTTL > 0 {
+		go ws.filesExpiryLoop()
+	}
+	return ws
 }
 
 func (ws *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if ws.draining.Load() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(ws.reconnectAfter.Seconds())))
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	wsConn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		ws.logger.Error("Failed to upgrade connection", zap.Error(err))
 		return
 	}
+	conn := models.NewConnection(
+		wsConn,
+		codec.BySubprotocol(wsConn.Subprotocol()),
+		ws.writeTimeout,
+		ws.compressionThreshold,
+	)
+	if ws.upgrader.EnableCompression && offersCompression(r) {
+		_ = conn.SetCompressionLevel(ws.compressionLevel)
+		conn.SetCompressed(true)
+	}
 	defer conn.Close()
-	ws.logger.Info("Connection upgraded successfully")
+	ws.connections.Add(conn)
+	defer ws.connections.Remove(conn)
+	ws.logger.Info("Connection upgraded successfully", zap.String("codec", conn.Codec.Name()))
+
+	// The shutdown could start while the connection was upgraded
+	if ws.draining.Load() {
+		_ = conn.CloseWithCode(websocket.CloseServiceRestart, shutdownCloseReason)
+		return
+	}
+
+	// Frames bigger than the limit make the read fail and close the connection
+	conn.SetReadLimit(ws.maxMessageSize)
+
+	// The peer has to answer pings in time, otherwise the read deadline expires
+	// and the connection is treated as dead.
+	_ = conn.SetReadDeadline(time.Now().Add(ws.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(ws.pongWait))
+	})
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go ws.heartbeat(conn, stopHeartbeat)
+
+	// Messages of a single connection are processed in order by one worker.
+	// When the queue is full the read loop blocks, so a fast client is slowed
+	// down by TCP backpressure instead of piling up work on the server.
+	// The validations of the messages are canceled once the connection is closed
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	queue := make(chan []byte, ws.messageQueueSize)
+	done := make(chan struct{})
+	go ws.processMessages(ctx, conn, queue, done)
+
 	for {
 		mt, msg, err := conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			ws.logger.Info("Message exceeds the size limit", zap.Int64("limit", ws.maxMessageSize))
+		}
 		if err != nil || mt == websocket.CloseMessage {
-			ws.unregisterUser(conn)
-			ws.logger.Info("Connection closed")
 			break
 		}
 
-		// Handle the incoming message
-		go ws.messageHandler(conn, msg)
+		queue <- msg
+	}
+
+	cancel()
+	close(queue)
+	<-done
+	ws.unregisterUser(conn)
+	ws.logger.Info("Connection closed")
+}
+
+// heartbeat pings the peer until stop is closed or the ping can't be written.
+func (ws *WebSocketHandler) heartbeat(conn *models.Connection, stop <-chan struct{}) {
+	ticker := time.NewTicker(ws.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				ws.logger.Debug("Failed to ping connection", zap.Error(err))
+				// Closing the connection unblocks the read loop which unregisters the user
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// processMessages handles the queued messages of a connection one by one.
+func (ws *WebSocketHandler) processMessages(
+	ctx context.Context,
+	conn *models.Connection,
+	queue <-chan []byte,
+	done chan<- struct{},
+) {
+	defer close(done)
+	limiter := ratelimit.NewLimiter(ws.rateLimits, ws.maxViolations, ws.violationWindow)
+	for msg := range queue {
+		ws.handlerSlots <- struct{}{}
+		ws.messageHandler(ctx, conn, limiter, msg)
+		<-ws.handlerSlots
 	}
 }
 
-func (ws *WebSocketHandler) messageHandler(conn *websocket.Conn, msg []byte) {
-	message, err := messageDefiner(msg)
+// CheckOrigin reports whether the upgrade request comes from an allowed origin.
+func (ws *WebSocketHandler) CheckOrigin(r *http.Request) bool {
+	return ws.upgrader.CheckOrigin(r)
+}
+
+// Stats is the counters of the websocket endpoint.
+type Stats struct {
+	// Connections is the number of the open connections
+	Connections int
+
+	// RejectedOrigins is the number of the upgrade requests rejected because of their origin,
+	// including the ones of the Socket.IO endpoint sharing CheckOrigin
+	RejectedOrigins uint64
+}
+
+// Stats returns the counters of the websocket endpoint.
+func (ws *WebSocketHandler) Stats() Stats {
+	return Stats{
+		Connections:     len(ws.connections.GetAll()),
+		RejectedOrigins: ws.origins.Rejected(),
+	}
+}
+
+// ValidateAccess checks the access of the JWT token to the board and returns the user id.
+func (ws *WebSocketHandler) ValidateAccess(ctx context.Context, jwt, boardID string) (string, error) {
+	return ws.cacheOrValidate(ctx, jwt, boardID)
+}
+
+func (ws *WebSocketHandler) messageHandler(
+	ctx context.Context,
+	conn *models.Connection,
+	limiter *ratelimit.Limiter,
+	msg []byte,
+) {
+	// The scenes are already saved, so the messages received after the shutdown event are ignored
+	if ws.draining.Load() {
+		return
+	}
+
+	message, err := messageDefiner(conn.Codec, msg)
 	if err != nil {
 		ws.logger.Debug("Failed to define message", zap.Error(err))
 		return
 	}
 
+	// Check the limits
+	if !limiter.Allow(message.GetEvent()) {
+		ws.reportViolation(conn, limiter, ErrorCodeRateLimited,
+			fmt.Sprintf("too many '%s' messages", message.GetEvent()))
+		return
+	}
+	if sceneSize(message) > ws.maxSceneSize {
+		ws.reportViolation(conn, limiter, ErrorCodeSceneTooLarge,
+			fmt.Sprintf("scene exceeds %d bytes", ws.maxSceneSize))
+		return
+	}
+	if chatLength(message) > ws.maxChatMessageLength {
+		ws.reportViolation(conn, limiter, ErrorCodeMessageTooLong,
+			fmt.Sprintf("message exceeds %d characters", ws.maxChatMessageLength))
+		return
+	}
+
 	switch v := message.(type) {
+	case MessageHelloRequest:
+		ws.hello(conn, v)
 	case MessageConnectRequest:
-		ws.registerUser(conn, v)
+		ws.registerUser(ctx, conn, v)
 	case MessageNewDataRequest:
-		ws.sendDataToRoom(v)
+		ws.sendDataToRoom(ctx, v)
+	case MessageNewEncryptedDataRequest:
+		ws.sendEncryptedDataToRoom(ctx, v)
+	case MessageUploadFileRequest:
+		ws.uploadFile(ctx, conn, v)
+	case MessageGetFileRequest:
+		ws.getFile(ctx, conn, v)
 	case MessageSetLeaderRequest:
-		ws.setLeader(v)
+		ws.setLeader(ctx, v)
+	case MessageResumeRequest:
+		ws.resume(ctx, conn, v)
+	case MessageAckRequest:
+		ws.ack(ctx, v)
+	case MessageChatRequest:
+		ws.sendChatMessage(ctx, conn, v)
+	case MessageReactionRequest:
+		ws.sendReaction(ctx, conn, v)
+	}
+}
+
+// sceneSize returns the size of the scene carried by the message.
+func sceneSize(message EventMessage) int {
+	switch v := message.(type) {
+	case MessageNewDataRequest:
+		return len(v.Data.Elements) + len(v.Data.AppState)
+	case MessageNewEncryptedDataRequest:
+		return len(v.Data.Payload) + len(v.Data.IV)
+	default:
+		return 0
+	}
+}
+
+// sendError sends the error event to the connection.
+func (ws *WebSocketHandler) sendError(conn *models.Connection, code, reason string) {
+	// The users waiting for the session resume have no connection
+	if conn == nil {
+		return
+	}
+	_ = conn.Send(MessageErrorResponse{
+		Message: Message{
+			Event: EventError,
+		},
+		Code:   code,
+		Reason: reason,
+	})
+}
+
+// reportViolation sends the error to the connection and drops the connection if it keeps violating the limits.
+func (ws *WebSocketHandler) reportViolation(conn *models.Connection, limiter *ratelimit.Limiter, code, reason string) {
+	ws.logger.Debug("Limit violated", zap.String("code", code), zap.String("reason", reason))
+	ws.sendError(conn, code, reason)
+
+	if limiter.Violate() {
+		ws.logger.Info("Connection dropped for violating the limits", zap.String("code", code))
+		_ = conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many violations"),
+			time.Now().Add(ws.writeTimeout),
+		)
+		// Closing the connection unblocks the read loop which unregisters the user
+		_ = conn.Close()
 	}
 }
 
 //nolint:cyclop
-func (ws *WebSocketHandler) setLeader(request MessageSetLeaderRequest) {
-	userID, err := ws.cacheOrValidate(request.Jwt, request.BoardID)
+func (ws *WebSocketHandler) setLeader(ctx context.Context, request MessageSetLeaderRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
 	if err != nil {
 		ws.logger.Error("Failed to validate", zap.Error(err))
 		return
@@ -149,6 +612,12 @@ func (ws *WebSocketHandler) setLeader(request MessageSetLeaderRequest) {
 		return
 	}
 
+	// The spectators only watch the room
+	if currentRoom.IsSpectator(userID) {
+		ws.sendError(u.Conn, ErrorCodeSpectator, "the spectators can't lead the room")
+		return
+	}
+
 	// Set the leader
 	switch currentRoom.LeaderID {
 	case "0":
@@ -161,29 +630,20 @@ func (ws *WebSocketHandler) setLeader(request MessageSetLeaderRequest) {
 		return
 	}
 
-	// Send the message to all the users in the room
-	for _, currentUser := range currentRoom.GetUsers() {
-		u, _ := ws.userStorage.Get(currentUser.ID)
-		if u == nil {
-			continue
-		}
+	ws.notify(models.RoomEventLeaderChanged, currentRoom, userID)
 
-		err := u.Conn.WriteJSON(MessageSetLeaderResponse{
-			Message: Message{
-				Event: EventSetLeader,
-			},
-			BoardID: request.BoardID,
-			UserID:  currentRoom.LeaderID,
-		})
-		if err != nil {
-			// Remove the user from the storage
-			_ = ws.userStorage.Delete(currentUser.ID)
-		}
-	}
+	// Send the message to all the users in the room
+	ws.broadcastToRoom(currentRoom, MessageSetLeaderResponse{
+		Message: Message{
+			Event: EventSetLeader,
+		},
+		BoardID: request.BoardID,
+		UserID:  currentRoom.LeaderID,
+	})
 }
 
-func (ws *WebSocketHandler) sendDataToRoom(request MessageNewDataRequest) {
-	userID, err := ws.cacheOrValidate(request.Jwt, request.BoardID)
+func (ws *WebSocketHandler) sendDataToRoom(ctx context.Context, request MessageNewDataRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
 	if err != nil {
 		ws.logger.Error("Failed to validate", zap.Error(err))
 		return
@@ -214,43 +674,54 @@ func (ws *WebSocketHandler) sendDataToRoom(request MessageNewDataRequest) {
 		return
 	}
 
+	// The encrypted rooms accept only the encrypted scenes
+	if currentRoom.Encrypted {
+		ws.sendError(u.Conn, ErrorCodeRoomModeMismatch, "the room accepts only encrypted data")
+		return
+	}
+
+	// Validate the scene, so the malformed elements never reach the other users
+	elements, err := ws.sanitizeScene(request.Data.Elements, request.Data.AppState)
+	if err != nil {
+		ws.sendError(u.Conn, ErrorCodeInvalidElements, err.Error())
+		return
+	}
+
 	// Update the current data
-	currentRoom.SetElements(request.Data.Elements)
+	currentRoom.SetElements(elements)
 	currentRoom.SetAppState(request.Data.AppState)
+	revision := currentRoom.NextRevision()
 
 	ws.logger.Debug("Data updated", zap.String("userID", userID), zap.String("boardID", currentRoom.BoardID))
+	ws.notifier.Notify(models.NewSceneUpdatedEvent(currentRoom, userID, elements, request.Data.AppState))
 
 	// Send the new data to all the users in the room
-	for _, v := range currentRoom.GetUsers() {
-		u, _ := ws.userStorage.Get(v.ID)
-		if u == nil {
-			continue
-		}
-
-		err := u.Conn.WriteJSON(MessageNewDataResponse{
-			Message: Message{
-				Event: EventNewData,
-			},
-			BoardID: currentRoom.BoardID,
-			Data: Data{
-				Elements: currentRoom.GetElements(),
-				AppState: currentRoom.GetAppState(),
-			},
-		})
-		if err != nil {
-			ws.unregisterUser(u.Conn)
-		}
-	}
+	ws.broadcastToRoom(currentRoom, MessageNewDataResponse{
+		Message: Message{
+			Event: EventNewData,
+		},
+		BoardID: currentRoom.BoardID,
+		Data: Data{
+			Elements: currentRoom.GetElements(),
+			AppState: currentRoom.GetAppState(),
+		},
+		Revision: revision,
+	})
+	ws.checkMemoryBudget()
 }
 
-func (ws *WebSocketHandler) unregisterUser(conn *websocket.Conn) {
-	// Get the user
-	u, _ := ws.userStorage.GetWhere(func(u *models.User) bool {
-		return u.Conn == conn
-	})
-	if u == nil {
+func (ws *WebSocketHandler) unregisterUser(conn *models.Connection) {
+	// Keep the membership of the user for the grace period, so the session can be resumed
+	u, detached := ws.detachUser(conn)
+	if u == nil || detached {
 		return
 	}
+	ws.removeUser(u)
+}
+
+// removeUser removes the user from the room and closes the room if it is empty.
+func (ws *WebSocketHandler) removeUser(u *models.User) {
+	ws.endSession(u.ID)
 
 	// Get the room
 	currentRoom, _ := ws.roomStorage.Get(u.RoomID)
@@ -259,90 +730,175 @@ func (ws *WebSocketHandler) unregisterUser(conn *websocket.Conn) {
 	}
 
 	// Remove the user from the room
+	spectator := currentRoom.IsSpectator(u.ID)
 	currentRoom.RemoveUser(u.ID)
 
 	// Check if the user was the leader
-	if currentRoom.LeaderID == u.ID {
+	leaderLeft := currentRoom.LeaderID == u.ID
+	if leaderLeft {
 		currentRoom.SetLeader("0")
 	}
 
 	// Remove the user from the storage
 	_ = ws.userStorage.Delete(u.ID)
 	ws.logger.Info("User unregistered", zap.String("userID", u.ID))
+	ws.notify(models.RoomEventUserLeft, currentRoom, u.ID)
+	if leaderLeft {
+		ws.notify(models.RoomEventLeaderChanged, currentRoom, u.ID)
+	}
 
 	// Check if the room is empty
-	if len(currentRoom.GetUsers()) == 0 {
-		_ = ws.roomStorage.Delete(currentRoom.BoardID)
+	if len(currentRoom.GetUsers()) == 0 && ws.closeRoom(currentRoom, u.ID) {
 		return
 	}
 
-	// Get the users ids
-	userIDs := make([]string, 0)
-	for _, u := range currentRoom.GetUsers() {
-		userIDs = append(userIDs, u.ID)
+	// The spectator who waits the longest takes the free place
+	if promotedID := currentRoom.PromoteSpectator(ws.roomLimit(currentRoom)); promotedID != "" {
+		ws.logger.Info("Spectator promoted",
+			zap.String("userID", promotedID), zap.String("boardID", currentRoom.BoardID))
 	}
 
 	// Send the user disconnected message
+	userIDs, spectatorIDs := currentRoom.GetMembers()
 	ws.sendUserDisconnected(MessageUserDisconnectedResponse{
 		Message: Message{
 			Event: EventUserDisconnected,
 		},
-		BoardID:  currentRoom.BoardID,
-		UserIDs:  userIDs,
-		LeaderID: currentRoom.LeaderID,
+		BoardID:      currentRoom.BoardID,
+		UserIDs:      userIDs,
+		SpectatorIDs: spectatorIDs,
+		LeaderID:     currentRoom.LeaderID,
+		User:         u.Participant(spectator),
+		Users:        currentRoom.GetParticipants(),
 	})
 }
 
-func (ws *WebSocketHandler) registerUser(conn *websocket.Conn, request MessageConnectRequest) {
-	userID, err := ws.cacheOrValidate(request.Jwt, request.BoardID)
+func (ws *WebSocketHandler) registerUser(ctx context.Context, conn *models.Connection, request MessageConnectRequest) {
+	jwtResponse, maxUsers, err := ws.cacheOrValidateUser(ctx, request.Jwt, request.BoardID)
 	if err != nil {
 		ws.logger.Error("Failed to validate", zap.Error(err))
 		return
 	}
+	userID := jwtResponse.ID
 
-	// Check if the user is already connected
+	// Check if the user is already connected, a new connect ends the session waiting for the resume
 	if v, _ := ws.userStorage.Get(userID); v != nil {
+		if v.Conn != nil || !ws.expireSession(userID, "") {
+			return
+		}
+	}
+
+	// Create a room if it doesn't exist, the first user chooses whether the room is encrypted
+	currentRoom, _ := ws.acquireRoom(request.BoardID, userID, request.Encrypted)
+	defer currentRoom.Unpin()
+
+	// Check if the user expects the same mode of the room
+	if currentRoom.Encrypted != request.Encrypted {
+		reason := "the room is not encrypted"
+		if currentRoom.Encrypted {
+			reason = "the room is encrypted"
+		}
+		ws.sendError(conn, ErrorCodeRoomModeMismatch, reason)
 		return
 	}
 
-	// Create a room if it doesn't exist
-	var currentRoom *models.Room
-	if currentRoom, _ = ws.roomStorage.Get(request.BoardID); currentRoom == nil {
-		currentRoom = models.NewRoom(request.BoardID)
-		_ = ws.roomStorage.Set(request.BoardID, currentRoom)
+	// The first user of the room sets the limit of the board checked with the access,
+	// the room could be created without users, e.g. by an import
+	if len(currentRoom.GetUsers()) == 0 {
+		currentRoom.SetMaxUsers(maxUsers)
 	}
 
-	// Store the user
+	// Add the user to the room if the room is not locked or full
 	newUser := &models.User{
 		ID:     userID,
 		RoomID: request.BoardID,
 		Conn:   conn,
 	}
-	err = ws.userStorage.Set(newUser.ID, newUser)
-	if err != nil {
+	newUser.Name, newUser.AvatarURL = profile(jwtResponse, request)
+	spectator, err := currentRoom.Join(newUser, ws.roomLimit(currentRoom), ws.spectateOverflow)
+	switch {
+	case errors.Is(err, models.ErrRoomLocked):
+		ws.sendError(conn, ErrorCodeRoomLocked, "the room is locked")
+		return
+	case errors.Is(err, models.ErrRoomFull):
+		ws.sendError(conn, ErrorCodeRoomFull, "the room is full")
 		return
 	}
 
-	// Add the user to the room
-	currentRoom.AddUser(newUser)
-
-	// Get the users ids
-	userIDs := make([]string, 0)
-	for _, u := range currentRoom.GetUsers() {
-		userIDs = append(userIDs, u.ID)
+	// Store the user
+	err = ws.userStorage.Set(newUser.ID, newUser)
+	if err != nil {
+		currentRoom.RemoveUser(newUser.ID)
+		return
 	}
+	ws.notify(models.RoomEventUserJoined, currentRoom, userID)
+	ws.startSession(conn, newUser, currentRoom)
 
 	// Send the user connected message
+	userIDs, spectatorIDs := currentRoom.GetMembers()
 	ws.sendUserConnected(MessageUserConnectedResponse{
 		Message: Message{
 			Event: EventUserConnected,
 		},
-		BoardID:  request.BoardID,
-		UserIDs:  userIDs,
-		LeaderID: currentRoom.LeaderID,
+		BoardID:      request.BoardID,
+		UserIDs:      userIDs,
+		SpectatorIDs: spectatorIDs,
+		LeaderID:     currentRoom.LeaderID,
+		User:         newUser.Participant(spectator),
+		Users:        currentRoom.GetParticipants(),
 	})
 
-	ws.logger.Info("User registered", zap.String("userID", newUser.ID), zap.String("roomID", newUser.RoomID))
+	// Send the current scene to the new user, e.g. the imported one
+	ws.sendScene(conn, currentRoom)
+	ws.sendChatHistory(conn, currentRoom)
+
+	ws.logger.Info(
+		"User registered",
+		zap.String("userID", newUser.ID),
+		zap.String("roomID", newUser.RoomID),
+		zap.Bool("spectator", spectator),
+	)
+}
+
+// sendScene sends the current scene of the room to the user.
+func (ws *WebSocketHandler) sendScene(conn *models.Connection, currentRoom *models.Room) {
+	if message := sceneMessage(currentRoom, currentRoom.GetRevision()); message != nil {
+		_ = conn.Send(message)
+	}
+}
+
+// sceneMessage builds the message carrying the current scene of the room, nil if the room has no scene.
+func sceneMessage(currentRoom *models.Room, revision int64) interface{} {
+	// The persisted encrypted scene
+	if scene := currentRoom.GetEncryptedScene(); scene != nil {
+		return MessageNewEncryptedDataResponse{
+			Message: Message{
+				Event: EventNewEncryptedData,
+			},
+			BoardID: currentRoom.BoardID,
+			Data: EncryptedData{
+				Payload: scene.Payload,
+				IV:      scene.IV,
+			},
+			Revision: revision,
+		}
+	}
+
+	// The plain scene
+	if elements := currentRoom.GetElements(); elements != "" && !currentRoom.Encrypted {
+		return MessageNewDataResponse{
+			Message: Message{
+				Event: EventNewData,
+			},
+			BoardID: currentRoom.BoardID,
+			Data: Data{
+				Elements: elements,
+				AppState: currentRoom.GetAppState(),
+			},
+			Revision: revision,
+		}
+	}
+	return nil
 }
 
 func (ws *WebSocketHandler) sendUserConnected(request MessageUserConnectedResponse) {
@@ -353,18 +909,7 @@ func (ws *WebSocketHandler) sendUserConnected(request MessageUserConnectedRespon
 	}
 
 	// Send the message to all the users in the room
-	for _, currentUser := range currentRoom.GetUsers() {
-		u, _ := ws.userStorage.Get(currentUser.ID)
-		if u == nil {
-			continue
-		}
-
-		err := u.Conn.WriteJSON(request)
-		if err != nil {
-			// Remove the user from the storage
-			_ = ws.userStorage.Delete(currentUser.ID)
-		}
-	}
+	ws.broadcastToRoom(currentRoom, request)
 }
 
 func (ws *WebSocketHandler) sendUserDisconnected(request MessageUserDisconnectedResponse) {
@@ -375,145 +920,175 @@ func (ws *WebSocketHandler) sendUserDisconnected(request MessageUserDisconnected
 	}
 
 	// Send the message to all the users in the room
+	ws.broadcastToRoom(currentRoom, request)
+}
+
+// preparedMessage is a message encoded once with a codec for all the recipients.
+type preparedMessage struct {
+	pm   *websocket.PreparedMessage
+	size int
+}
+
+// broadcastToRoom encodes the message once per codec and sends it to all the users in the room.
+func (ws *WebSocketHandler) broadcastToRoom(currentRoom *models.Room, message interface{}) {
+	ws.recordHistory(currentRoom, message)
+	prepared := make(map[string]*preparedMessage)
+
 	for _, currentUser := range currentRoom.GetUsers() {
+		// The users waiting for the session resume receive the missed events when they come back
 		u, _ := ws.userStorage.Get(currentUser.ID)
-		if u == nil {
+		if u == nil || u.Conn == nil {
 			continue
 		}
 
-		err := u.Conn.WriteJSON(request)
-		if err != nil {
-			// Remove the user from the storage
-			_ = ws.userStorage.Delete(currentUser.ID)
+		p, ok := prepared[u.Conn.Codec.Name()]
+		if !ok {
+			var err error
+			if p, err = prepareMessage(u.Conn.Codec, message); err != nil {
+				ws.logger.Error("Failed to prepare message", zap.Error(err))
+				return
+			}
+			prepared[u.Conn.Codec.Name()] = p
+		}
+
+		if err := u.Conn.WritePreparedMessage(p.pm, p.size); err != nil {
+			// Close the connection, the read loop will unregister the user
+			_ = u.Conn.Close()
 		}
 	}
 }
 
-func (ws *WebSocketHandler) validateJWT(jwt string) (string, error) {
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ws.jwtValidationURL, nil)
+func prepareMessage(c codec.Codec, message interface{}) (*preparedMessage, error) {
+	data, err := c.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	pm, err := websocket.NewPreparedMessage(c.MessageType(), data)
 	if err != nil {
-		return "", fmt.Errorf("failed to create validation request: %w", err)
+		return nil, fmt.Errorf("failed to prepare message: %w", err)
 	}
-	req.Header.Set(ws.jwtHeaderName, jwt)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	return &preparedMessage{pm: pm, size: len(data)}, nil
+}
+
+func (ws *WebSocketHandler) validateJWT(ctx context.Context, jwt string) (JWTValidationResponse, error) {
+	var jwtResponse JWTValidationResponse
+
+	header := http.Header{}
+	header.Set(ws.jwtHeaderName, jwt)
+	resp, err := ws.upstream.Get(ctx, ws.jwtValidationURL, header)
 	if err != nil {
-		return "", fmt.Errorf("failed to send validation request: %w", err)
+		return jwtResponse, fmt.Errorf("failed to send validation request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
-		return "", fmt.Errorf("unauthorized: %w", ErrValidatingJWT)
+		return jwtResponse, fmt.Errorf("unauthorized: %w", ErrValidatingJWT)
 	case http.StatusForbidden:
-		return "", fmt.Errorf("forbidden: %w", ErrValidatingJWT)
-	case http.StatusInternalServerError:
-		return "", fmt.Errorf("internal server error: %w", ErrValidatingJWT)
+		return jwtResponse, fmt.Errorf("forbidden: %w", ErrValidatingJWT)
 	}
 
-	var jwtResponse JWTValidationResponse
-	err = json.NewDecoder(resp.Body).Decode(&jwtResponse)
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxValidationResponseSize)).Decode(&jwtResponse)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode JWT response: %w", err)
+		return jwtResponse, fmt.Errorf("failed to decode JWT response: %w", err)
 	}
 	if jwtResponse.ID == "0" {
-		return "", ErrInvalidJWT
+		return jwtResponse, ErrInvalidJWT
 	}
 
-	return jwtResponse.ID, nil
+	return jwtResponse, nil
 }
 
-func (ws *WebSocketHandler) validateBoardAccess(boardID, jwt string) bool {
+// validateBoardAccess checks the access to the board, the response body is optional.
+// The error wraps ErrNoBoardAccess if the access is denied.
+func (ws *WebSocketHandler) validateBoardAccess(
+	ctx context.Context,
+	boardID, jwt string,
+) (BoardValidationResponse, error) {
+	var boardResponse BoardValidationResponse
 	fullURL, err := url.JoinPath(ws.boardValidationURL, boardID)
 	if err != nil {
-		ws.logger.Error("failed to join URL", zap.Error(err))
-		return false
+		return boardResponse, fmt.Errorf("failed to join URL: %w", err)
 	}
-	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, fullURL, nil)
-	req.Header.Set(ws.jwtHeaderName, jwt)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	header := http.Header{}
+	header.Set(ws.jwtHeaderName, jwt)
+	resp, err := ws.upstream.Get(ctx, fullURL, header)
 	if err != nil {
-		ws.logger.Error("failed to send board validation request", zap.Error(err))
-		return false
+		return boardResponse, fmt.Errorf("failed to send board validation request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		return true
-	default:
-		return false
+	if resp.StatusCode != http.StatusOK {
+		return boardResponse, fmt.Errorf("unexpected status code %d: %w", resp.StatusCode, ErrNoBoardAccess)
 	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, maxValidationResponseSize)).Decode(&boardResponse)
+	return boardResponse, nil
 }
 
-func messageDefiner(msg []byte) (interface{}, error) {
+func messageDefiner(c codec.Codec, msg []byte) (EventMessage, error) {
 	var message Message
-	if err := json.Unmarshal(msg, &message); err != nil {
+	if err := c.Unmarshal(msg, &message); err != nil {
 		return nil, ErrInvalidMessage
 	}
 	switch message.Event {
+	case EventHello:
+		return decode[MessageHelloRequest](c, msg)
 	case EventConnect:
-		var connectRequest MessageConnectRequest
-		if err := json.Unmarshal(msg, &connectRequest); err == nil {
-			return connectRequest, nil
-		} else {
-			return nil, fmt.Errorf("error Unmarshaling MessageConnectRequest: %w", err)
-		}
+		return decode[MessageConnectRequest](c, msg)
 	case EventNewData:
-		var newData MessageNewDataRequest
-		if err := json.Unmarshal(msg, &newData); err == nil {
-			return newData, nil
-		} else {
-			return nil, fmt.Errorf("error Unmarshaling MessageNewDataRequest: %w", err)
-		}
+		return decode[MessageNewDataRequest](c, msg)
+	case EventNewEncryptedData:
+		return decode[MessageNewEncryptedDataRequest](c, msg)
+	case EventUploadFile:
+		return decode[MessageUploadFileRequest](c, msg)
+	case EventGetFile:
+		return decode[MessageGetFileRequest](c, msg)
 	case EventSetLeader:
-		var setLeaderRequest MessageSetLeaderRequest
-		if err := json.Unmarshal(msg, &setLeaderRequest); err == nil {
-			return setLeaderRequest, nil
-		} else {
-			return nil, fmt.Errorf("error Unmarshaling MessageSetLeaderRequest: %w", err)
-		}
+		return decode[MessageSetLeaderRequest](c, msg)
+	case EventResume:
+		return decode[MessageResumeRequest](c, msg)
+	case EventAck:
+		return decode[MessageAckRequest](c, msg)
+	case EventChatMessage:
+		return decode[MessageChatRequest](c, msg)
+	case EventReaction:
+		return decode[MessageReactionRequest](c, msg)
 	}
 	return nil, ErrInvalidMessage
 }
 
-//nolint:nestif
-func (ws *WebSocketHandler) cacheOrValidate(jwt, boardID string) (string, error) {
-	var userID string
-
-	// Check if the user is in cache
-	if v, err := ws.cache.Get(jwt); v == nil {
-		if err != nil {
-			return "", fmt.Errorf("failed to get from cache: %w", err)
-		}
-		// Get the UserID from the JWT token
-		userID, err = ws.validateJWT(jwt)
-		if err != nil {
-			return "", fmt.Errorf("failed to validate JWT: %w", err)
-		}
+// decode unmarshals the message of the event into its request type.
+func decode[T EventMessage](c codec.Codec, msg []byte) (EventMessage, error) {
+	var request T
+	if err := c.Unmarshal(msg, &request); err != nil {
+		return nil, fmt.Errorf("error Unmarshaling %T: %w", request, err)
+	}
+	return request, nil
+}
 
-		// Check if the user has access to the board
-		if !ws.validateBoardAccess(boardID, jwt) {
-			return "", fmt.Errorf(
-				"user '%s' doesn't have access to the board '%s': %w",
-				userID,
-				boardID,
-				err,
-			)
-		}
+func (ws *WebSocketHandler) cacheOrValidate(ctx context.Context, jwt, boardID string) (string, error) {
+	jwtResponse, _, err := ws.cacheOrValidateUser(ctx, jwt, boardID)
+	if err != nil {
+		return "", err
+	}
+	return jwtResponse.ID, nil
+}
 
-		// Store the validation result
-		_ = ws.cache.SetWithTTL(jwt, userID, ws.cacheTTLInSeconds)
-	} else {
-		if err != nil {
-			return "", fmt.Errorf("failed to get from cache: %w", err)
-		}
-		var ok bool
-		userID, ok = v.(string)
-		if !ok {
-			return "", fmt.Errorf("failed to parse userID to string: %w", err)
-		}
+// cacheOrValidateUser checks the access of the JWT token to the board and returns the JWT validation response
+// with the profile of the user and the limit of the participants of the board room. The identity is cached
+// by the token and the access by the user and the board, so a token cached for one board is still checked
+// against the others.
+func (ws *WebSocketHandler) cacheOrValidateUser(
+	ctx context.Context,
+	jwt, boardID string,
+) (JWTValidationResponse, int, error) {
+	jwtResponse, err := ws.cacheOrValidateIdentity(ctx, jwt)
+	if err != nil {
+		return jwtResponse, 0, err
+	}
+	maxUsers, err := ws.cacheOrValidateAccess(ctx, jwt, jwtResponse.ID, boardID)
+	if err != nil {
+		return jwtResponse, 0, err
 	}
-	return userID, nil
+	return jwtResponse, maxUsers, nil
 }
@@ -0,0 +1,484 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/cache/inmemory"
+	"github.com/Icerzack/excaliroom/internal/codec"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+	inmemFile "github.com/Icerzack/excaliroom/internal/storage/file/inmemory"
+	inmemRoom "github.com/Icerzack/excaliroom/internal/storage/room/inmemory"
+	inmemUser "github.com/Icerzack/excaliroom/internal/storage/user/inmemory"
+)
+
+const (
+	// testTimeout is the time the tests wait for an event
+	testTimeout = 5 * time.Second
+
+	// testJwtHeader is the header of the JWT token sent to the validation URLs
+	testJwtHeader = "Authorization"
+
+	// testInvalidJwt is the token rejected by the test backend, any other token is the id of its user
+	testInvalidJwt = "invalid"
+
+	// testForbiddenBoard is the prefix of the boards the test backend denies the access to
+	testForbiddenBoard = "forbidden"
+
+	// testLimitedBoard is the prefix of the boards the test backend limits to a single participant
+	testLimitedBoard = "limited"
+
+	testBoardID = "board-1"
+
+	testUserID = "user-1"
+
+	testOtherUserID = "user-2"
+
+	testThirdUserID = "user-3"
+)
+
+// testBackend serves the JWT and the board validation URLs and counts the requests.
+type testBackend struct {
+	server        *httptest.Server
+	jwtRequests   *atomic.Int64
+	boardRequests *atomic.Int64
+}
+
+// newTestBackend starts the validation URLs; if hold is not nil, the JWT validations wait until it is closed.
+func newTestBackend(t *testing.T, hold <-chan struct{}) *testBackend {
+	t.Helper()
+	b := &testBackend{
+		jwtRequests:   &atomic.Int64{},
+		boardRequests: &atomic.Int64{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwt", func(w http.ResponseWriter, r *http.Request) {
+		b.jwtRequests.Add(1)
+		if hold != nil {
+			<-hold
+		}
+		jwt := r.Header.Get(testJwtHeader)
+		if jwt == testInvalidJwt {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(JWTValidationResponse{ID: jwt})
+	})
+	mux.HandleFunc("/boards/", func(w http.ResponseWriter, r *http.Request) {
+		b.boardRequests.Add(1)
+		if strings.HasPrefix(path.Base(r.URL.Path), testForbiddenBoard) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		response := BoardValidationResponse{Role: BoardRoleOwner}
+		if strings.HasPrefix(path.Base(r.URL.Path), testLimitedBoard) {
+			response.MaxUsers = 1
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	b.server = httptest.NewServer(mux)
+	t.Cleanup(b.server.Close)
+	return b
+}
+
+// newTestHandler creates the handler with the in-memory storages validating with the backend.
+// The handler is shut down when the test ends.
+func newTestHandler(t *testing.T, backend *testBackend, cfg Config) *WebSocketHandler {
+	t.Helper()
+	return newTestHandlerWithFiles(t, backend, cfg, inmemFile.NewStorage(file.Quota{}, zap.NewNop()))
+}
+
+// newTestHandlerWithFiles creates the handler like newTestHandler with the files storage.
+func newTestHandlerWithFiles(t *testing.T, backend *testBackend, cfg Config, files file.Storage) *WebSocketHandler {
+	t.Helper()
+	logger := zap.NewNop()
+	cfg.JwtHeaderName = testJwtHeader
+	cfg.JwtValidationURL = backend.server.URL + "/jwt"
+	cfg.BoardValidationURL = backend.server.URL + "/boards"
+	cfg.Logger = logger
+	c := inmemory.NewCache(&inmemory.Config{Logger: logger})
+	ws := NewWebSocketHandler(
+		inmemUser.NewStorage(logger),
+		inmemRoom.NewStorage(logger),
+		files,
+		c,
+		&cfg,
+	)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+		_ = c.Close()
+	})
+	return ws
+}
+
+// newTestServer serves the websocket endpoint of the handler and returns its URL.
+func newTestServer(t *testing.T, ws *WebSocketHandler) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(ws.Handle))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// testClient is a websocket client reading the messages in the background, so it answers the pings.
+type testClient struct {
+	conn     *websocket.Conn
+	codec    codec.Codec
+	messages chan []byte
+
+	// err is the error that ended the reading, it is set when messages is closed
+	err error
+}
+
+// dialTest opens the connection with the dialer, nil uses the default dialer.
+func dialTest(t *testing.T, url string, dialer *websocket.Dialer) *testClient {
+	t.Helper()
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+	c := &testClient{
+		conn:     conn,
+		codec:    codec.BySubprotocol(conn.Subprotocol()),
+		messages: make(chan []byte, 256),
+	}
+	go func() {
+		defer close(c.messages)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				c.err = err
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+	return c
+}
+
+// closed waits until the connection is closed and returns the error that closed it.
+func (c *testClient) closed(t *testing.T) error {
+	t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case _, ok := <-c.messages:
+			if !ok {
+				return c.err
+			}
+		case <-deadline:
+			t.Fatal("connection not closed in time")
+		}
+	}
+}
+
+// send encodes the message with the codec of the connection and writes it.
+func (c *testClient) send(t *testing.T, v interface{}) {
+	t.Helper()
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error: %v", err)
+	}
+	if err := c.conn.WriteMessage(c.codec.MessageType(), data); err != nil {
+		t.Fatalf("WriteMessage() unexpected error: %v", err)
+	}
+}
+
+// next returns the next message with the event, the other messages are skipped.
+// It returns false if the connection is closed or the event doesn't come in time.
+func (c *testClient) next(event string, timeout time.Duration) ([]byte, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return nil, false
+			}
+			var message Message
+			if c.codec.Unmarshal(msg, &message) == nil && message.Event == event {
+				return msg, true
+			}
+		case <-deadline:
+			return nil, false
+		}
+	}
+}
+
+// expect waits for the event and decodes it into v, v can be nil.
+func (c *testClient) expect(t *testing.T, event string, v interface{}) {
+	t.Helper()
+	msg, ok := c.next(event, testTimeout)
+	if !ok {
+		t.Fatalf("event %q not received", event)
+	}
+	if v == nil {
+		return
+	}
+	if err := c.codec.Unmarshal(msg, v); err != nil {
+		t.Fatalf("Unmarshal(%s) unexpected error: %v", event, err)
+	}
+}
+
+// expectNone checks that the event doesn't come within the time.
+func (c *testClient) expectNone(t *testing.T, event string, wait time.Duration) {
+	t.Helper()
+	if _, ok := c.next(event, wait); ok {
+		t.Fatalf("event %q received, want none", event)
+	}
+}
+
+// connect joins the board as the user and waits until the user is connected.
+func (c *testClient) connect(t *testing.T, userID, boardID string) MessageUserConnectedResponse {
+	t.Helper()
+	c.send(t, MessageConnectRequest{Message: Message{Event: EventConnect}, BoardID: boardID, Jwt: userID})
+	var response MessageUserConnectedResponse
+	c.expect(t, EventUserConnected, &response)
+	return response
+}
+
+// setLeader takes the leadership of the board as the user.
+func (c *testClient) setLeader(t *testing.T, userID, boardID string) {
+	t.Helper()
+	c.send(t, MessageSetLeaderRequest{Message: Message{Event: EventSetLeader}, BoardID: boardID, Jwt: userID})
+}
+
+func newDataRequest(userID, boardID, elements string) MessageNewDataRequest {
+	return MessageNewDataRequest{
+		Message: Message{Event: EventNewData},
+		BoardID: boardID,
+		Jwt:     userID,
+		Data:    Data{Elements: elements},
+	}
+}
+
+// waitFor polls the condition until it holds or the test timeout passes.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMessagesProcessedInOrder(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{MessageQueueSize: 1})
+	client := dialTest(t, newTestServer(t, ws), nil)
+	client.connect(t, testUserID, testBoardID)
+
+	// The scene updates are accepted only after the leadership is taken, so they rely on the order
+	client.setLeader(t, testUserID, testBoardID)
+	const updates = 5
+	for i := 1; i <= updates; i++ {
+		elements := `[{"id":"a","type":"rectangle","x":` + strconv.Itoa(i) + `}]`
+		client.send(t, newDataRequest(testUserID, testBoardID, elements))
+	}
+
+	for i := 1; i <= updates; i++ {
+		var response MessageNewDataResponse
+		client.expect(t, EventNewData, &response)
+		want := `[{"id":"a","type":"rectangle","x":` + strconv.Itoa(i) + `}]`
+		if response.Revision != int64(i) || response.Data.Elements != want {
+			t.Fatalf("update %d: got revision %d with %s, want revision %d with %s",
+				i, response.Revision, response.Data.Elements, i, want)
+		}
+	}
+}
+
+func TestHandlerSlotsAreShared(t *testing.T) {
+	hold := make(chan struct{})
+	release := sync.OnceFunc(func() { close(hold) })
+	t.Cleanup(release)
+
+	ws := newTestHandler(t, newTestBackend(t, hold), Config{MaxConcurrentHandlers: 1})
+	url := newTestServer(t, ws)
+	blocked := dialTest(t, url, nil)
+	waiting := dialTest(t, url, nil)
+
+	// The validation of the connect holds the only slot, so the other connection waits for it
+	blocked.send(t, MessageConnectRequest{Message: Message{Event: EventConnect}, BoardID: testBoardID, Jwt: testUserID})
+	waitFor(t, func() bool { return len(ws.handlerSlots) == 1 })
+	waiting.send(t, MessageHelloRequest{Message: Message{Event: EventHello}, ProtocolVersion: ProtocolVersionCurrent})
+	waiting.expectNone(t, EventWelcome, 200*time.Millisecond)
+
+	release()
+	blocked.expect(t, EventUserConnected, nil)
+	waiting.expect(t, EventWelcome, nil)
+}
+
+func TestHeartbeatReapsDeadConnections(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{PingInterval: 1, PongWait: 2})
+	url := newTestServer(t, ws)
+
+	// The client reading the messages answers the pings, the other one never does
+	alive := dialTest(t, url, nil)
+	dead, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+	defer dead.Close()
+	waitFor(t, func() bool { return len(ws.connections.GetAll()) == 2 })
+
+	waitFor(t, func() bool { return len(ws.connections.GetAll()) == 1 })
+	alive.send(t, MessageHelloRequest{Message: Message{Event: EventHello}, ProtocolVersion: ProtocolVersionCurrent})
+	alive.expect(t, EventWelcome, nil)
+}
+
+// hello negotiates the capabilities and returns the agreed ones.
+func (c *testClient) hello(t *testing.T, capabilities ...string) []string {
+	t.Helper()
+	c.send(t, MessageHelloRequest{
+		Message:         Message{Event: EventHello},
+		ProtocolVersion: ProtocolVersionCurrent,
+		Capabilities:    capabilities,
+	})
+	var response MessageWelcomeResponse
+	c.expect(t, EventWelcome, &response)
+	return response.Capabilities
+}
+
+func TestBroadcastCompression(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{EnableCompression: true, CompressionThreshold: 16})
+	url := newTestServer(t, ws)
+	compressing := &websocket.Dialer{EnableCompression: true}
+
+	leader := dialTest(t, url, compressing)
+	compressed := dialTest(t, url, compressing)
+	declined := dialTest(t, url, compressing)
+	uncompressed := dialTest(t, url, nil)
+
+	// The compression is agreed only if it was negotiated and the client keeps the capability
+	tests := []struct {
+		name   string
+		client *testClient
+		offer  []string
+		want   bool
+	}{
+		{name: "leader", client: leader, offer: []string{CapabilityCompression}, want: true},
+		{name: "compressed", client: compressed, offer: []string{CapabilityCompression}, want: true},
+		{name: "declined", client: declined, want: false},
+		{name: "not negotiated", client: uncompressed, offer: []string{CapabilityCompression}, want: false},
+	}
+	for i, tt := range tests {
+		agreed := tt.client.hello(t, tt.offer...)
+		if got := len(agreed) == 1 && agreed[0] == CapabilityCompression; got != tt.want {
+			t.Fatalf("%s: agreed capabilities %v, want compression %v", tt.name, agreed, tt.want)
+		}
+		tt.client.connect(t, "user-"+strconv.Itoa(i), testBoardID)
+	}
+
+	// The message over the threshold reaches the users with and without the compression
+	leader.setLeader(t, "user-0", testBoardID)
+	elements := `[{"id":"a","text":"` + strings.Repeat("compressible ", 100) + `","type":"text"}]`
+	leader.send(t, newDataRequest("user-0", testBoardID, elements))
+	for _, tt := range tests[1:] {
+		var response MessageNewDataResponse
+		tt.client.expect(t, EventNewData, &response)
+		if response.Data.Elements != elements {
+			t.Errorf("%s: got elements %s, want %s", tt.name, response.Data.Elements, elements)
+		}
+	}
+}
+
+func TestMessageDefiner(t *testing.T) {
+	tests := []struct {
+		event   string
+		want    EventMessage
+		wantErr bool
+	}{
+		{event: EventHello, want: MessageHelloRequest{}},
+		{event: EventConnect, want: MessageConnectRequest{}},
+		{event: EventNewData, want: MessageNewDataRequest{}},
+		{event: EventNewEncryptedData, want: MessageNewEncryptedDataRequest{}},
+		{event: EventUploadFile, want: MessageUploadFileRequest{}},
+		{event: EventGetFile, want: MessageGetFileRequest{}},
+		{event: EventSetLeader, want: MessageSetLeaderRequest{}},
+		{event: EventResume, want: MessageResumeRequest{}},
+		{event: EventAck, want: MessageAckRequest{}},
+		{event: EventChatMessage, want: MessageChatRequest{}},
+		{event: EventReaction, want: MessageReactionRequest{}},
+		{event: EventWelcome, wantErr: true},
+		{event: "unknown", wantErr: true},
+	}
+
+	for _, c := range []codec.Codec{codec.NewJSON(), codec.NewMsgpack()} {
+		for _, tt := range tests {
+			t.Run(c.Name()+"/"+tt.event, func(t *testing.T) {
+				msg, err := c.Marshal(map[string]string{"event": tt.event, "board_id": testBoardID})
+				if err != nil {
+					t.Fatalf("Marshal() unexpected error: %v", err)
+				}
+				got, err := messageDefiner(c, msg)
+				if tt.wantErr {
+					if !errors.Is(err, ErrInvalidMessage) {
+						t.Errorf("messageDefiner() error = %v, want %v", err, ErrInvalidMessage)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("messageDefiner() unexpected error: %v", err)
+				}
+				if reflect.TypeOf(got) != reflect.TypeOf(tt.want) || got.GetEvent() != tt.event {
+					t.Errorf("messageDefiner() = %T with event %s, want %T", got, got.GetEvent(), tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMessageDefinerRejectsInvalidMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{name: "not a message", msg: `not json`},
+		{name: "wrong field type", msg: `{"event":"connect","board_id":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := messageDefiner(codec.NewJSON(), []byte(tt.msg)); err == nil {
+				t.Error("messageDefiner() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestMsgpackSubprotocol(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	client := dialTest(t, newTestServer(t, ws), &websocket.Dialer{Subprotocols: codec.Subprotocols()})
+	if client.codec.Name() != codec.MsgpackSubprotocol {
+		t.Fatalf("negotiated codec %s, want %s", client.codec.Name(), codec.MsgpackSubprotocol)
+	}
+
+	if agreed := client.hello(t, CapabilityBinary); len(agreed) != 1 || agreed[0] != CapabilityBinary {
+		t.Fatalf("agreed capabilities %v, want %s", agreed, CapabilityBinary)
+	}
+	response := client.connect(t, testUserID, testBoardID)
+	if response.User.ID != testUserID {
+		t.Errorf("connected user %s, want %s", response.User.ID, testUserID)
+	}
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/scene"
+)
+
+// Board roles returned by the board validation URL that allow to manage the board.
+const (
+	BoardRoleOwner = "owner"
+	BoardRoleAdmin = "admin"
+)
+
+// maxValidationResponseSize is the maximum size of the validation response body that is read.
+const maxValidationResponseSize = 1 << 20
+
+// ValidateOwner checks that the JWT token belongs to an owner or an admin of the board and returns the user id.
+// The result is not cached, so the revoked roles take effect immediately.
+func (ws *WebSocketHandler) ValidateOwner(ctx context.Context, jwt, boardID string) (string, error) {
+	jwtResponse, err := ws.validateJWT(ctx, jwt)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate JWT: %w", err)
+	}
+	userID := jwtResponse.ID
+
+	boardResponse, err := ws.validateBoardAccess(ctx, boardID, jwt)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate access of user '%s' to the board '%s': %w", userID, boardID, err)
+	}
+	if boardResponse.Role != BoardRoleOwner && boardResponse.Role != BoardRoleAdmin {
+		return "", fmt.Errorf("user '%s' can't manage the board '%s': %w", userID, boardID, ErrNotBoardOwner)
+	}
+
+	return userID, nil
+}
+
+// ImportScene stores the files and replaces the scene of the board room, creating the room if it doesn't exist,
+// and sends the new scene to the users in the room. The scene and the quota of the files are checked first,
+// so a rejected import stores nothing.
+func (ws *WebSocketHandler) ImportScene(boardID, userID, elements, appState string, files []*models.File) error {
+	if len(elements)+len(appState) > ws.maxSceneSize {
+		return ErrSceneTooLarge
+	}
+	elements, err := ws.sanitizeScene(elements, appState)
+	if err != nil {
+		return err
+	}
+	if err = ws.fileStorage.Check(files); err != nil {
+		return fmt.Errorf("failed to check files: %w", err)
+	}
+
+	// Create a room if it doesn't exist, the budget is checked once the room is released
+	currentRoom, _ := ws.acquireRoom(boardID, userID, false)
+	defer ws.checkMemoryBudget()
+	defer currentRoom.Unpin()
+
+	currentRoom.RoomMutex.Lock()
+	defer currentRoom.RoomMutex.Unlock()
+
+	// The encrypted rooms accept only the encrypted scenes
+	if currentRoom.Encrypted {
+		return ErrRoomEncrypted
+	}
+
+	// Store the files before the scene, so the users can fetch them as soon as they get the scene
+	for _, f := range files {
+		if err = ws.fileStorage.Set(f); err != nil {
+			return fmt.Errorf("failed to store file: %w", err)
+		}
+	}
+
+	// Update the current data
+	currentRoom.SetElements(elements)
+	currentRoom.SetAppState(appState)
+	revision := currentRoom.NextRevision()
+
+	ws.logger.Info("Scene imported", zap.String("boardID", boardID), zap.String("userID", userID))
+	ws.notifier.Notify(models.NewSceneUpdatedEvent(currentRoom, userID, elements, appState))
+
+	// Send the new data to all the users in the room
+	ws.broadcastToRoom(currentRoom, MessageNewDataResponse{
+		Message: Message{
+			Event: EventNewData,
+		},
+		BoardID: boardID,
+		Data: Data{
+			Elements: elements,
+			AppState: appState,
+		},
+		Revision: revision,
+	})
+
+	return nil
+}
+
+// sanitizeScene validates the scene and returns the elements without the unknown fields and the unsafe links.
+func (ws *WebSocketHandler) sanitizeScene(elements, appState string) (string, error) {
+	sanitized, err := scene.Sanitize(elements, ws.sceneLimits)
+	if err != nil {
+		return "", fmt.Errorf("failed to sanitize elements: %w", err)
+	}
+	if err = scene.ValidateAppState(appState); err != nil {
+		return "", fmt.Errorf("failed to validate app state: %w", err)
+	}
+	return sanitized, nil
+}
@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+	inmemFile "github.com/Icerzack/excaliroom/internal/storage/file/inmemory"
+)
+
+func TestImportScene(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	client := dialTest(t, newTestServer(t, ws), nil)
+	client.connect(t, testUserID, testBoardID)
+
+	elements := `[{"id":"a","type":"rectangle"}]`
+	files := []*models.File{{ID: "f", BoardID: testBoardID, MimeType: "image/png", Data: []byte("png")}}
+	if err := ws.ImportScene(testBoardID, testUserID, elements, "{}", files); err != nil {
+		t.Fatalf("ImportScene() unexpected error: %v", err)
+	}
+
+	// The users of the room get the imported scene
+	var response MessageNewDataResponse
+	client.expect(t, EventNewData, &response)
+	if response.Data.Elements != elements || response.Revision != 1 {
+		t.Errorf("got %s with revision %d, want %s with revision 1",
+			response.Data.Elements, response.Revision, elements)
+	}
+	if f, err := ws.fileStorage.Get(testBoardID, "f"); err != nil || f == nil {
+		t.Errorf("file not stored: %v", err)
+	}
+}
+
+func TestImportSceneCreatesRoom(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	elements := `[{"id":"a","type":"rectangle"}]`
+	if err := ws.ImportScene(testBoardID, testUserID, elements, "{}", nil); err != nil {
+		t.Fatalf("ImportScene() unexpected error: %v", err)
+	}
+
+	// The users connecting later get the imported scene
+	client := dialTest(t, newTestServer(t, ws), nil)
+	client.connect(t, testUserID, testBoardID)
+	var response MessageNewDataResponse
+	client.expect(t, EventNewData, &response)
+	if response.Data.Elements != elements {
+		t.Errorf("got %s, want %s", response.Data.Elements, elements)
+	}
+}
+
+func TestImportSceneRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int
+		encrypted bool
+		elements  string
+		files     []*models.File
+		wantErr   error
+	}{
+		{
+			name:     "scene too large",
+			maxSize:  8,
+			elements: `[{"id":"a","type":"rectangle"}]`,
+			wantErr:  ErrSceneTooLarge,
+		},
+		{
+			name:      "encrypted room",
+			encrypted: true,
+			elements:  `[]`,
+			wantErr:   ErrRoomEncrypted,
+		},
+		{
+			name:     "files over the quota",
+			elements: `[]`,
+			files:    []*models.File{{ID: "f", BoardID: testBoardID, Data: []byte("too large")}},
+			wantErr:  file.ErrFileTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := inmemFile.NewStorage(file.Quota{MaxFileSize: 4}, zap.NewNop())
+			ws := newTestHandlerWithFiles(t, newTestBackend(t, nil), Config{MaxSceneSize: tt.maxSize}, files)
+			if tt.encrypted {
+				client := dialTest(t, newTestServer(t, ws), nil)
+				client.connectEncrypted(t, testUserID, testBoardID)
+			}
+
+			err := ws.ImportScene(testBoardID, testUserID, tt.elements, "{}", tt.files)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportScene() error = %v, want %v", err, tt.wantErr)
+			}
+			// A rejected import stores nothing
+			if f, _ := files.Get(testBoardID, "f"); f != nil {
+				t.Error("file stored by the rejected import")
+			}
+		})
+	}
+}
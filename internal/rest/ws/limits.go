@@ -0,0 +1,25 @@
+package ws
+
+import (
+	"github.com/Icerzack/excaliroom/internal/ratelimit"
+)
+
+// RateLimit is a token bucket limit for a single event type.
+type RateLimit = ratelimit.Limit
+
+// defaultRateLimits returns the limits used for the events that are not configured.
+func defaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		EventHello:            {Rate: 1, Burst: 3},
+		EventConnect:          {Rate: 1, Burst: 5},
+		EventSetLeader:        {Rate: 2, Burst: 5},
+		EventNewData:          {Rate: 30, Burst: 60},
+		EventNewEncryptedData: {Rate: 30, Burst: 60},
+		EventUploadFile:       {Rate: 2, Burst: 10},
+		EventGetFile:          {Rate: 10, Burst: 30},
+		EventResume:           {Rate: 1, Burst: 5},
+		EventAck:              {Rate: 10, Burst: 30},
+		EventChatMessage:      {Rate: 2, Burst: 10},
+		EventReaction:         {Rate: 5, Burst: 20},
+	}
+}
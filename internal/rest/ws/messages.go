@@ -1,17 +1,51 @@
 package ws
 
+import (
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+//nolint:tagliatelle
 type JWTValidationResponse struct {
-	ID string `json:"id"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+//nolint:tagliatelle
+type BoardValidationResponse struct {
+	Role     string `json:"role"`
+	MaxUsers int    `json:"max_users"`
 }
 
 type Message struct {
 	Event string `json:"event"`
 }
 
+func (m Message) GetEvent() string {
+	return m.Event
+}
+
+type MessageHelloRequest struct {
+	Message
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+type MessageWelcomeResponse struct {
+	Message
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
+}
+
 type MessageConnectRequest struct {
 	Message
-	BoardID string `json:"board_id"`
-	Jwt     string `json:"jwt"`
+	BoardID   string `json:"board_id"`
+	Jwt       string `json:"jwt"`
+	Encrypted bool   `json:"encrypted"`
+
+	// Name and AvatarURL are used if the JWT validation response doesn't have them
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
 }
 
 type MessageNewDataRequest struct {
@@ -24,9 +58,12 @@ type MessageNewDataRequest struct {
 //nolint:tagliatelle
 type MessageUserConnectedResponse struct {
 	Message
-	BoardID  string   `json:"board_id"`
-	UserIDs  []string `json:"user_ids"`
-	LeaderID string   `json:"leader_id"`
+	BoardID      string               `json:"board_id"`
+	UserIDs      []string             `json:"user_ids"`
+	SpectatorIDs []string             `json:"spectator_ids,omitempty"`
+	LeaderID     string               `json:"leader_id"`
+	User         models.Participant   `json:"user"`
+	Users        []models.Participant `json:"users"`
 }
 
 type MessageSetLeaderRequest struct {
@@ -50,18 +87,173 @@ type MessageUserFailedToConnectResponse struct {
 //nolint:tagliatelle
 type MessageUserDisconnectedResponse struct {
 	Message
-	BoardID  string   `json:"board_id"`
-	UserIDs  []string `json:"user_ids"`
-	LeaderID string   `json:"leader_id"`
+	BoardID      string               `json:"board_id"`
+	UserIDs      []string             `json:"user_ids"`
+	SpectatorIDs []string             `json:"spectator_ids,omitempty"`
+	LeaderID     string               `json:"leader_id"`
+	User         models.Participant   `json:"user"`
+	Users        []models.Participant `json:"users"`
 }
 
 type MessageNewDataResponse struct {
 	Message
-	BoardID string `json:"board_id"`
-	Data    Data   `json:"data"`
+	BoardID  string `json:"board_id"`
+	Data     Data   `json:"data"`
+	Revision int64  `json:"revision"`
+}
+
+type MessageNewEncryptedDataRequest struct {
+	Message
+	BoardID string        `json:"board_id"`
+	Jwt     string        `json:"jwt"`
+	Data    EncryptedData `json:"data"`
+}
+
+type MessageNewEncryptedDataResponse struct {
+	Message
+	BoardID  string        `json:"board_id"`
+	Data     EncryptedData `json:"data"`
+	Revision int64         `json:"revision"`
+}
+
+type EncryptedData struct {
+	Payload []byte `json:"payload"`
+	IV      []byte `json:"iv"`
 }
 
 type Data struct {
 	Elements string `json:"elements"`
 	AppState string `json:"app_state"`
 }
+
+type MessageErrorResponse struct {
+	Message
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+type MessageUploadFileRequest struct {
+	Message
+	BoardID string   `json:"board_id"`
+	Jwt     string   `json:"jwt"`
+	File    FileData `json:"file"`
+}
+
+type MessageFileUploadedResponse struct {
+	Message
+	BoardID  string `json:"board_id"`
+	FileID   string `json:"file_id"`
+	MimeType string `json:"mime_type"`
+}
+
+type MessageGetFileRequest struct {
+	Message
+	BoardID string `json:"board_id"`
+	Jwt     string `json:"jwt"`
+	FileID  string `json:"file_id"`
+}
+
+type MessageFileResponse struct {
+	Message
+	BoardID string   `json:"board_id"`
+	File    FileData `json:"file"`
+}
+
+type FileData struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+	Created  int64  `json:"created"`
+}
+
+//nolint:tagliatelle
+type MessageServerShutdownResponse struct {
+	Message
+	Reason string `json:"reason"`
+
+	// ReconnectAfter is the delay before the client reconnects in milliseconds
+	ReconnectAfter int64 `json:"reconnect_after"`
+}
+
+//nolint:tagliatelle
+type MessageSessionResponse struct {
+	Message
+	BoardID     string `json:"board_id"`
+	ResumeToken string `json:"resume_token"`
+	Revision    int64  `json:"revision"`
+}
+
+//nolint:tagliatelle
+type MessageResumeRequest struct {
+	Message
+	BoardID     string `json:"board_id"`
+	Jwt         string `json:"jwt"`
+	ResumeToken string `json:"resume_token"`
+}
+
+//nolint:tagliatelle
+type MessageResumedResponse struct {
+	Message
+	BoardID      string               `json:"board_id"`
+	ResumeToken  string               `json:"resume_token"`
+	Revision     int64                `json:"revision"`
+	UserIDs      []string             `json:"user_ids"`
+	SpectatorIDs []string             `json:"spectator_ids,omitempty"`
+	LeaderID     string               `json:"leader_id"`
+	Users        []models.Participant `json:"users"`
+}
+
+type MessageAckRequest struct {
+	Message
+	BoardID  string `json:"board_id"`
+	Jwt      string `json:"jwt"`
+	Revision int64  `json:"revision"`
+}
+
+type MessageRoomLockedResponse struct {
+	Message
+	BoardID string `json:"board_id"`
+	Locked  bool   `json:"locked"`
+}
+
+type MessageChatRequest struct {
+	Message
+	BoardID string         `json:"board_id"`
+	Jwt     string         `json:"jwt"`
+	Text    string         `json:"text"`
+	Data    *EncryptedData `json:"data,omitempty"`
+}
+
+//nolint:tagliatelle
+type MessageChatResponse struct {
+	Message
+	BoardID   string         `json:"board_id"`
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Text      string         `json:"text,omitempty"`
+	Data      *EncryptedData `json:"data,omitempty"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+//nolint:tagliatelle
+type MessageChatHistoryResponse struct {
+	Message
+	BoardID  string               `json:"board_id"`
+	Messages []models.ChatMessage `json:"messages"`
+}
+
+type MessageReactionRequest struct {
+	Message
+	BoardID string `json:"board_id"`
+	Jwt     string `json:"jwt"`
+	Emoji   string `json:"emoji"`
+}
+
+//nolint:tagliatelle
+type MessageReactionResponse struct {
+	Message
+	BoardID   string `json:"board_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	Timestamp int64  `json:"timestamp"`
+}
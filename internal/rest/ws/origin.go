@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// originChecker checks the Origin header of the upgrade requests against the allow-list.
+type originChecker struct {
+	// patterns is the list of allowed origins
+	patterns []originPattern
+
+	// rejected is the number of rejected upgrade requests
+	rejected *atomic.Uint64
+
+	logger *zap.Logger
+}
+
+// originPattern is an allowed origin, e.g. "https://example.com", "*.example.com" or "http://localhost:3000".
+type originPattern struct {
+	// scheme is the required scheme, empty means any
+	scheme string
+
+	// host is the required host without the port, it starts with "*." for wildcard subdomains
+	host string
+
+	// port is the required port, empty means any
+	port string
+}
+
+func newOriginChecker(allowedOrigins []string, logger *zap.Logger) *originChecker {
+	patterns := make([]originPattern, 0, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin == "" {
+			continue
+		}
+		pattern := originPattern{host: origin}
+		if scheme, host, ok := strings.Cut(origin, "://"); ok {
+			pattern = originPattern{scheme: scheme, host: host}
+		}
+		pattern.host = strings.TrimSuffix(pattern.host, "/")
+		if host, port, err := net.SplitHostPort(pattern.host); err == nil {
+			pattern.host, pattern.port = host, port
+		}
+		pattern.host = strings.TrimSuffix(strings.TrimPrefix(pattern.host, "["), "]")
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		logger.Warn("No allowed origins configured, websocket connections are accepted from the same origin only")
+	}
+	return &originChecker{
+		patterns: patterns,
+		rejected: &atomic.Uint64{},
+		logger:   logger,
+	}
+}
+
+// Check reports whether the upgrade request comes from an allowed origin.
+func (c *originChecker) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	// Non-browser clients don't send the Origin header
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil {
+		// Without the allow-list only the same origin is allowed, as the default check of the upgrader does
+		if len(c.patterns) == 0 && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, pattern := range c.patterns {
+			if pattern.matches(strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), originPort(u)) {
+				return true
+			}
+		}
+	}
+
+	rejected := c.rejected.Add(1)
+	c.logger.Warn("Origin rejected", zap.String("origin", origin), zap.Uint64("rejectedTotal", rejected))
+	return false
+}
+
+// Rejected returns the number of the upgrade requests rejected because of their origin.
+func (c *originChecker) Rejected() uint64 {
+	return c.rejected.Load()
+}
+
+// matches reports whether the origin with the scheme, the host and the port is allowed by the pattern.
+func (p originPattern) matches(scheme, host, port string) bool {
+	if p.scheme != "" && p.scheme != scheme {
+		return false
+	}
+	if p.port != "" && p.port != port {
+		return false
+	}
+	switch {
+	case p.host == "*":
+		return true
+	case strings.HasPrefix(p.host, "*."):
+		// The wildcard matches any subdomain, but not the domain itself
+		return strings.HasSuffix(host, p.host[1:])
+	default:
+		return p.host == host
+	}
+}
+
+// originPort returns the port of the origin, the default port of the scheme if the origin has none.
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOriginPatternMatches(t *testing.T) {
+	const (
+		plain     = "http"
+		secure    = "https"
+		domain    = "example.com"
+		wildcard  = "*." + domain
+		localhost = "localhost"
+		port      = "443"
+		devPort   = "3000"
+	)
+	tests := []struct {
+		name    string
+		pattern originPattern
+		scheme  string
+		host    string
+		port    string
+		want    bool
+	}{
+		{"any origin", originPattern{host: "*"}, secure, domain, port, true},
+		{"exact host", originPattern{host: domain}, secure, domain, port, true},
+		{"other host", originPattern{host: domain}, secure, "example.org", port, false},
+		{"any port", originPattern{host: domain}, plain, domain, "8080", true},
+		{"same scheme", originPattern{scheme: secure, host: domain}, secure, domain, port, true},
+		{"other scheme", originPattern{scheme: secure, host: domain}, plain, domain, "80", false},
+		{"same port", originPattern{host: localhost, port: devPort}, plain, localhost, devPort, true},
+		{"other port", originPattern{host: localhost, port: devPort}, plain, localhost, "3001", false},
+		{"wildcard subdomain", originPattern{host: wildcard}, secure, "app." + domain, port, true},
+		{"wildcard nested subdomain", originPattern{host: wildcard}, secure, "a.b." + domain, port, true},
+		{"wildcard bare domain", originPattern{host: wildcard}, secure, domain, port, false},
+		{"wildcard suffix", originPattern{host: wildcard}, secure, "evil" + domain, port, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pattern.matches(tt.scheme, tt.host, tt.port); got != tt.want {
+				t.Errorf("matches(%s, %s, %s) = %v, want %v", tt.scheme, tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginCheckerCheck(t *testing.T) {
+	const (
+		host      = "excaliroom.example.com"
+		appOrigin = "https://app.example.com"
+		localhost = "http://localhost:3000"
+	)
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		want           bool
+	}{
+		{
+			name:   "no origin header",
+			origin: "",
+			want:   true,
+		},
+		{
+			name:   "same origin without allow-list",
+			origin: "https://" + host,
+			want:   true,
+		},
+		{
+			name:   "cross origin without allow-list",
+			origin: "https://evil.example.org",
+			want:   false,
+		},
+		{
+			name:           "allowed origin",
+			allowedOrigins: []string{appOrigin},
+			origin:         appOrigin,
+			want:           true,
+		},
+		{
+			name:           "allowed origin is case insensitive",
+			allowedOrigins: []string{" HTTPS://App.Example.com/ "},
+			origin:         "https://APP.example.com",
+			want:           true,
+		},
+		{
+			name:           "allowed origin with port",
+			allowedOrigins: []string{localhost},
+			origin:         localhost,
+			want:           true,
+		},
+		{
+			name:           "allowed origin with other port",
+			allowedOrigins: []string{localhost},
+			origin:         "http://localhost:3001",
+			want:           false,
+		},
+		{
+			name:           "default port of the scheme",
+			allowedOrigins: []string{"https://app.example.com:443"},
+			origin:         appOrigin,
+			want:           true,
+		},
+		{
+			name:           "IPv6 host with port",
+			allowedOrigins: []string{"http://[::1]:3000"},
+			origin:         "http://[::1]:3000",
+			want:           true,
+		},
+		{
+			name:           "wildcard subdomain with port",
+			allowedOrigins: []string{"*.example.com:8080"},
+			origin:         "http://app.example.com:8080",
+			want:           true,
+		},
+		{
+			name:           "not allowed origin",
+			allowedOrigins: []string{appOrigin},
+			origin:         "https://" + host,
+			want:           false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newOriginChecker(tt.allowedOrigins, zap.NewNop())
+			r := httptest.NewRequest(http.MethodGet, "http://"+host+"/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checker.Check(r); got != tt.want {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+			var wantRejected uint64
+			if !tt.want {
+				wantRejected = 1
+			}
+			if got := checker.Rejected(); got != wantRejected {
+				t.Errorf("Rejected() = %d, want %d", got, wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// roomLimit returns the maximum number of the participants of the room, zero means unlimited.
+// The limit of the board returned by the board validation URL takes precedence over the server limit.
+func (ws *WebSocketHandler) roomLimit(currentRoom *models.Room) int {
+	if maxUsers := currentRoom.GetMaxUsers(); maxUsers > 0 {
+		return maxUsers
+	}
+	return ws.maxRoomUsers
+}
+
+// LockRoom locks or unlocks the board. The users of the locked board stay, but no new users can join it.
+// The lock is kept when the room of the board is closed and applies to the rooms created later.
+func (ws *WebSocketHandler) LockRoom(boardID, userID string, locked bool) error {
+	ws.roomsMtx.Lock()
+	currentRoom, _ := ws.roomStorage.Get(boardID)
+	if _, ok := ws.locks[boardID]; !ok && !locked && currentRoom == nil && ws.snapshotStorage == nil {
+		// Nothing to unlock, with the snapshots the board could be locked by the snapshot of its evicted room
+		ws.roomsMtx.Unlock()
+		return nil
+	}
+	ws.locks[boardID] = locked
+	if currentRoom != nil {
+		currentRoom.SetLocked(locked)
+	}
+	ws.roomsMtx.Unlock()
+
+	ws.logger.Info("Room lock changed",
+		zap.String("boardID", boardID), zap.String("userID", userID), zap.Bool("locked", locked))
+	if currentRoom == nil {
+		return nil
+	}
+
+	// Send the lock to all the users in the room
+	ws.broadcastToRoom(currentRoom, MessageRoomLockedResponse{
+		Message: Message{
+			Event: EventRoomLocked,
+		},
+		BoardID: boardID,
+		Locked:  locked,
+	})
+	return nil
+}
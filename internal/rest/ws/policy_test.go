@@ -0,0 +1,140 @@
+package ws
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// expectError waits for the error and checks its code.
+func (c *testClient) expectError(t *testing.T, code string) {
+	t.Helper()
+	var response MessageErrorResponse
+	c.expect(t, EventError, &response)
+	if response.Code != code {
+		t.Errorf("error code %s, want %s", response.Code, code)
+	}
+}
+
+// sendConnect asks to join the board as the user without waiting for the answer.
+func (c *testClient) sendConnect(t *testing.T, userID, boardID string) {
+	t.Helper()
+	c.send(t, MessageConnectRequest{Message: Message{Event: EventConnect}, BoardID: boardID, Jwt: userID})
+}
+
+func TestRoomFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		boardID string
+	}{
+		{name: "server limit", cfg: Config{MaxRoomUsers: 1}, boardID: testBoardID},
+		{name: "board limit", boardID: testLimitedBoard},
+		{name: "board limit over the server limit", cfg: Config{MaxRoomUsers: 5}, boardID: testLimitedBoard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), tt.cfg)
+			url := newTestServer(t, ws)
+			dialTest(t, url, nil).connect(t, testUserID, tt.boardID)
+
+			other := dialTest(t, url, nil)
+			other.sendConnect(t, testOtherUserID, tt.boardID)
+			other.expectError(t, ErrorCodeRoomFull)
+		})
+	}
+}
+
+func TestRoomLimitCachedWithAccess(t *testing.T) {
+	backend := newTestBackend(t, nil)
+	ws := newTestHandler(t, backend, Config{})
+	url := newTestServer(t, ws)
+
+	// The limit of the board comes with the access check, the first user doesn't ask for it again
+	dialTest(t, url, nil).connect(t, testUserID, testLimitedBoard)
+	if got := backend.boardRequests.Load(); got != 1 {
+		t.Errorf("board requests = %d, want 1", got)
+	}
+	maxUsers, err := ws.cacheOrValidateAccess(context.Background(), testUserID, testUserID, testLimitedBoard)
+	if err != nil {
+		t.Fatalf("cacheOrValidateAccess() unexpected error: %v", err)
+	}
+	if maxUsers != 1 || backend.boardRequests.Load() != 1 {
+		t.Errorf("cacheOrValidateAccess() = %d with %d requests, want 1 from the cache",
+			maxUsers, backend.boardRequests.Load())
+	}
+
+	other := dialTest(t, url, nil)
+	other.sendConnect(t, testOtherUserID, testLimitedBoard)
+	other.expectError(t, ErrorCodeRoomFull)
+}
+
+func TestRoomOverflowSpectate(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{MaxRoomUsers: 1, RoomOverflow: RoomOverflowSpectate})
+	url := newTestServer(t, ws)
+	participant := dialTest(t, url, nil)
+	participant.connect(t, testUserID, testBoardID)
+
+	// The users beyond the limit watch the room
+	spectator := dialTest(t, url, nil)
+	connected := spectator.connect(t, testOtherUserID, testBoardID)
+	if !slices.Equal(connected.SpectatorIDs, []string{testOtherUserID}) {
+		t.Fatalf("spectators %v, want [%s]", connected.SpectatorIDs, testOtherUserID)
+	}
+	spectator.setLeader(t, testOtherUserID, testBoardID)
+	spectator.expectError(t, ErrorCodeSpectator)
+	spectator.send(t, newUploadFileRequest(testOtherUserID, testBoardID, "f", []byte("png")))
+	spectator.expectError(t, ErrorCodeSpectator)
+
+	// The spectator takes the place of the leaving participant
+	_ = participant.conn.Close()
+	var disconnected MessageUserDisconnectedResponse
+	spectator.expect(t, EventUserDisconnected, &disconnected)
+	if len(disconnected.SpectatorIDs) != 0 || !slices.Equal(disconnected.UserIDs, []string{testOtherUserID}) {
+		t.Errorf("users %v and spectators %v, want [%s] and none",
+			disconnected.UserIDs, disconnected.SpectatorIDs, testOtherUserID)
+	}
+	spectator.setLeader(t, testOtherUserID, testBoardID)
+	spectator.expectNone(t, EventError, 100*time.Millisecond)
+}
+
+func TestLockRoom(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	client := dialTest(t, url, nil)
+	client.connect(t, testUserID, testBoardID)
+
+	// The users of the locked room stay, but no new users join it
+	if err := ws.LockRoom(testBoardID, testUserID, true); err != nil {
+		t.Fatalf("LockRoom() unexpected error: %v", err)
+	}
+	var locked MessageRoomLockedResponse
+	client.expect(t, EventRoomLocked, &locked)
+	if !locked.Locked {
+		t.Error("room locked event with locked false, want true")
+	}
+	other := dialTest(t, url, nil)
+	other.sendConnect(t, testOtherUserID, testBoardID)
+	other.expectError(t, ErrorCodeRoomLocked)
+
+	if err := ws.LockRoom(testBoardID, testUserID, false); err != nil {
+		t.Fatalf("LockRoom() unexpected error: %v", err)
+	}
+	client.expect(t, EventRoomLocked, &locked)
+	other.connect(t, testOtherUserID, testBoardID)
+}
+
+func TestLockRoomBeforeItIsCreated(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+
+	// The lock applies to the room created later
+	if err := ws.LockRoom(testBoardID, testUserID, true); err != nil {
+		t.Fatalf("LockRoom() unexpected error: %v", err)
+	}
+	client := dialTest(t, url, nil)
+	client.sendConnect(t, testUserID, testBoardID)
+	client.expectError(t, ErrorCodeRoomLocked)
+}
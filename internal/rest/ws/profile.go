@@ -0,0 +1,50 @@
+package ws
+
+import (
+	"net/url"
+	"unicode/utf8"
+)
+
+const (
+	// maxNameLength is the maximum length of the display name in characters, the longer names are truncated
+	maxNameLength = 64
+
+	// maxAvatarURLLength is the maximum length of the avatar URL, the longer URLs are dropped
+	maxAvatarURLLength = 2048
+)
+
+// profile returns the display name and the avatar URL of the user. The JWT validation response
+// takes precedence over the connect message, which the user controls.
+func profile(jwtResponse JWTValidationResponse, request MessageConnectRequest) (string, string) {
+	name, avatarURL := jwtResponse.Name, jwtResponse.AvatarURL
+	if name == "" {
+		name = request.Name
+	}
+	if avatarURL == "" {
+		avatarURL = request.AvatarURL
+	}
+	return sanitizeName(name), sanitizeAvatarURL(avatarURL)
+}
+
+// sanitizeName truncates the name to maxNameLength characters, the invalid UTF-8 names are dropped.
+func sanitizeName(name string) string {
+	if !utf8.ValidString(name) {
+		return ""
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+	return name
+}
+
+// sanitizeAvatarURL keeps only the absolute http and https URLs, so the clients can show them as images safely.
+func sanitizeAvatarURL(avatarURL string) string {
+	if avatarURL == "" || len(avatarURL) > maxAvatarURLLength {
+		return ""
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return avatarURL
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testName      = "Alice"
+	testAvatarURL = "https://example.com/alice.png"
+)
+
+func TestProfile(t *testing.T) {
+	request := MessageConnectRequest{Name: "Bob", AvatarURL: "https://example.com/bob.png"}
+	tests := []struct {
+		name          string
+		jwtResponse   JWTValidationResponse
+		wantName      string
+		wantAvatarURL string
+	}{
+		{
+			name:          "JWT profile",
+			jwtResponse:   JWTValidationResponse{Name: testName, AvatarURL: testAvatarURL},
+			wantName:      testName,
+			wantAvatarURL: testAvatarURL,
+		},
+		{
+			name:          "connect profile",
+			wantName:      request.Name,
+			wantAvatarURL: request.AvatarURL,
+		},
+		{
+			name:          "JWT name only",
+			jwtResponse:   JWTValidationResponse{Name: testName},
+			wantName:      testName,
+			wantAvatarURL: request.AvatarURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, avatarURL := profile(tt.jwtResponse, request)
+			if name != tt.wantName || avatarURL != tt.wantAvatarURL {
+				t.Errorf("profile() = %s, %s, want %s, %s", name, avatarURL, tt.wantName, tt.wantAvatarURL)
+			}
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: testName, want: testName},
+		{name: "too long", in: strings.Repeat("é", maxNameLength+1), want: strings.Repeat("é", maxNameLength)},
+		{name: "invalid UTF-8", in: "\xff", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeName(tt.in); got != tt.want {
+				t.Errorf("sanitizeName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeAvatarURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "https", in: testAvatarURL, want: testAvatarURL},
+		{name: "http", in: "http://example.com/a.png", want: "http://example.com/a.png"},
+		{name: "javascript", in: "javascript:alert(1)", want: ""},
+		{name: "data", in: "data:image/png;base64,iVBORw==", want: ""},
+		{name: "relative", in: "/a.png", want: ""},
+		{name: "too long", in: "https://example.com/" + strings.Repeat("a", maxAvatarURLLength), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeAvatarURL(tt.in); got != tt.want {
+				t.Errorf("sanitizeAvatarURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresenceProfiles(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	first := dialTest(t, url, nil)
+	first.send(t, MessageConnectRequest{
+		Message:   Message{Event: EventConnect},
+		BoardID:   testBoardID,
+		Jwt:       testUserID,
+		Name:      testName,
+		AvatarURL: testAvatarURL,
+	})
+	first.expect(t, EventUserConnected, nil)
+
+	// The users get the profiles and the distinct colors of the room members
+	second := dialTest(t, url, nil)
+	connected := second.connect(t, testOtherUserID, testBoardID)
+	if len(connected.Users) != 2 {
+		t.Fatalf("got %d users, want 2", len(connected.Users))
+	}
+	firstUser := connected.Users[0]
+	if firstUser.ID != testUserID || firstUser.Name != testName || firstUser.AvatarURL != testAvatarURL {
+		t.Errorf("first user %+v, want the profile of %s", firstUser, testUserID)
+	}
+	if connected.User.ID != testOtherUserID || connected.User.Color == "" || connected.User.Color == firstUser.Color {
+		t.Errorf("connected user %+v, want %s with a color other than %s",
+			connected.User, testOtherUserID, firstUser.Color)
+	}
+
+	// The leaving user is described by the disconnected message
+	_ = second.conn.Close()
+	var disconnected MessageUserDisconnectedResponse
+	first.expect(t, EventUserDisconnected, &disconnected)
+	if disconnected.User.ID != testOtherUserID || len(disconnected.Users) != 1 {
+		t.Errorf("disconnected %+v with %d users, want %s with 1 user",
+			disconnected.User, len(disconnected.Users), testOtherUserID)
+	}
+}
@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	// ProtocolVersionLegacy is the version of the clients that don't send the hello event
+	ProtocolVersionLegacy = 1
+
+	// ProtocolVersionResume is the first version with the session resume, the older clients leave the room at once
+	ProtocolVersionResume = 2
+
+	// ProtocolVersionCurrent is the latest protocol version supported by the server
+	ProtocolVersionCurrent = 2
+)
+
+const (
+	CapabilityCompression = "compression"
+	CapabilityBinary      = "binary"
+)
+
+// serverCapabilities returns the capabilities the server can agree to on the connection.
+func (ws *WebSocketHandler) serverCapabilities(conn *models.Connection) map[string]bool {
+	return map[string]bool{
+		// The compression is negotiated with the websocket extension when the connection is opened
+		CapabilityCompression: conn.IsCompressed(),
+		// The binary codec is selected with the websocket subprotocol when the connection is opened
+		CapabilityBinary: conn.Codec.MessageType() == websocket.BinaryMessage,
+	}
+}
+
+// hello negotiates the protocol version and the capabilities with the client.
+func (ws *WebSocketHandler) hello(conn *models.Connection, request MessageHelloRequest) {
+	if request.ProtocolVersion < ProtocolVersionLegacy {
+		ws.sendError(conn, ErrorCodeUnsupportedProtocol, "protocol version must be at least 1")
+		return
+	}
+
+	// The client gets the highest version both sides support
+	version := min(request.ProtocolVersion, ProtocolVersionCurrent)
+
+	supported := ws.serverCapabilities(conn)
+	capabilities := make([]string, 0, len(request.Capabilities))
+	for _, capability := range request.Capabilities {
+		if supported[capability] {
+			capabilities = append(capabilities, capability)
+			// Each capability is agreed once
+			delete(supported, capability)
+		}
+	}
+	conn.SetProtocol(version, capabilities)
+
+	// The browsers always offer the compression, the client declines it by leaving out the capability
+	if !conn.HasCapability(CapabilityCompression) {
+		conn.SetCompressed(false)
+	}
+
+	_ = conn.Send(MessageWelcomeResponse{
+		Message: Message{
+			Event: EventWelcome,
+		},
+		ProtocolVersion: version,
+		Capabilities:    capabilities,
+	})
+}
+
+// offersCompression reports whether the upgrade request offers the permessage-deflate extension.
+func offersCompression(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHelloNegotiation(t *testing.T) {
+	tests := []struct {
+		name             string
+		version          int
+		capabilities     []string
+		wantVersion      int
+		wantCapabilities []string
+	}{
+		{
+			name:             "legacy version",
+			version:          ProtocolVersionLegacy,
+			wantVersion:      ProtocolVersionLegacy,
+			wantCapabilities: []string{},
+		},
+		{
+			name:             "newer version",
+			version:          ProtocolVersionCurrent + 1,
+			wantVersion:      ProtocolVersionCurrent,
+			wantCapabilities: []string{},
+		},
+		{
+			name:             "unknown and unsupported capabilities",
+			version:          ProtocolVersionCurrent,
+			capabilities:     []string{"unknown", CapabilityCompression, CapabilityBinary},
+			wantVersion:      ProtocolVersionCurrent,
+			wantCapabilities: []string{},
+		},
+	}
+
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialTest(t, url, nil)
+			client.send(t, MessageHelloRequest{
+				Message:         Message{Event: EventHello},
+				ProtocolVersion: tt.version,
+				Capabilities:    tt.capabilities,
+			})
+			var response MessageWelcomeResponse
+			client.expect(t, EventWelcome, &response)
+			if response.ProtocolVersion != tt.wantVersion {
+				t.Errorf("protocol version %d, want %d", response.ProtocolVersion, tt.wantVersion)
+			}
+			if !reflect.DeepEqual(response.Capabilities, tt.wantCapabilities) {
+				t.Errorf("capabilities %v, want %v", response.Capabilities, tt.wantCapabilities)
+			}
+		})
+	}
+}
+
+func TestHelloUnsupportedVersion(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	client := dialTest(t, newTestServer(t, ws), nil)
+
+	client.send(t, MessageHelloRequest{Message: Message{Event: EventHello}, ProtocolVersion: 0})
+	var response MessageErrorResponse
+	client.expect(t, EventError, &response)
+	if response.Code != ErrorCodeUnsupportedProtocol {
+		t.Errorf("error code %s, want %s", response.Code, ErrorCodeUnsupportedProtocol)
+	}
+}
+
+func TestHelloCapabilityAgreedOnce(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{EnableCompression: true})
+	client := dialTest(t, newTestServer(t, ws), &websocket.Dialer{EnableCompression: true})
+
+	// The compression was negotiated, so the capability is agreed, but only once
+	agreed := client.hello(t, CapabilityCompression, CapabilityCompression)
+	if !reflect.DeepEqual(agreed, []string{CapabilityCompression}) {
+		t.Errorf("capabilities %v, want %v", agreed, []string{CapabilityCompression})
+	}
+}
+
+func TestLegacyClientWithoutHello(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	client := dialTest(t, newTestServer(t, ws), nil)
+
+	// The clients of the first version connect without the hello event
+	response := client.connect(t, testUserID, testBoardID)
+	if response.BoardID != testBoardID || !reflect.DeepEqual(response.UserIDs, []string{testUserID}) {
+		t.Errorf("connected to %s with users %v, want %s with %s",
+			response.BoardID, response.UserIDs, testBoardID, testUserID)
+	}
+}
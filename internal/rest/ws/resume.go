@@ -0,0 +1,269 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// resumeTokenSize is the number of the random bytes of a resume token.
+const resumeTokenSize = 32
+
+// session is the resumable state of a connected user.
+type session struct {
+	// boardID is the board the user is connected to
+	boardID string
+
+	// token is the secret the user presents to resume the session, it changes with every resume
+	token string
+
+	// ackedRevision is the last scene revision the user acknowledged
+	ackedRevision int64
+
+	// joinedSeq is the sequence number of the last room event sent before the user joined
+	joinedSeq int64
+
+	// timer ends the session when the grace period is over, nil while the user is connected
+	timer *time.Timer
+}
+
+// startSession issues the resume token to the user who connected to the room.
+// The clients of the older protocol versions can't resume, they leave the room as soon as they disconnect.
+func (ws *WebSocketHandler) startSession(conn *models.Connection, u *models.User, currentRoom *models.Room) {
+	if ws.resumeGracePeriod == 0 || conn.ProtocolVersion() < ProtocolVersionResume {
+		return
+	}
+
+	token := newResumeToken()
+	revision := currentRoom.GetRevision()
+	ws.sessionsMtx.Lock()
+	ws.sessions[u.ID] = &session{
+		boardID:       currentRoom.BoardID,
+		token:         token,
+		ackedRevision: revision,
+		joinedSeq:     currentRoom.GetHistorySeq(),
+	}
+	ws.sessionsMtx.Unlock()
+
+	_ = conn.Send(MessageSessionResponse{
+		Message: Message{
+			Event: EventSession,
+		},
+		BoardID:     currentRoom.BoardID,
+		ResumeToken: token,
+		Revision:    revision,
+	})
+}
+
+// detachUser returns the user of the closed connection and whether the user waits for the session resume.
+// The waiting user keeps the membership and the leadership, but has no connection.
+func (ws *WebSocketHandler) detachUser(conn *models.Connection) (*models.User, bool) {
+	ws.sessionsMtx.Lock()
+	defer ws.sessionsMtx.Unlock()
+
+	u, _ := ws.userStorage.GetWhere(func(u *models.User) bool {
+		return u.Conn == conn
+	})
+	if u == nil {
+		return nil, false
+	}
+
+	// The clients reconnect to another server after the shutdown, so there is nothing to wait for
+	s := ws.sessions[u.ID]
+	if s == nil || ws.draining.Load() {
+		return u, false
+	}
+
+	detached := *u
+	detached.Conn = nil
+	if err := ws.userStorage.Set(u.ID, &detached); err != nil {
+		return u, false
+	}
+	token := s.token
+	s.timer = time.AfterFunc(ws.resumeGracePeriod, func() {
+		ws.expireSession(u.ID, token)
+	})
+
+	ws.logger.Info("User detached", zap.String("userID", u.ID), zap.Duration("gracePeriod", ws.resumeGracePeriod))
+	return &detached, true
+}
+
+// expireSession removes the user waiting for the session resume from the room. An empty token matches
+// any session. It returns false if the session is not waiting, e.g. it was already resumed.
+func (ws *WebSocketHandler) expireSession(userID, token string) bool {
+	ws.sessionsMtx.Lock()
+	s := ws.sessions[userID]
+	u, _ := ws.userStorage.Get(userID)
+	if s == nil || (token != "" && s.token != token) || u == nil || u.Conn != nil {
+		ws.sessionsMtx.Unlock()
+		return false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	delete(ws.sessions, userID)
+	ws.sessionsMtx.Unlock()
+
+	ws.logger.Info("Session expired", zap.String("userID", userID))
+	ws.removeUser(u)
+	return true
+}
+
+// endSession forgets the session of the user.
+func (ws *WebSocketHandler) endSession(userID string) {
+	ws.sessionsMtx.Lock()
+	defer ws.sessionsMtx.Unlock()
+	if s := ws.sessions[userID]; s != nil {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		delete(ws.sessions, userID)
+	}
+}
+
+// resume attaches the connection to the session of the user and sends the events missed
+// since the last acknowledged scene revision.
+func (ws *WebSocketHandler) resume(ctx context.Context, conn *models.Connection, request MessageResumeRequest) {
+	if conn.ProtocolVersion() < ProtocolVersionResume {
+		ws.sendError(conn, ErrorCodeResumeFailed, "the session resume requires the protocol version 2")
+		return
+	}
+
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		ws.sendError(conn, ErrorCodeResumeFailed, "access to the board is denied")
+		return
+	}
+
+	// A connection holds a single user
+	if v, _ := ws.userStorage.GetWhere(func(u *models.User) bool { return u.Conn == conn }); v != nil {
+		ws.sendError(conn, ErrorCodeResumeFailed, "the connection already has a session")
+		return
+	}
+
+	currentRoom, _ := ws.roomStorage.Get(request.BoardID)
+	if currentRoom == nil {
+		ws.sendError(conn, ErrorCodeResumeFailed, "the session is expired or unknown")
+		return
+	}
+
+	// The missed events are collected under the room lock, the scene updates broadcast after it
+	// reach the resumed connection directly and carry a higher revision
+	currentRoom.RoomMutex.Lock()
+
+	ws.sessionsMtx.Lock()
+	s := ws.sessions[userID]
+	u, _ := ws.userStorage.Get(userID)
+	if s == nil || u == nil || s.boardID != request.BoardID ||
+		subtle.ConstantTimeCompare([]byte(s.token), []byte(request.ResumeToken)) != 1 {
+		ws.sessionsMtx.Unlock()
+		currentRoom.RoomMutex.Unlock()
+		ws.sendError(conn, ErrorCodeResumeFailed, "the session is expired or unknown")
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.token = newResumeToken()
+	resumed := *u
+	resumed.Conn = conn
+	if err := ws.userStorage.Set(userID, &resumed); err != nil {
+		ws.sessionsMtx.Unlock()
+		currentRoom.RoomMutex.Unlock()
+		ws.sendError(conn, ErrorCodeResumeFailed, "failed to resume the session")
+		return
+	}
+	token, acked, joinedSeq := s.token, s.ackedRevision, s.joinedSeq
+	ws.sessionsMtx.Unlock()
+
+	revision := currentRoom.GetRevision()
+	userIDs, spectatorIDs := currentRoom.GetMembers()
+	messages := []interface{}{
+		MessageResumedResponse{
+			Message: Message{
+				Event: EventResumed,
+			},
+			BoardID:      currentRoom.BoardID,
+			ResumeToken:  token,
+			Revision:     revision,
+			UserIDs:      userIDs,
+			SpectatorIDs: spectatorIDs,
+			LeaderID:     currentRoom.GetLeader(),
+			Users:        currentRoom.GetParticipants(),
+		},
+	}
+
+	// The events dropped from the history are lost, the resumed message carries the current members anyway
+	if history, complete := currentRoom.GetHistory(acked, joinedSeq); complete {
+		messages = append(messages, history...)
+	}
+
+	// The history has no scenes, the current scene replaces the missed ones
+	var scene interface{}
+	if revision > acked {
+		scene = sceneMessage(currentRoom, revision)
+	}
+	currentRoom.RoomMutex.Unlock()
+
+	// The previous connection is still open if the server didn't notice it broke,
+	// its read loop finds no user and leaves the session alone
+	if u.Conn != nil {
+		_ = u.Conn.Close()
+	}
+
+	for _, message := range messages {
+		_ = conn.Send(message)
+	}
+
+	// A newer scene was already broadcast to the connection, the collected one is stale
+	if scene != nil && currentRoom.GetRevision() == revision {
+		_ = conn.Send(scene)
+	}
+
+	ws.logger.Info("Session resumed", zap.String("userID", userID), zap.Int64("ackedRevision", acked))
+}
+
+// ack remembers the last scene revision received by the user.
+func (ws *WebSocketHandler) ack(ctx context.Context, request MessageAckRequest) {
+	userID, err := ws.cacheOrValidate(ctx, request.Jwt, request.BoardID)
+	if err != nil {
+		ws.logger.Error("Failed to validate", zap.Error(err))
+		return
+	}
+
+	ws.sessionsMtx.Lock()
+	defer ws.sessionsMtx.Unlock()
+	if s := ws.sessions[userID]; s != nil && s.boardID == request.BoardID && request.Revision > s.ackedRevision {
+		s.ackedRevision = request.Revision
+	}
+}
+
+// recordHistory keeps the event for the resumed sessions. The scenes are not kept,
+// a resumed session receives the current scene instead.
+func (ws *WebSocketHandler) recordHistory(currentRoom *models.Room, message interface{}) {
+	if ws.resumeGracePeriod == 0 {
+		return
+	}
+	switch message.(type) {
+	case MessageNewDataResponse, MessageNewEncryptedDataResponse:
+		return
+	}
+	currentRoom.AddHistory(message, ws.resumeHistorySize)
+}
+
+// newResumeToken generates a random resume token.
+func newResumeToken() string {
+	b := make([]byte, resumeTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package ws
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// connectSession says hello with the current protocol, joins the board and returns the resume token.
+// The token comes before the user connected message.
+func (c *testClient) connectSession(t *testing.T, userID, boardID string) string {
+	t.Helper()
+	c.hello(t)
+	c.send(t, MessageConnectRequest{Message: Message{Event: EventConnect}, BoardID: boardID, Jwt: userID})
+	var response MessageSessionResponse
+	c.expect(t, EventSession, &response)
+	c.expect(t, EventUserConnected, nil)
+	return response.ResumeToken
+}
+
+func newResumeRequest(userID, boardID, token string) MessageResumeRequest {
+	return MessageResumeRequest{
+		Message:     Message{Event: EventResume},
+		BoardID:     boardID,
+		Jwt:         userID,
+		ResumeToken: token,
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	resumed := dialTest(t, url, nil)
+	token := resumed.connectSession(t, testUserID, testBoardID)
+	other := dialTest(t, url, nil)
+	other.connect(t, testOtherUserID, testBoardID)
+
+	// The user keeps the membership while the session waits for the resume
+	_ = resumed.conn.Close()
+	other.expectNone(t, EventUserDisconnected, 100*time.Millisecond)
+
+	// The events and the scene missed by the user are sent on resume
+	late := dialTest(t, url, nil)
+	late.connect(t, testThirdUserID, testBoardID)
+	other.setLeader(t, testOtherUserID, testBoardID)
+	elements := `[{"id":"a","type":"rectangle"}]`
+	other.send(t, newDataRequest(testOtherUserID, testBoardID, elements))
+	other.expect(t, EventNewData, nil)
+
+	reconnected := dialTest(t, url, nil)
+	reconnected.hello(t)
+	reconnected.send(t, newResumeRequest(testUserID, testBoardID, token))
+	var response MessageResumedResponse
+	reconnected.expect(t, EventResumed, &response)
+	if response.ResumeToken == "" || response.ResumeToken == token || response.Revision != 1 {
+		t.Errorf("resumed with the token %q and the revision %d, want a new token and the revision 1",
+			response.ResumeToken, response.Revision)
+	}
+	if !slices.Contains(response.UserIDs, testUserID) || response.LeaderID != testOtherUserID {
+		t.Errorf("resumed with the users %v and the leader %s, want %s among the users and %s as the leader",
+			response.UserIDs, response.LeaderID, testUserID, testOtherUserID)
+	}
+	// The history is replayed from the join of the user, the last event has all the users
+	var connected MessageUserConnectedResponse
+	for !slices.Contains(connected.UserIDs, testThirdUserID) {
+		reconnected.expect(t, EventUserConnected, &connected)
+	}
+	var scene MessageNewDataResponse
+	reconnected.expect(t, EventNewData, &scene)
+	if scene.Data.Elements != elements {
+		t.Errorf("missed scene %s, want %s", scene.Data.Elements, elements)
+	}
+}
+
+func TestResumeAfterAck(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	url := newTestServer(t, ws)
+	client := dialTest(t, url, nil)
+	token := client.connectSession(t, testUserID, testBoardID)
+	client.setLeader(t, testUserID, testBoardID)
+	client.send(t, newDataRequest(testUserID, testBoardID, `[]`))
+	client.expect(t, EventNewData, nil)
+	client.send(t, MessageAckRequest{
+		Message:  Message{Event: EventAck},
+		BoardID:  testBoardID,
+		Jwt:      testUserID,
+		Revision: 1,
+	})
+
+	// The acknowledged scene is not sent again
+	waitFor(t, func() bool {
+		ws.sessionsMtx.Lock()
+		defer ws.sessionsMtx.Unlock()
+		return ws.sessions[testUserID].ackedRevision == 1
+	})
+	_ = client.conn.Close()
+	reconnected := dialTest(t, url, nil)
+	reconnected.hello(t)
+	reconnected.send(t, newResumeRequest(testUserID, testBoardID, token))
+	reconnected.expect(t, EventResumed, nil)
+	reconnected.expectNone(t, EventNewData, 100*time.Millisecond)
+}
+
+func TestResumeExpires(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{ResumeGracePeriod: 1})
+	url := newTestServer(t, ws)
+	resumed := dialTest(t, url, nil)
+	token := resumed.connectSession(t, testUserID, testBoardID)
+	other := dialTest(t, url, nil)
+	other.connect(t, testOtherUserID, testBoardID)
+
+	// The user leaves the room once the grace period is over
+	_ = resumed.conn.Close()
+	var disconnected MessageUserDisconnectedResponse
+	other.expect(t, EventUserDisconnected, &disconnected)
+	if slices.Contains(disconnected.UserIDs, testUserID) {
+		t.Errorf("users %v, want %s removed", disconnected.UserIDs, testUserID)
+	}
+
+	reconnected := dialTest(t, url, nil)
+	reconnected.hello(t)
+	reconnected.send(t, newResumeRequest(testUserID, testBoardID, token))
+	var response MessageErrorResponse
+	reconnected.expect(t, EventError, &response)
+	if response.Code != ErrorCodeResumeFailed {
+		t.Errorf("error code %s, want %s", response.Code, ErrorCodeResumeFailed)
+	}
+}
+
+func TestResumeRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		legacy  bool
+		boardID string
+		token   func(token string) string
+	}{
+		{name: "legacy client", legacy: true, boardID: testBoardID},
+		{name: "other board", boardID: "board-2"},
+		{name: "invalid token", boardID: testBoardID, token: func(token string) string { return token + "x" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+			url := newTestServer(t, ws)
+			client := dialTest(t, url, nil)
+			token := client.connectSession(t, testUserID, testBoardID)
+			_ = client.conn.Close()
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			reconnected := dialTest(t, url, nil)
+			if !tt.legacy {
+				reconnected.hello(t)
+			}
+			reconnected.send(t, newResumeRequest(testUserID, tt.boardID, token))
+			var response MessageErrorResponse
+			reconnected.expect(t, EventError, &response)
+			if response.Code != ErrorCodeResumeFailed {
+				t.Errorf("error code %s, want %s", response.Code, ErrorCodeResumeFailed)
+			}
+		})
+	}
+}
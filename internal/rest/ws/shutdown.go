@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// shutdownCloseReason is the reason of the close frame sent on shutdown.
+const shutdownCloseReason = "server shutdown"
+
+// Shutdown stops accepting the connections, tells the clients when to reconnect, saves the room
+// snapshots and closes the connections with the 1012 Service Restart code. It returns when all the
+// users are unregistered; the connections still open when the context is done are closed abruptly.
+func (ws *WebSocketHandler) Shutdown(ctx context.Context) error {
+	if !ws.draining.Swap(true) {
+		close(ws.loopsDone)
+	}
+	conns := ws.connections.GetAll()
+	ws.logger.Info("Draining connections", zap.Int("connections", len(conns)))
+
+	// The reconnect delay is spread, so the clients don't come back all at once
+	forEachConnection(conns, func(conn *models.Connection) {
+		delay := ws.reconnectAfter + time.Duration(rand.Int63n(int64(ws.reconnectAfter)+1))
+		_ = conn.Send(MessageServerShutdownResponse{
+			Message: Message{
+				Event: EventServerShutdown,
+			},
+			Reason:         "the server is restarting",
+			ReconnectAfter: delay.Milliseconds(),
+		})
+	})
+
+	// The rooms are deleted when their users leave, so the scenes are saved before the connections are closed
+	ws.saveSnapshots()
+
+	forEachConnection(conns, func(conn *models.Connection) {
+		_ = conn.CloseWithCode(websocket.CloseServiceRestart, shutdownCloseReason)
+	})
+	if err := ws.connections.Wait(ctx); err != nil {
+		for _, conn := range ws.connections.GetAll() {
+			_ = conn.Close()
+		}
+		return fmt.Errorf("failed to drain connections: %w", err)
+	}
+	return nil
+}
+
+// forEachConnection calls the function for the connections concurrently, so a slow peer
+// doesn't hold the others for the write timeout.
+func forEachConnection(conns []*models.Connection, f func(conn *models.Connection)) {
+	wg := &sync.WaitGroup{}
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *models.Connection) {
+			defer wg.Done()
+			f(conn)
+		}(conn)
+	}
+	wg.Wait()
+}
+
+// saveSnapshots stores the scenes of the rooms if the snapshots are enabled.
+func (ws *WebSocketHandler) saveSnapshots() {
+	if ws.snapshotStorage == nil {
+		return
+	}
+	rooms, err := ws.roomStorage.GetAll()
+	if err != nil {
+		ws.logger.Error("Failed to get rooms", zap.Error(err))
+		return
+	}
+
+	saved := 0
+	for _, currentRoom := range rooms {
+		// The rooms without a scene have nothing to restore
+		value := snapshotOf(currentRoom)
+		if value.Elements == "" && value.EncryptedScene == nil && !value.Locked {
+			continue
+		}
+		if err := ws.snapshotStorage.Save(value); err != nil {
+			ws.logger.Error("Failed to save snapshot", zap.String("boardID", value.BoardID), zap.Error(err))
+			continue
+		}
+		saved++
+	}
+	ws.logger.Info("Snapshots saved", zap.Int("rooms", saved))
+}
+
+// RestoreSnapshots creates the rooms from the snapshots saved on the last shutdown while they fit
+// into the memory budget. The other snapshots, including the ones of the evicted rooms, are restored
+// when a user joins the board.
+func (ws *WebSocketHandler) RestoreSnapshots() error {
+	if ws.snapshotStorage == nil {
+		return nil
+	}
+
+	ws.roomsMtx.Lock()
+	defer ws.roomsMtx.Unlock()
+	var total int64
+	restored := 0
+	err := ws.snapshotStorage.Load(func(value *models.Snapshot) bool {
+		currentRoom := roomFromSnapshot(value)
+		size := int64(currentRoom.SceneSize())
+		if ws.maxScenesSize > 0 && total+size > ws.maxScenesSize {
+			return false
+		}
+		if err := ws.roomStorage.Set(value.BoardID, currentRoom); err != nil {
+			ws.logger.Error("Failed to restore room", zap.String("boardID", value.BoardID), zap.Error(err))
+			return false
+		}
+		if value.Locked {
+			ws.locks[value.BoardID] = true
+		}
+		ws.notify(models.RoomEventRoomCreated, currentRoom, "")
+		total += size
+		restored++
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load snapshots: %w", err)
+	}
+	ws.logger.Info("Snapshots restored", zap.Int("rooms", restored), zap.Int64("scenesSize", total))
+	return nil
+}
+
+// snapshotOf returns the snapshot of the room scene.
+func snapshotOf(currentRoom *models.Room) *models.Snapshot {
+	currentRoom.RoomMutex.Lock()
+	defer currentRoom.RoomMutex.Unlock()
+	return &models.Snapshot{
+		BoardID:        currentRoom.BoardID,
+		Elements:       currentRoom.GetElements(),
+		AppState:       currentRoom.GetAppState(),
+		Encrypted:      currentRoom.Encrypted,
+		EncryptedScene: currentRoom.GetEncryptedScene(),
+		Locked:         currentRoom.IsLocked(),
+		MaxUsers:       currentRoom.GetMaxUsers(),
+		Created:        time.Now().UnixMilli(),
+	}
+}
+
+// roomFromSnapshot creates the room with the scene of the snapshot.
+func roomFromSnapshot(value *models.Snapshot) *models.Room {
+	currentRoom := models.NewRoom(value.BoardID)
+	currentRoom.Encrypted = value.Encrypted
+	currentRoom.SetElements(value.Elements)
+	currentRoom.SetAppState(value.AppState)
+	currentRoom.SetEncryptedScene(value.EncryptedScene)
+	currentRoom.SetLocked(value.Locked)
+	currentRoom.SetMaxUsers(value.MaxUsers)
+	return currentRoom
+}
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/storage/snapshot/disk"
+)
+
+// shutdownTest shuts the handler down and fails the test on error.
+func shutdownTest(t *testing.T, ws *WebSocketHandler) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() unexpected error: %v", err)
+	}
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{ReconnectAfter: 2})
+	url := newTestServer(t, ws)
+	client := dialTest(t, url, nil)
+	client.connect(t, testUserID, testBoardID)
+
+	shutdownTest(t, ws)
+
+	// The clients are told when to reconnect, the delay is spread between one and two reconnect intervals
+	var response MessageServerShutdownResponse
+	client.expect(t, EventServerShutdown, &response)
+	if response.ReconnectAfter < 2000 || response.ReconnectAfter > 4000 {
+		t.Errorf("reconnect after %d ms, want 2000 to 4000 ms", response.ReconnectAfter)
+	}
+	if err := client.closed(t); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("connection closed with %v, want %d", err, websocket.CloseServiceRestart)
+	}
+	if stats := ws.Stats(); stats.Connections != 0 {
+		t.Errorf("%d connections left, want 0", stats.Connections)
+	}
+
+	// The new connections are rejected
+	w := httptest.NewRecorder()
+	ws.Handle(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("status = %d with Retry-After %s, want %d with 2",
+			w.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+}
+
+func TestShutdownSavesSnapshots(t *testing.T) {
+	snapshots, err := disk.NewStorage(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStorage() unexpected error: %v", err)
+	}
+	backend := newTestBackend(t, nil)
+	ws := newTestHandler(t, backend, Config{SnapshotStorage: snapshots})
+	client := dialTest(t, newTestServer(t, ws), nil)
+	client.connect(t, testUserID, testBoardID)
+	client.setLeader(t, testUserID, testBoardID)
+	elements := `[{"id":"a","type":"rectangle"}]`
+	client.send(t, newDataRequest(testUserID, testBoardID, elements))
+	client.expect(t, EventNewData, nil)
+
+	shutdownTest(t, ws)
+
+	// The next server restores the scene of the room
+	restarted := newTestHandler(t, backend, Config{SnapshotStorage: snapshots})
+	if err = restarted.RestoreSnapshots(); err != nil {
+		t.Fatalf("RestoreSnapshots() unexpected error: %v", err)
+	}
+	late := dialTest(t, newTestServer(t, restarted), nil)
+	late.connect(t, testOtherUserID, testBoardID)
+	var response MessageNewDataResponse
+	late.expect(t, EventNewData, &response)
+	if response.Data.Elements != elements {
+		t.Errorf("restored %s, want %s", response.Data.Elements, elements)
+	}
+}
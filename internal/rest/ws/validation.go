@@ -0,0 +1,286 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/upstream"
+)
+
+// accessRevokedReason is the reason of the close frame sent to the users whose access was invalidated.
+const accessRevokedReason = "access revoked"
+
+// validationFailure is a cached rejection, so the invalid tokens don't reach the validation URLs on every message.
+type validationFailure struct {
+	err error
+}
+
+// accessGranted is the cached access of a user to a board.
+type accessGranted struct {
+	// maxUsers is the limit of the participants of the board room, zero if the board has none
+	maxUsers int
+}
+
+// accessGeneration is the generation of the cached access set by the invalidation.
+type accessGeneration struct {
+	generation  uint64
+	invalidated time.Time
+}
+
+// cacheOrValidateIdentity returns the user of the JWT token. The concurrent validations of the same token
+// share a single request.
+func (ws *WebSocketHandler) cacheOrValidateIdentity(ctx context.Context, jwt string) (JWTValidationResponse, error) {
+	v, err := ws.cache.Get(jwt)
+	if err != nil {
+		return JWTValidationResponse{}, fmt.Errorf("failed to get from cache: %w", err)
+	}
+	switch value := v.(type) {
+	case nil:
+	case JWTValidationResponse:
+		return value, nil
+	case validationFailure:
+		return JWTValidationResponse{}, fmt.Errorf("cached rejection: %w", value.err)
+	default:
+		return JWTValidationResponse{}, fmt.Errorf("failed to parse cached user: %w", ErrInvalidJWT)
+	}
+	if err := ws.cachedFailure(jwt); err != nil {
+		return JWTValidationResponse{}, fmt.Errorf("cached rejection: %w", err)
+	}
+
+	result, err := ws.validateOnce(ctx, "jwt\x00"+jwt, func(ctx context.Context) (interface{}, error) {
+		jwtResponse, err := ws.validateJWT(ctx, jwt)
+		if err != nil {
+			if cached, ok := ws.fallback(jwt, err); ok {
+				if cachedResponse, ok := cached.(JWTValidationResponse); ok {
+					return cachedResponse, nil
+				}
+			}
+			err = fmt.Errorf("failed to validate JWT: %w", err)
+			ws.cacheFailure(jwt, err)
+			return jwtResponse, err
+		}
+		_ = ws.cache.SetWithTTL(jwt, jwtResponse, ws.cacheTTLInSeconds)
+		ws.keepForFallback(jwt, jwtResponse)
+		return jwtResponse, nil
+	})
+	if err != nil {
+		return JWTValidationResponse{}, err
+	}
+	jwtResponse, ok := result.(JWTValidationResponse)
+	if !ok {
+		return JWTValidationResponse{}, fmt.Errorf("failed to parse shared validation: %w", ErrInvalidJWT)
+	}
+	return jwtResponse, nil
+}
+
+// cacheOrValidateAccess checks the access of the user to the board and returns the limit of the participants
+// of the board room. The concurrent checks of the same user and board share a single request.
+func (ws *WebSocketHandler) cacheOrValidateAccess(ctx context.Context, jwt, userID, boardID string) (int, error) {
+	key := ws.accessKey(userID, boardID)
+	v, err := ws.cache.Get(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get from cache: %w", err)
+	}
+	switch value := v.(type) {
+	case nil:
+	case accessGranted:
+		return value.maxUsers, nil
+	case validationFailure:
+		return 0, fmt.Errorf("cached rejection: %w", value.err)
+	default:
+		return 0, fmt.Errorf("failed to parse cached access: %w", ErrNoBoardAccess)
+	}
+	if err := ws.cachedFailure(key); err != nil {
+		return 0, fmt.Errorf("cached rejection: %w", err)
+	}
+
+	result, err := ws.validateOnce(ctx, key, func(ctx context.Context) (interface{}, error) {
+		boardResponse, err := ws.validateBoardAccess(ctx, boardID, jwt)
+		if err != nil {
+			if cached, ok := ws.fallback(key, err); ok {
+				if cachedAccess, ok := cached.(accessGranted); ok {
+					return cachedAccess, nil
+				}
+			}
+			err = fmt.Errorf("failed to validate access of user '%s' to the board '%s': %w", userID, boardID, err)
+			ws.cacheFailure(key, err)
+			return nil, err
+		}
+		access := accessGranted{maxUsers: boardResponse.MaxUsers}
+		_ = ws.cache.SetWithTTL(key, access, ws.accessCacheTTL)
+		ws.keepForFallback(key, access)
+		return access, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	access, ok := result.(accessGranted)
+	if !ok {
+		return 0, fmt.Errorf("failed to parse shared access: %w", ErrNoBoardAccess)
+	}
+	return access.maxUsers, nil
+}
+
+// validateOnce runs the validation shared by the concurrent callers with the same key. The shared validation
+// is not canceled with the context of the caller who started it, it is limited by the deadline of the client;
+// every caller stops waiting for it when its own context is done.
+func (ws *WebSocketHandler) validateOnce(
+	ctx context.Context,
+	key string,
+	validate func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	results := ws.validations.DoChan(key, func() (interface{}, error) {
+		return validate(context.WithoutCancel(ctx))
+	})
+	select {
+	case result := <-results:
+		return result.Val, result.Err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for validation: %w", ctx.Err())
+	}
+}
+
+// accessKey returns the cache key of the access of the user to the board. The key includes the generations
+// of the board and of the user on the board, so the invalidation makes the entries cached before it unreachable,
+// including the ones stored by the validations still in flight.
+func (ws *WebSocketHandler) accessKey(userID, boardID string) string {
+	ws.accessMtx.Lock()
+	boardGeneration := ws.accessGenerations[boardID].generation
+	userGeneration := ws.accessGenerations[userGenerationKey(userID, boardID)].generation
+	ws.accessMtx.Unlock()
+	return "access\x00" + userID + "\x00" + boardID + "\x00" +
+		strconv.FormatUint(boardGeneration, 10) + "\x00" + strconv.FormatUint(userGeneration, 10)
+}
+
+// accessGenerationTTL returns the time the generations are kept after the invalidation: the longest TTL
+// of the entries keyed by them and the deadline of the validations still in flight, which store the entries
+// with the generations read before the invalidation.
+func accessGenerationTTL(cfg *Config) time.Duration {
+	ttl := max(cfg.AccessCacheTTL, cfg.NegativeCacheTTL)
+	if cfg.ValidationFallback == ValidationFallbackCache {
+		ttl = max(ttl, cfg.FallbackCacheTTL)
+	}
+	return time.Duration(ttl)*time.Second + cfg.Upstream.Deadline()
+}
+
+// pruneAccessGenerations drops the generations invalidated more than the generation TTL ago, every entry cached
+// before their invalidation has expired by then. The dropped generations are never given out again, so the entries
+// cached with them can't become reachable. It is called with accessMtx held.
+func (ws *WebSocketHandler) pruneAccessGenerations(now time.Time) {
+	if now.Sub(ws.accessPruned) < ws.accessGenerationTTL {
+		return
+	}
+	ws.accessPruned = now
+	for key, generation := range ws.accessGenerations {
+		if now.Sub(generation.invalidated) >= ws.accessGenerationTTL {
+			delete(ws.accessGenerations, key)
+		}
+	}
+}
+
+// userGenerationKey returns the key of the access generation of the user on the board.
+func userGenerationKey(userID, boardID string) string {
+	return boardID + "\x00" + userID
+}
+
+// cacheFailure keeps the rejection for the negative cache TTL. The errors of the requests themselves,
+// e.g. the network errors, are not cached.
+func (ws *WebSocketHandler) cacheFailure(key string, err error) {
+	if ws.negativeCacheTTL == 0 {
+		return
+	}
+	if !errors.Is(err, ErrValidatingJWT) && !errors.Is(err, ErrInvalidJWT) && !errors.Is(err, ErrNoBoardAccess) {
+		return
+	}
+	_ = ws.negativeCache.SetWithTTL(key, validationFailure{err: err}, ws.negativeCacheTTL)
+}
+
+// cachedFailure returns the cached rejection of the key, nil if there is none.
+func (ws *WebSocketHandler) cachedFailure(key string) error {
+	v, _ := ws.negativeCache.Get(key)
+	if failure, ok := v.(validationFailure); ok {
+		return failure.err
+	}
+	return nil
+}
+
+// keepForFallback keeps the decision for the fallback TTL, so it can be served while the validation URLs
+// are unavailable.
+func (ws *WebSocketHandler) keepForFallback(key string, value interface{}) {
+	if !ws.fallbackToCache {
+		return
+	}
+	_ = ws.fallbackCache.SetWithTTL(fallbackKey(key), value, ws.fallbackCacheTTL)
+}
+
+// fallback returns the last decision kept for the key if the validation failed because the validation
+// URLs are unavailable and the fallback to the cache is enabled.
+func (ws *WebSocketHandler) fallback(key string, err error) (interface{}, bool) {
+	if !ws.fallbackToCache || !errors.Is(err, upstream.ErrUnavailable) {
+		return nil, false
+	}
+	value, _ := ws.fallbackCache.Get(fallbackKey(key))
+	if value == nil {
+		return nil, false
+	}
+	ws.logger.Debug("Validation URL is unavailable, serving the cached decision", zap.Error(err))
+	return value, true
+}
+
+// fallbackKey returns the cache key of the decision kept for the fallback.
+func fallbackKey(key string) string {
+	return "fallback\x00" + key
+}
+
+// InvalidateAccess drops the cached access of the user to the board, of all the users if userID is empty,
+// so the next message is checked with the board validation URL. If disconnect is true, the affected users
+// are disconnected from the room and have to connect again. It returns the number of the disconnected users.
+func (ws *WebSocketHandler) InvalidateAccess(boardID, userID string, disconnect bool) int {
+	now := time.Now()
+	ws.accessMtx.Lock()
+	ws.pruneAccessGenerations(now)
+	ws.lastAccessGeneration++
+	key := boardID
+	if userID != "" {
+		key = userGenerationKey(userID, boardID)
+	}
+	ws.accessGenerations[key] = accessGeneration{generation: ws.lastAccessGeneration, invalidated: now}
+	ws.accessMtx.Unlock()
+	ws.logger.Info("Access invalidated", zap.String("boardID", boardID), zap.String("userID", userID))
+	if !disconnect {
+		return 0
+	}
+
+	currentRoom, _ := ws.roomStorage.Get(boardID)
+	if currentRoom == nil {
+		return 0
+	}
+	disconnected := 0
+	for _, roomUser := range currentRoom.GetUsers() {
+		if userID != "" && roomUser.ID != userID {
+			continue
+		}
+
+		// The session is ended, so the user leaves the room as soon as the connection is closed
+		u, _ := ws.userStorage.Get(roomUser.ID)
+		if u == nil {
+			continue
+		}
+		if u.Conn == nil {
+			ws.expireSession(u.ID, "")
+			disconnected++
+			continue
+		}
+		ws.endSession(u.ID)
+		ws.sendError(u.Conn, ErrorCodeAccessRevoked, "the access to the board is revoked")
+		_ = u.Conn.CloseWithCode(websocket.ClosePolicyViolation, accessRevokedReason)
+		disconnected++
+	}
+	return disconnected
+}
@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegativeCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttl          int64
+		wantRequests int64
+	}{
+		{name: "enabled", wantRequests: 1},
+		{name: "disabled", ttl: -1, wantRequests: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestBackend(t, nil)
+			ws := newTestHandler(t, backend, Config{NegativeCacheTTL: tt.ttl})
+
+			for i := 0; i < 2; i++ {
+				_, err := ws.cacheOrValidateIdentity(context.Background(), testInvalidJwt)
+				if !errors.Is(err, ErrValidatingJWT) {
+					t.Fatalf("cacheOrValidateIdentity() error = %v, want %v", err, ErrValidatingJWT)
+				}
+				_, err = ws.cacheOrValidateAccess(context.Background(), testUserID, testUserID, testForbiddenBoard)
+				if !errors.Is(err, ErrNoBoardAccess) {
+					t.Fatalf("cacheOrValidateAccess() error = %v, want %v", err, ErrNoBoardAccess)
+				}
+			}
+			if got := backend.jwtRequests.Load(); got != tt.wantRequests {
+				t.Errorf("JWT requests = %d, want %d", got, tt.wantRequests)
+			}
+			if got := backend.boardRequests.Load(); got != tt.wantRequests {
+				t.Errorf("board requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestNegativeCacheSkipsRequestErrors(t *testing.T) {
+	backend := newTestBackend(t, nil)
+	ws := newTestHandler(t, backend, Config{})
+
+	// The unreachable validation URL is not a rejection, so it is asked again
+	backend.server.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := ws.cacheOrValidateIdentity(context.Background(), testUserID); err == nil {
+			t.Fatal("cacheOrValidateIdentity() expected error")
+		}
+		if err := ws.cachedFailure(testUserID); err != nil {
+			t.Fatalf("cachedFailure() = %v, want nil", err)
+		}
+	}
+}
+
+func TestValidationsCoalesced(t *testing.T) {
+	hold := make(chan struct{})
+	release := sync.OnceFunc(func() { close(hold) })
+	t.Cleanup(release)
+
+	backend := newTestBackend(t, hold)
+	ws := newTestHandler(t, backend, Config{})
+
+	const callers = 5
+	results := make(chan JWTValidationResponse, callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			response, err := ws.cacheOrValidateIdentity(context.Background(), testUserID)
+			results <- response
+			errs <- err
+		}()
+	}
+
+	// The callers join the validation in flight while the backend holds it
+	waitFor(t, func() bool { return backend.jwtRequests.Load() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	release()
+
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("cacheOrValidateIdentity() unexpected error: %v", err)
+		}
+		if response := <-results; response.ID != testUserID {
+			t.Errorf("cacheOrValidateIdentity() = %v, want the user %s", response.ID, testUserID)
+		}
+	}
+	if got := backend.jwtRequests.Load(); got != 1 {
+		t.Errorf("JWT requests = %d, want 1", got)
+	}
+}
+
+func TestValidationCallerCanceled(t *testing.T) {
+	hold := make(chan struct{})
+	release := sync.OnceFunc(func() { close(hold) })
+	t.Cleanup(release)
+
+	backend := newTestBackend(t, hold)
+	ws := newTestHandler(t, backend, Config{})
+
+	// The caller who started the validation stops waiting, the validation goes on for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := ws.cacheOrValidateIdentity(ctx, testUserID)
+		canceled <- err
+	}()
+	waitFor(t, func() bool { return backend.jwtRequests.Load() == 1 })
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cacheOrValidateIdentity() error = %v, want %v", err, context.Canceled)
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := ws.cacheOrValidateIdentity(context.Background(), testUserID)
+		waiting <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	release()
+	if err := <-waiting; err != nil {
+		t.Fatalf("cacheOrValidateIdentity() unexpected error: %v", err)
+	}
+	if got := backend.jwtRequests.Load(); got != 1 {
+		t.Errorf("JWT requests = %d, want 1", got)
+	}
+}
+
+func TestInvalidateAccess(t *testing.T) {
+	backend := newTestBackend(t, nil)
+	ws := newTestHandler(t, backend, Config{})
+	checkAccess := func(wantRequests int64) {
+		t.Helper()
+		if _, err := ws.cacheOrValidateAccess(context.Background(), testUserID, testUserID, testBoardID); err != nil {
+			t.Fatalf("cacheOrValidateAccess() unexpected error: %v", err)
+		}
+		if got := backend.boardRequests.Load(); got != wantRequests {
+			t.Errorf("board requests = %d, want %d", got, wantRequests)
+		}
+	}
+
+	checkAccess(1)
+	checkAccess(1)
+
+	// The access of the other users stays cached
+	if got := ws.InvalidateAccess(testBoardID, testOtherUserID, false); got != 0 {
+		t.Errorf("InvalidateAccess() = %d, want 0", got)
+	}
+	checkAccess(1)
+
+	// The access of the user and the access of all the users of the board are checked again
+	ws.InvalidateAccess(testBoardID, testUserID, false)
+	checkAccess(2)
+	ws.InvalidateAccess(testBoardID, "", false)
+	checkAccess(3)
+	checkAccess(3)
+}
+
+func TestInvalidateAccessDisconnects(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           string
+		wantDisconnected []string
+	}{
+		{name: "user", userID: testUserID, wantDisconnected: []string{testUserID}},
+		{name: "board", wantDisconnected: []string{testUserID, testOtherUserID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+			url := newTestServer(t, ws)
+			clients := map[string]*testClient{
+				testUserID:      dialTest(t, url, nil),
+				testOtherUserID: dialTest(t, url, nil),
+			}
+			clients[testUserID].connect(t, testUserID, testBoardID)
+			clients[testOtherUserID].connect(t, testOtherUserID, testBoardID)
+
+			if got := ws.InvalidateAccess(testBoardID, tt.userID, true); got != len(tt.wantDisconnected) {
+				t.Errorf("InvalidateAccess() = %d, want %d", got, len(tt.wantDisconnected))
+			}
+			for _, userID := range tt.wantDisconnected {
+				clients[userID].expectError(t, ErrorCodeAccessRevoked)
+				if err := clients[userID].closed(t); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+					t.Errorf("connection of %s closed with %v, want %d", userID, err, websocket.ClosePolicyViolation)
+				}
+			}
+
+			// The disconnected users can't resume their sessions
+			ws.sessionsMtx.Lock()
+			_, resumable := ws.sessions[testUserID]
+			ws.sessionsMtx.Unlock()
+			if resumable {
+				t.Errorf("session of %s not ended", testUserID)
+			}
+			if tt.userID != "" {
+				clients[testOtherUserID].expect(t, EventUserDisconnected, nil)
+			}
+		})
+	}
+}
+
+func TestAccessGenerationsPruned(t *testing.T) {
+	ws := newTestHandler(t, newTestBackend(t, nil), Config{})
+	before := ws.accessKey(testUserID, testBoardID)
+	ws.InvalidateAccess(testBoardID, "", false)
+	ws.InvalidateAccess(testBoardID, testUserID, false)
+	invalidated := ws.accessKey(testUserID, testBoardID)
+
+	// The generations are dropped once the entries cached before the invalidation have expired
+	ws.accessMtx.Lock()
+	ws.pruneAccessGenerations(time.Now().Add(ws.accessGenerationTTL))
+	pruned := len(ws.accessGenerations)
+	ws.accessMtx.Unlock()
+	if pruned != 0 {
+		t.Fatalf("generations = %d, want 0", pruned)
+	}
+
+	// The dropped generations are not given out again
+	ws.InvalidateAccess(testBoardID, testUserID, false)
+	if got := ws.accessKey(testUserID, testBoardID); got == before || got == invalidated {
+		t.Errorf("accessKey() = %q reused after the invalidation", got)
+	}
+}
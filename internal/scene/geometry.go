@@ -0,0 +1,215 @@
+package scene
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// padding is the space around the elements on the exported canvas
+	padding = 10
+
+	// ellipseSegments is the number of segments approximating an ellipse
+	ellipseSegments = 64
+
+	// arrowheadLength is the maximum length of the arrowhead wings
+	arrowheadLength = 20
+
+	// arrowheadAngle is the angle between the arrow and the arrowhead wings
+	arrowheadAngle = math.Pi / 8
+)
+
+type point [2]float64
+
+// canvas is the area covering all the visible elements.
+type canvas struct {
+	minX, minY    float64
+	width, height float64
+}
+
+func newCanvas(elements []Element) canvas {
+	if len(elements) == 0 {
+		return canvas{width: 2 * padding, height: 2 * padding}
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i := range elements {
+		p := newPlacement(&elements[i], 0, 0)
+		x0, y0, x1, y1 := localBox(&elements[i])
+		for _, corner := range []point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}} {
+			x, y := p.apply(corner[0], corner[1])
+			minX, minY = min(minX, x), min(minY, y)
+			maxX, maxY = max(maxX, x), max(maxY, y)
+		}
+	}
+	return canvas{
+		minX:   minX - padding,
+		minY:   minY - padding,
+		width:  maxX - minX + 2*padding,
+		height: maxY - minY + 2*padding,
+	}
+}
+
+// placement maps the local coordinates of an element to the canvas coordinates.
+type placement struct {
+	// ox and oy are the position of the element origin on the canvas
+	ox, oy float64
+
+	// cx and cy are the local coordinates of the rotation center
+	cx, cy float64
+
+	sin, cos float64
+}
+
+func newPlacement(e *Element, originX, originY float64) placement {
+	x0, y0, x1, y1 := localBox(e)
+	sin, cos := math.Sincos(e.Angle)
+	return placement{
+		ox:  e.X - originX,
+		oy:  e.Y - originY,
+		cx:  (x0 + x1) / 2,
+		cy:  (y0 + y1) / 2,
+		sin: sin,
+		cos: cos,
+	}
+}
+
+func (c canvas) placement(e *Element) placement {
+	return newPlacement(e, c.minX, c.minY)
+}
+
+func (p placement) apply(x, y float64) (float64, float64) {
+	dx, dy := x-p.cx, y-p.cy
+	return p.cos*dx - p.sin*dy + p.cx + p.ox, p.sin*dx + p.cos*dy + p.cy + p.oy
+}
+
+// localBox returns the bounding box of the element in its local coordinates.
+func localBox(e *Element) (float64, float64, float64, float64) {
+	if !isLinear(e) || len(e.Points) == 0 {
+		return min(e.Width, 0), min(e.Height, 0), max(e.Width, 0), max(e.Height, 0)
+	}
+	x0, y0 := e.Points[0][0], e.Points[0][1]
+	x1, y1 := x0, y0
+	for _, p := range e.Points[1:] {
+		x0, y0 = min(x0, p[0]), min(y0, p[1])
+		x1, y1 = max(x1, p[0]), max(y1, p[1])
+	}
+	return x0, y0, x1, y1
+}
+
+func isLinear(e *Element) bool {
+	return e.Type == "line" || e.Type == "arrow" || e.Type == "freedraw"
+}
+
+// outline returns the path of the element in its local coordinates and whether it is closed.
+func outline(e *Element) ([]point, bool) {
+	w, h := e.Width, e.Height
+	switch e.Type {
+	case "ellipse":
+		points := make([]point, ellipseSegments)
+		for i := range points {
+			sin, cos := math.Sincos(2 * math.Pi * float64(i) / ellipseSegments)
+			points[i] = point{w/2 + w/2*cos, h/2 + h/2*sin}
+		}
+		return points, true
+	case "diamond":
+		return []point{{w / 2, 0}, {w, h / 2}, {w / 2, h}, {0, h / 2}}, true
+	case "line", "arrow", "freedraw":
+		points := make([]point, len(e.Points))
+		for i, p := range e.Points {
+			points[i] = p
+		}
+		closed := e.Type == "line" && len(points) > 2 && points[0] == points[len(points)-1]
+		if closed {
+			points = points[:len(points)-1]
+		}
+		return points, closed
+	case "text":
+		return nil, false
+	default:
+		// Rectangles, frames, images and embeds are drawn as rectangles
+		return []point{{0, 0}, {w, 0}, {w, h}, {0, h}}, true
+	}
+}
+
+// arrowheads returns the paths of the arrowheads in the local coordinates of the arrow.
+func arrowheads(e *Element) [][]point {
+	if e.Type != "arrow" || len(e.Points) < 2 {
+		return nil
+	}
+	heads := make([][]point, 0, 2)
+	if e.StartArrowhead != nil && *e.StartArrowhead != "" {
+		heads = append(heads, arrowhead(e.Points[0], e.Points[1]))
+	}
+	if e.EndArrowhead != nil && *e.EndArrowhead != "" {
+		heads = append(heads, arrowhead(e.Points[len(e.Points)-1], e.Points[len(e.Points)-2]))
+	}
+	return heads
+}
+
+// arrowhead returns the wings of the arrowhead at the tip of the segment from the tail.
+func arrowhead(tip, tail point) []point {
+	dx, dy := tail[0]-tip[0], tail[1]-tip[1]
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	size := min(arrowheadLength, length/2)
+	angle := math.Atan2(dy, dx)
+	wing := func(a float64) point {
+		sin, cos := math.Sincos(angle + a)
+		return point{tip[0] + size*cos, tip[1] + size*sin}
+	}
+	return []point{wing(arrowheadAngle), tip, wing(-arrowheadAngle)}
+}
+
+// dashPattern returns the lengths of the dashes and the gaps of the stroke, nil for a solid stroke.
+func dashPattern(e *Element) []float64 {
+	switch e.StrokeStyle {
+	case "dashed":
+		return []float64{8, 8 + e.StrokeWidth}
+	case "dotted":
+		return []float64{1.5, 6 + e.StrokeWidth}
+	default:
+		return nil
+	}
+}
+
+// parseColor parses the CSS hex color, it returns false for the transparent or unsupported colors.
+func parseColor(s string) (color.NRGBA, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	switch len(s) {
+	case 3, 4:
+		var expanded strings.Builder
+		for _, r := range s {
+			expanded.WriteRune(r)
+			expanded.WriteRune(r)
+		}
+		s = expanded.String()
+	case 6, 8:
+	default:
+		return color.NRGBA{}, false
+	}
+	if len(s) == 6 {
+		s += "ff"
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || v&0xff == 0 {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, true
+}
+
+// strokeColor returns the stroke color of the element, black if it is not set.
+func strokeColor(e *Element) color.NRGBA {
+	if c, ok := parseColor(e.StrokeColor); ok {
+		return c
+	}
+	if e.StrokeColor == "transparent" {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{A: 0xff}
+}
@@ -0,0 +1,114 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+var errInvalidDataURL = errors.New("invalid data URL")
+
+// Import is the scene loaded from an .excalidraw document.
+type Import struct {
+	// Elements is the JSON array of the elements
+	Elements string
+
+	// AppState is the JSON object of the app state
+	AppState string
+
+	// Files is the list of the files of the document
+	Files []*models.File
+}
+
+// ParseDocument validates the .excalidraw document, or an object with the elements and the app state,
+// and loads the scene of the board from it.
+func ParseDocument(boardID string, data []byte) (*Import, error) {
+	var document Document
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScene, err)
+	}
+	if document.Type != "" && document.Type != DocumentType {
+		return nil, fmt.Errorf("%w: unknown document type '%s'", ErrInvalidScene, document.Type)
+	}
+
+	// The elements must be an array of the objects with id and type
+	var elements []struct {
+		ID   *string `json:"id"`
+		Type *string `json:"type"`
+	}
+	if err := json.Unmarshal(document.Elements, &elements); err != nil || elements == nil {
+		return nil, fmt.Errorf("%w: elements must be an array of objects", ErrInvalidScene)
+	}
+	for i, e := range elements {
+		if e.ID == nil || *e.ID == "" || e.Type == nil || *e.Type == "" {
+			return nil, fmt.Errorf("%w: element %d has no id or type", ErrInvalidScene, i)
+		}
+	}
+	if _, err := ParseElements(string(document.Elements)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScene, err)
+	}
+
+	appState := []byte("{}")
+	if len(document.AppState) > 0 && !bytes.Equal(document.AppState, []byte("null")) {
+		var parsed map[string]json.RawMessage
+		if err := json.Unmarshal(document.AppState, &parsed); err != nil {
+			return nil, fmt.Errorf("%w: app state must be an object", ErrInvalidScene)
+		}
+		appState = document.AppState
+	}
+
+	files := make([]*models.File, 0, len(document.Files))
+	for id, f := range document.Files {
+		if id == "" || len(id) > models.MaxFileIDLength {
+			return nil, fmt.Errorf("%w: file id must be 1 to %d characters long",
+				ErrInvalidScene, models.MaxFileIDLength)
+		}
+		mimeType, content, err := parseDataURL(f.DataURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: file '%s': %w", ErrInvalidScene, id, err)
+		}
+		if f.MimeType != "" {
+			mimeType = f.MimeType
+		}
+		files = append(files, &models.File{
+			ID:       id,
+			BoardID:  boardID,
+			MimeType: mimeType,
+			Data:     content,
+			Created:  f.Created,
+		})
+	}
+
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, document.Elements); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScene, err)
+	}
+	compactedAppState := &bytes.Buffer{}
+	if err := json.Compact(compactedAppState, appState); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScene, err)
+	}
+
+	return &Import{
+		Elements: compacted.String(),
+		AppState: compactedAppState.String(),
+		Files:    files,
+	}, nil
+}
+
+// parseDataURL returns the MIME type and the content of the base64 data URL.
+func parseDataURL(dataURL string) (string, []byte, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok || !strings.HasPrefix(dataURL, "data:") || !strings.HasSuffix(header, ";base64") {
+		return "", nil, errInvalidDataURL
+	}
+	content, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode data URL: %w", err)
+	}
+	return strings.TrimSuffix(header, ";base64"), content, nil
+}
@@ -0,0 +1,91 @@
+package scene
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testBoardID = "board-1"
+
+func TestParseDocument(t *testing.T) {
+	document := `{
+		"type": "excalidraw",
+		"version": 2,
+		"elements": [{"id": "a", "type": "rectangle"}],
+		"appState": {"viewBackgroundColor": "#fff"},
+		"files": {"f": {"mimeType": "image/png", "dataURL": "data:image/jpeg;base64,iVBORw=="}}
+	}`
+
+	imported, err := ParseDocument(testBoardID, []byte(document))
+	if err != nil {
+		t.Fatalf("ParseDocument() unexpected error: %v", err)
+	}
+	if want := `[{"id":"a","type":"rectangle"}]`; imported.Elements != want {
+		t.Errorf("Elements = %s, want %s", imported.Elements, want)
+	}
+	if want := `{"viewBackgroundColor":"#fff"}`; imported.AppState != want {
+		t.Errorf("AppState = %s, want %s", imported.AppState, want)
+	}
+	if len(imported.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(imported.Files))
+	}
+	// The MIME type of the file takes precedence over the one of the data URL
+	f := imported.Files[0]
+	if f.ID != "f" || f.BoardID != testBoardID || f.MimeType != "image/png" ||
+		!bytes.Equal(f.Data, []byte{0x89, 'P', 'N', 'G'}) {
+		t.Errorf("file = %+v, want the decoded PNG of the board", f)
+	}
+}
+
+func TestParseDocumentWithoutAppState(t *testing.T) {
+	imported, err := ParseDocument(testBoardID, []byte(`{"elements": [], "appState": null}`))
+	if err != nil {
+		t.Fatalf("ParseDocument() unexpected error: %v", err)
+	}
+	if imported.Elements != "[]" || imported.AppState != "{}" || len(imported.Files) != 0 {
+		t.Errorf("ParseDocument() = %+v, want the empty scene", imported)
+	}
+}
+
+func TestParseDocumentRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{name: "invalid JSON", document: `{`},
+		{name: "unknown type", document: `{"type": "other", "elements": []}`},
+		{name: "no elements", document: `{"type": "excalidraw"}`},
+		{name: "elements not an array", document: `{"elements": {}}`},
+		{name: "element without id", document: `{"elements": [{"type": "rectangle"}]}`},
+		{name: "element without type", document: `{"elements": [{"id": "a"}]}`},
+		{name: "app state not an object", document: `{"elements": [], "appState": []}`},
+		{
+			name:     "file without data URL",
+			document: `{"elements": [], "files": {"f": {"dataURL": "image/png;base64,iVBORw=="}}}`,
+		},
+		{
+			name:     "file not in base64",
+			document: `{"elements": [], "files": {"f": {"dataURL": "data:image/png,raw"}}}`,
+		},
+		{
+			name:     "file with invalid base64",
+			document: `{"elements": [], "files": {"f": {"dataURL": "data:image/png;base64,!"}}}`,
+		},
+		{
+			name: "file id too long",
+			document: `{"elements": [], "files": {"` + strings.Repeat("f", 1000) +
+				`": {"dataURL": "data:image/png;base64,iVBORw=="}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDocument(testBoardID, []byte(tt.document))
+			if !errors.Is(err, ErrInvalidScene) {
+				t.Errorf("ParseDocument() error = %v, want %v", err, ErrInvalidScene)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package scene
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	// Register the decoders of the image files.
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+	_ "golang.org/x/image/webp"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	// MaxScale is the maximum scale of the PNG image
+	MaxScale = 4
+
+	// maxCanvasPixels is the maximum number of pixels of the PNG image, bigger canvases are scaled down
+	maxCanvasPixels = 4096 * 4096
+
+	// maxCanvasSide is the maximum width and height of the PNG image in pixels, longer canvases are scaled down
+	maxCanvasSide = 8192
+
+	// maxDashes is the maximum number of the dashes of a path, the rest of the path is drawn solid
+	maxDashes = 1 << 14
+
+	// maxStrokeSegments is the maximum number of the stroke segments of the image, the strokes beyond it are skipped
+	maxStrokeSegments = 1 << 20
+
+	// maxImagePixels is the maximum number of pixels of an image file drawn on the canvas
+	maxImagePixels = 4096 * 4096
+
+	// joinSegments is the number of segments of the round joins of the strokes
+	joinSegments = 12
+)
+
+// PNGRenderer renders the scenes as PNG images. The fonts are parsed once, on the first render.
+type PNGRenderer struct {
+	fonts func() (map[int]*opentype.Font, error)
+}
+
+// NewPNGRenderer creates the renderer.
+func NewPNGRenderer() *PNGRenderer {
+	return &PNGRenderer{
+		fonts: sync.OnceValues(loadFonts),
+	}
+}
+
+// loadFonts parses the fonts used to draw the text, the monospace font is used for the Excalidraw code font.
+func loadFonts() (map[int]*opentype.Font, error) {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	mono, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	return map[int]*opentype.Font{0: regular, 3: mono}, nil
+}
+
+// RenderPNG renders the elements as a PNG image. Files is a map of the files of the image elements by id.
+// The canvas is scaled down if it exceeds the maximum number of pixels at the given scale.
+func (p *PNGRenderer) RenderPNG(
+	w io.Writer,
+	elements []Element,
+	appState AppState,
+	files map[string]*models.File,
+	scale float64,
+) error {
+	fontFaces, err := p.fonts()
+	if err != nil {
+		return err
+	}
+
+	elements = visible(elements)
+	c := newCanvas(elements)
+
+	scale = min(max(scale, 0), MaxScale)
+	if scale == 0 {
+		scale = 1
+	}
+	if pixels := c.width * c.height * scale * scale; pixels > maxCanvasPixels {
+		scale *= math.Sqrt(maxCanvasPixels / pixels)
+	}
+	if side := max(c.width, c.height) * scale; side > maxCanvasSide {
+		scale *= maxCanvasSide / side
+	}
+	width, height := max(int(math.Ceil(c.width*scale)), 1), max(int(math.Ceil(c.height*scale)), 1)
+
+	r := &pngRenderer{
+		dst:    image.NewRGBA(image.Rect(0, 0, width, height)),
+		raster: vector.NewRasterizer(width, height),
+		scale:  scale,
+		fonts:  fontFaces,
+		faces:  make(map[faceKey]font.Face),
+		files:  files,
+	}
+	defer r.close()
+
+	bg, ok := parseColor(appState.ViewBackgroundColor)
+	if !ok && appState.ViewBackgroundColor == "" {
+		bg = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	}
+	draw.Draw(r.dst, r.dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for i := range elements {
+		r.element(c, &elements[i])
+	}
+
+	if err = png.Encode(w, r.dst); err != nil {
+		return fmt.Errorf("failed to encode png: %w", err)
+	}
+	return nil
+}
+
+type faceKey struct {
+	family int
+	size   float64
+}
+
+type pngRenderer struct {
+	dst    *image.RGBA
+	raster *vector.Rasterizer
+	scale  float64
+
+	// fonts is a map of the fonts by Excalidraw font family, 0 is the default font
+	fonts map[int]*opentype.Font
+
+	// faces is a map of the font faces created while rendering
+	faces map[faceKey]font.Face
+
+	files map[string]*models.File
+
+	// segments is the number of the stroke segments drawn so far
+	segments int
+}
+
+func (r *pngRenderer) close() {
+	for _, face := range r.faces {
+		_ = face.Close()
+	}
+}
+
+func (r *pngRenderer) element(c canvas, e *Element) {
+	p := c.placement(e)
+	alpha := e.opacity() / 100
+
+	switch e.Type {
+	case "text":
+		r.text(p, e, alpha)
+		return
+	case "image":
+		if img := r.decodeFile(e.FileID); img != nil {
+			r.transform(p, img, e.Width, e.Height, alpha)
+			return
+		}
+	}
+
+	points, closed := outline(e)
+	if len(points) == 0 {
+		return
+	}
+	if bg, ok := parseColor(e.BackgroundColor); ok && closed {
+		r.fill(r.place(p, points), withAlpha(bg, alpha))
+	}
+	if e.StrokeWidth <= 0 {
+		return
+	}
+	stroke := withAlpha(strokeColor(e), alpha)
+	paths := [][]point{r.place(p, points)}
+	if closed {
+		paths[0] = append(paths[0], paths[0][0])
+	}
+	for _, head := range arrowheads(e) {
+		paths = append(paths, r.place(p, head))
+	}
+	r.stroke(paths, e.StrokeWidth*r.scale, dashPattern(e), stroke)
+}
+
+// place maps the local points of the element to the pixels of the image.
+func (r *pngRenderer) place(p placement, points []point) []point {
+	placed := make([]point, len(points))
+	for i, pt := range points {
+		x, y := p.apply(pt[0], pt[1])
+		placed[i] = point{x * r.scale, y * r.scale}
+	}
+	return placed
+}
+
+func (r *pngRenderer) fill(points []point, c color.Color) {
+	r.draw([][]point{points}, c)
+}
+
+// stroke draws the paths as lines with round joins and caps, the dash pattern is in the element units.
+func (r *pngRenderer) stroke(paths [][]point, width float64, dash []float64, c color.Color) {
+	width = max(width, 1)
+	polygons := make([][]point, 0)
+	for _, path := range paths {
+		for _, segment := range dashes(path, dash, r.scale) {
+			r.segments += len(segment)
+			if r.segments > maxStrokeSegments {
+				break
+			}
+			for i := 1; i < len(segment); i++ {
+				polygons = append(polygons, segmentPolygon(segment[i-1], segment[i], width))
+			}
+			for _, pt := range segment {
+				polygons = append(polygons, joinPolygon(pt, width/2))
+			}
+		}
+	}
+	r.draw(polygons, c)
+}
+
+// draw fills the polygons, the rasterizer covers only the polygons bounds to keep small elements cheap.
+func (r *pngRenderer) draw(polygons [][]point, c color.Color) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, polygon := range polygons {
+		for _, p := range polygon {
+			minX, minY = min(minX, p[0]), min(minY, p[1])
+			maxX, maxY = max(maxX, p[0]), max(maxY, p[1])
+		}
+	}
+	rect := image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	).Intersect(r.dst.Bounds())
+	if rect.Empty() {
+		return
+	}
+
+	r.raster.Reset(rect.Dx(), rect.Dy())
+	offset := point{float64(rect.Min.X), float64(rect.Min.Y)}
+	for _, polygon := range polygons {
+		addPolygon(r.raster, polygon, offset)
+	}
+	r.raster.Draw(r.dst, rect, image.NewUniform(c), image.Point{})
+}
+
+func (r *pngRenderer) text(p placement, e *Element, alpha float64) {
+	width, height := int(math.Ceil(e.Width*r.scale)), int(math.Ceil(e.Height*r.scale))
+	if e.Text == "" || width <= 0 || height <= 0 ||
+		max(width, height) > maxCanvasSide || width*height > maxCanvasPixels {
+		return
+	}
+	face, err := r.face(e.fontFamily(), e.fontSize()*r.scale)
+	if err != nil {
+		return
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(strokeColor(e)), Face: face}
+	fontSize, lineHeight := e.fontSize()*r.scale, e.fontSize()*e.lineHeight()*r.scale
+	for i, line := range strings.Split(e.Text, "\n") {
+		var x float64
+		switch e.TextAlign {
+		case "center":
+			x = (float64(width) - fixedToFloat(d.MeasureString(line))) / 2
+		case "right":
+			x = float64(width) - fixedToFloat(d.MeasureString(line))
+		}
+		y := float64(i)*lineHeight + baseline(fontSize, lineHeight)
+		d.Dot = fixed.Point26_6{X: floatToFixed(x), Y: floatToFixed(y)}
+		d.DrawString(line)
+	}
+	r.transform(p, img, e.Width, e.Height, alpha)
+}
+
+func (r *pngRenderer) face(family int, size float64) (font.Face, error) {
+	if _, ok := r.fonts[family]; !ok {
+		family = 0
+	}
+	key := faceKey{family: family, size: size}
+	if face, ok := r.faces[key]; ok {
+		return face, nil
+	}
+	face, err := opentype.NewFace(r.fonts[family], &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	r.faces[key] = face
+	return face, nil
+}
+
+// decodeFile decodes the image file, it returns nil if the file is missing or can't be decoded.
+func (r *pngRenderer) decodeFile(fileID string) image.Image {
+	f := r.files[fileID]
+	if f == nil {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(f.Data))
+	if err != nil || config.Width*config.Height > maxImagePixels {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// transform draws the image stretched to the element of the given size.
+func (r *pngRenderer) transform(p placement, img image.Image, width, height, alpha float64) {
+	bounds := img.Bounds()
+	if bounds.Empty() || width <= 0 || height <= 0 {
+		return
+	}
+	sx, sy := width/float64(bounds.Dx()), height/float64(bounds.Dy())
+	s := r.scale
+	aff := f64.Aff3{
+		s * p.cos * sx, -s * p.sin * sy, s * (-p.cos*p.cx + p.sin*p.cy + p.cx + p.ox),
+		s * p.sin * sx, s * p.cos * sy, s * (-p.sin*p.cx - p.cos*p.cy + p.cy + p.oy),
+	}
+	// The transformation maps the image origin, so the image bounds must start at zero
+	aff[2] -= aff[0]*float64(bounds.Min.X) + aff[1]*float64(bounds.Min.Y)
+	aff[5] -= aff[3]*float64(bounds.Min.X) + aff[4]*float64(bounds.Min.Y)
+
+	var opts *draw.Options
+	if alpha < 1 {
+		opts = &draw.Options{SrcMask: image.NewUniform(color.Alpha{A: uint8(alpha * 0xff)})}
+	}
+	draw.BiLinear.Transform(r.dst, aff, img, bounds, draw.Over, opts)
+}
+
+// dashes splits the path into the dashes, scale converts the pattern to pixels.
+// The path beyond maxDashes dashes is returned as a single solid segment.
+func dashes(path []point, pattern []float64, scale float64) [][]point {
+	if len(pattern) == 0 || len(path) < 2 {
+		return [][]point{path}
+	}
+
+	result := make([][]point, 0)
+	index, left, on := 0, max(pattern[0]*scale, 0.5), true
+	current := []point{path[0]}
+	for i := 1; i < len(path); i++ {
+		from, to := path[i-1], path[i]
+		length := math.Hypot(to[0]-from[0], to[1]-from[1])
+		for length > left {
+			if len(result) >= maxDashes {
+				return append(result, append([]point{from}, path[i:]...))
+			}
+			t := left / length
+			from = point{from[0] + (to[0]-from[0])*t, from[1] + (to[1]-from[1])*t}
+			length -= left
+			if on {
+				result = append(result, append(current, from))
+			}
+			current = []point{from}
+			on = !on
+			index = (index + 1) % len(pattern)
+			left = max(pattern[index]*scale, 0.5)
+		}
+		left -= length
+		current = append(current, to)
+	}
+	if on && len(current) > 1 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// segmentPolygon returns the rectangle covering the line segment of the given width.
+func segmentPolygon(from, to point, width float64) []point {
+	dx, dy := to[0]-from[0], to[1]-from[1]
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	nx, ny := -dy/length*width/2, dx/length*width/2
+	return []point{
+		{from[0] + nx, from[1] + ny},
+		{to[0] + nx, to[1] + ny},
+		{to[0] - nx, to[1] - ny},
+		{from[0] - nx, from[1] - ny},
+	}
+}
+
+// joinPolygon returns the circle joining the segments of a stroke.
+func joinPolygon(center point, radius float64) []point {
+	points := make([]point, joinSegments)
+	for i := range points {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / joinSegments)
+		points[i] = point{center[0] + radius*cos, center[1] + radius*sin}
+	}
+	return points
+}
+
+// addPolygon adds the closed polygon moved by the offset in the same winding order for all the polygons,
+// so the overlapping polygons don't cancel each other.
+func addPolygon(z *vector.Rasterizer, points []point, offset point) {
+	if len(points) < 3 {
+		return
+	}
+	var area float64
+	for i, p := range points {
+		next := points[(i+1)%len(points)]
+		area += p[0]*next[1] - next[0]*p[1]
+	}
+
+	z.MoveTo(float32(points[0][0]-offset[0]), float32(points[0][1]-offset[1]))
+	for i := 1; i < len(points); i++ {
+		j := i
+		if area < 0 {
+			j = len(points) - i
+		}
+		z.LineTo(float32(points[j][0]-offset[0]), float32(points[j][1]-offset[1]))
+	}
+	z.ClosePath()
+}
+
+func withAlpha(c color.NRGBA, alpha float64) color.NRGBA {
+	c.A = uint8(float64(c.A) * alpha)
+	return c
+}
+
+func fixedToFloat(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
+
+func floatToFixed(v float64) fixed.Int26_6 {
+	return fixed.Int26_6(v * 64)
+}
@@ -0,0 +1,275 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxElements   = 10000
+	defaultMaxTextLength = 20000
+
+	// maxCoordinate is the maximum absolute value of the numeric fields
+	maxCoordinate = 1e9
+
+	// jsonNull is the JSON null value
+	jsonNull = "null"
+)
+
+var errMissingID = errors.New("missing id")
+
+// Limits restricts the size of the scene.
+type Limits struct {
+	// MaxElements is the maximum number of the elements, including the deleted ones
+	MaxElements int
+
+	// MaxTextLength is the maximum length of the text of an element in characters
+	MaxTextLength int
+}
+
+// WithDefaults returns a copy of the limits with zero values replaced by defaults.
+func (l Limits) WithDefaults() Limits {
+	if l.MaxElements <= 0 {
+		l.MaxElements = defaultMaxElements
+	}
+	if l.MaxTextLength <= 0 {
+		l.MaxTextLength = defaultMaxTextLength
+	}
+	return l
+}
+
+// fieldKind is the JSON type of an element field.
+type fieldKind int
+
+const (
+	kindNumber fieldKind = iota
+	kindString
+	kindNullableString
+	kindBool
+	kindStringArray
+	kindPoints
+	kindNullableArray
+	kindNullableObject
+	kindNumberArray
+	kindLink
+)
+
+// elementField returns the kind of the known field of the Excalidraw elements, the other fields are stripped.
+func elementField(name string) (fieldKind, bool) {
+	switch name {
+	case "id", "type", "strokeColor", "backgroundColor", "fillStyle", "strokeStyle", "text", "originalText",
+		"textAlign", "verticalAlign", "status":
+		return kindString, true
+	case "x", "y", "width", "height", "angle", "strokeWidth", "roughness", "opacity", "seed", "version",
+		"versionNonce", "updated", "fontSize", "fontFamily", "lineHeight", "baseline":
+		return kindNumber, true
+	case "groupIds":
+		return kindStringArray, true
+	case "frameId", "index", "startArrowhead", "endArrowhead", "containerId", "fileId", "name":
+		return kindNullableString, true
+	case "roundness", "customData", "startBinding", "endBinding", "crop":
+		return kindNullableObject, true
+	case "isDeleted", "locked", "simulatePressure", "elbowed", "autoResize", "validated":
+		return kindBool, true
+	case "boundElements", "lastCommittedPoint", "fixedSegments":
+		return kindNullableArray, true
+	case "link":
+		return kindLink, true
+	case "points":
+		return kindPoints, true
+	case "pressures", "scale":
+		return kindNumberArray, true
+	default:
+		return 0, false
+	}
+}
+
+// isElementType reports whether the type is one of the Excalidraw element types.
+func isElementType(elementType string) bool {
+	switch elementType {
+	case "selection", "rectangle", "diamond", "ellipse", "arrow", "line", "freedraw", "text", "image", "frame",
+		"magicframe", "embeddable", "iframe":
+		return true
+	default:
+		return false
+	}
+}
+
+// isLinkScheme reports whether the scheme is allowed in the element links.
+func isLinkScheme(scheme string) bool {
+	switch scheme {
+	case "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
+
+// Sanitize validates the JSON array of the elements against the Excalidraw element schema.
+// It returns the elements without the unknown fields and the unsafe links,
+// or ErrInvalidScene if the elements are malformed or exceed the limits.
+func Sanitize(elements string, limits Limits) (string, error) {
+	limits = limits.WithDefaults()
+	if elements == "" {
+		return "", nil
+	}
+
+	var parsed []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(elements), &parsed); err != nil || parsed == nil {
+		return "", fmt.Errorf("%w: elements must be an array of objects", ErrInvalidScene)
+	}
+	if len(parsed) > limits.MaxElements {
+		return "", fmt.Errorf("%w: more than %d elements", ErrInvalidScene, limits.MaxElements)
+	}
+
+	for i, element := range parsed {
+		if element == nil {
+			return "", fmt.Errorf("%w: element %d is not an object", ErrInvalidScene, i)
+		}
+		if err := sanitizeElement(element, limits); err != nil {
+			return "", fmt.Errorf("%w: element %d: %w", ErrInvalidScene, i, err)
+		}
+	}
+
+	sanitized, err := json.Marshal(parsed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode elements: %w", err)
+	}
+	return string(sanitized), nil
+}
+
+// ValidateAppState checks that the app state is a JSON object.
+func ValidateAppState(appState string) error {
+	if appState == "" {
+		return nil
+	}
+	var parsed map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(appState), &parsed); err != nil || parsed == nil {
+		return fmt.Errorf("%w: app state must be an object", ErrInvalidScene)
+	}
+	return nil
+}
+
+func sanitizeElement(element map[string]json.RawMessage, limits Limits) error {
+	for field, value := range element {
+		kind, ok := elementField(field)
+		if !ok {
+			delete(element, field)
+			continue
+		}
+		if kind == kindLink {
+			if !safeLink(value) {
+				element[field] = json.RawMessage(jsonNull)
+			}
+			continue
+		}
+		if !matchesKind(value, kind) {
+			return fmt.Errorf("invalid field '%s'", field)
+		}
+	}
+
+	var id, elementType string
+	if json.Unmarshal(element["id"], &id) != nil || id == "" {
+		return errMissingID
+	}
+	if json.Unmarshal(element["type"], &elementType) != nil || !isElementType(elementType) {
+		return fmt.Errorf("unknown type '%s'", elementType)
+	}
+
+	for _, field := range []string{"text", "originalText"} {
+		var text string
+		if value, ok := element[field]; ok && json.Unmarshal(value, &text) == nil {
+			if utf8.RuneCountInString(text) > limits.MaxTextLength {
+				return fmt.Errorf("text longer than %d characters", limits.MaxTextLength)
+			}
+		}
+	}
+	return nil
+}
+
+func matchesKind(value json.RawMessage, kind fieldKind) bool {
+	value = bytes.TrimSpace(value)
+	isNull := bytes.Equal(value, []byte(jsonNull))
+
+	switch kind {
+	case kindNumber:
+		var v float64
+		return json.Unmarshal(value, &v) == nil && !isNull && validNumber(v)
+	case kindString:
+		var v string
+		return !isNull && json.Unmarshal(value, &v) == nil
+	case kindNullableString:
+		var v *string
+		return json.Unmarshal(value, &v) == nil
+	case kindBool:
+		var v bool
+		return !isNull && json.Unmarshal(value, &v) == nil
+	case kindStringArray:
+		var v []string
+		return !isNull && json.Unmarshal(value, &v) == nil
+	case kindNumberArray:
+		var v []float64
+		if isNull || json.Unmarshal(value, &v) != nil {
+			return false
+		}
+		for _, n := range v {
+			if !validNumber(n) {
+				return false
+			}
+		}
+		return true
+	case kindPoints:
+		var v [][2]float64
+		if isNull || json.Unmarshal(value, &v) != nil {
+			return false
+		}
+		for _, p := range v {
+			if !validNumber(p[0]) || !validNumber(p[1]) {
+				return false
+			}
+		}
+		return true
+	case kindNullableArray:
+		var v []json.RawMessage
+		return json.Unmarshal(value, &v) == nil
+	case kindNullableObject:
+		var v map[string]json.RawMessage
+		return json.Unmarshal(value, &v) == nil
+	default:
+		return false
+	}
+}
+
+func validNumber(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0) && math.Abs(v) <= maxCoordinate
+}
+
+// safeLink reports whether the link is null, relative or uses an allowed scheme.
+func safeLink(value json.RawMessage) bool {
+	var link *string
+	if json.Unmarshal(value, &link) != nil {
+		return false
+	}
+	if link == nil {
+		return true
+	}
+
+	// Browsers ignore the whitespace and the control characters in the scheme, e.g. "java\tscript:"
+	normalized := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, *link)
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || isLinkScheme(strings.ToLower(u.Scheme))
+}
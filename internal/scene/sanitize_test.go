@@ -0,0 +1,181 @@
+package scene
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements string
+		limits   Limits
+		want     string
+	}{
+		{
+			name:     "empty",
+			elements: "",
+			want:     "",
+		},
+		{
+			name:     "empty array",
+			elements: `[]`,
+			want:     `[]`,
+		},
+		{
+			name:     "valid element",
+			elements: `[{"id":"a","type":"rectangle","x":1,"y":2,"isDeleted":false}]`,
+			want:     `[{"id":"a","isDeleted":false,"type":"rectangle","x":1,"y":2}]`,
+		},
+		{
+			name:     "unknown fields are stripped",
+			elements: `[{"id":"a","type":"text","text":"hi","onclick":"alert(1)"}]`,
+			want:     `[{"id":"a","text":"hi","type":"text"}]`,
+		},
+		{
+			name:     "unsafe link is dropped",
+			elements: `[{"id":"a","type":"rectangle","link":"javascript:alert(1)"}]`,
+			want:     `[{"id":"a","link":null,"type":"rectangle"}]`,
+		},
+		{
+			name:     "safe link is kept",
+			elements: `[{"id":"a","type":"rectangle","link":"https://example.com"}]`,
+			want:     `[{"id":"a","link":"https://example.com","type":"rectangle"}]`,
+		},
+		{
+			name:     "text within the limit",
+			elements: `[{"id":"a","type":"text","text":"` + strings.Repeat("é", 2) + `"}]`,
+			limits:   Limits{MaxTextLength: 2},
+			want:     `[{"id":"a","text":"éé","type":"text"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sanitize(tt.elements, tt.limits)
+			if err != nil {
+				t.Fatalf("Sanitize() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Sanitize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements string
+		limits   Limits
+	}{
+		{
+			name:     "not an array",
+			elements: `{"id":"a","type":"rectangle"}`,
+		},
+		{
+			name:     "malformed JSON",
+			elements: `[{"id":"a"`,
+		},
+		{
+			name:     "element is not an object",
+			elements: `[null]`,
+		},
+		{
+			name:     "missing id",
+			elements: `[{"type":"rectangle"}]`,
+		},
+		{
+			name:     "unknown type",
+			elements: `[{"id":"a","type":"script"}]`,
+		},
+		{
+			name:     "wrong field type",
+			elements: `[{"id":"a","type":"rectangle","x":"1"}]`,
+		},
+		{
+			name:     "coordinate out of range",
+			elements: `[{"id":"a","type":"rectangle","x":1e12}]`,
+		},
+		{
+			name:     "invalid point",
+			elements: `[{"id":"a","type":"line","points":[[0,0],[1e12,0]]}]`,
+		},
+		{
+			name:     "too many elements",
+			elements: `[{"id":"a","type":"rectangle"},{"id":"b","type":"rectangle"}]`,
+			limits:   Limits{MaxElements: 1},
+		},
+		{
+			name:     "text too long",
+			elements: `[{"id":"a","type":"text","text":"` + strings.Repeat("é", 3) + `"}]`,
+			limits:   Limits{MaxTextLength: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sanitize(tt.elements, tt.limits); !errors.Is(err, ErrInvalidScene) {
+				t.Errorf("Sanitize() error = %v, want %v", err, ErrInvalidScene)
+			}
+		})
+	}
+}
+
+func TestValidateAppState(t *testing.T) {
+	tests := []struct {
+		name     string
+		appState string
+		wantErr  bool
+	}{
+		{name: "empty", appState: ""},
+		{name: "object", appState: `{"viewBackgroundColor":"#fff"}`},
+		{name: "array", appState: `[]`, wantErr: true},
+		{name: "null value", appState: jsonNull, wantErr: true},
+		{name: "malformed", appState: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAppState(tt.appState)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAppState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSafeLink(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want bool
+	}{
+		{name: "null link", link: jsonNull, want: true},
+		{name: "relative", link: `"/boards/1"`, want: true},
+		{name: "fragment", link: `"#element"`, want: true},
+		{name: "http", link: `"http://example.com"`, want: true},
+		{name: "https", link: `"https://example.com/path?q=1"`, want: true},
+		{name: "mailto", link: `"mailto:user@example.com"`, want: true},
+		{name: "uppercase scheme", link: `"HTTPS://example.com"`, want: true},
+		{name: "javascript", link: `"javascript:alert(1)"`, want: false},
+		{name: "mixed case javascript", link: `"JaVaScRiPt:alert(1)"`, want: false},
+		{name: "javascript with tab", link: `"java\tscript:alert(1)"`, want: false},
+		{name: "javascript with newline", link: `"java\nscript:alert(1)"`, want: false},
+		{name: "javascript with leading space", link: `" javascript:alert(1)"`, want: false},
+		{name: "data", link: `"data:text/html,<script>alert(1)</script>"`, want: false},
+		{name: "vbscript", link: `"vbscript:msgbox(1)"`, want: false},
+		{name: "not a string", link: `1`, want: false},
+		{name: "object", link: `{"href":"https://example.com"}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := safeLink(json.RawMessage(tt.link)); got != tt.want {
+				t.Errorf("safeLink(%s) = %v, want %v", tt.link, got, tt.want)
+			}
+		})
+	}
+}
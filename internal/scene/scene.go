@@ -0,0 +1,207 @@
+package scene
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	// DocumentType is the type of the .excalidraw documents
+	DocumentType = "excalidraw"
+
+	// DocumentVersion is the version of the .excalidraw documents
+	DocumentVersion = 2
+
+	// DocumentSource is the source of the documents exported by the server
+	DocumentSource = "excaliroom"
+)
+
+const (
+	defaultOpacity    = 100
+	defaultLineHeight = 1.25
+	defaultFontSize   = 20
+)
+
+var ErrInvalidScene = errors.New("invalid scene")
+
+// Element is an Excalidraw element with the fields used to render it.
+//
+//nolint:tagliatelle
+type Element struct {
+	ID              string       `json:"id"`
+	Type            string       `json:"type"`
+	X               float64      `json:"x"`
+	Y               float64      `json:"y"`
+	Width           float64      `json:"width"`
+	Height          float64      `json:"height"`
+	Angle           float64      `json:"angle"`
+	StrokeColor     string       `json:"strokeColor"`
+	BackgroundColor string       `json:"backgroundColor"`
+	StrokeWidth     float64      `json:"strokeWidth"`
+	StrokeStyle     string       `json:"strokeStyle"`
+	Opacity         *float64     `json:"opacity"`
+	Roundness       *Roundness   `json:"roundness"`
+	IsDeleted       bool         `json:"isDeleted"`
+	Points          [][2]float64 `json:"points"`
+	StartArrowhead  *string      `json:"startArrowhead"`
+	EndArrowhead    *string      `json:"endArrowhead"`
+	Text            string       `json:"text"`
+	FontSize        float64      `json:"fontSize"`
+	FontFamily      float64      `json:"fontFamily"`
+	TextAlign       string       `json:"textAlign"`
+	LineHeight      float64      `json:"lineHeight"`
+	FileID          string       `json:"fileId"`
+}
+
+// Roundness is the corner rounding of an element.
+// The numbers are decoded as float64, so any number accepted by Sanitize can be parsed.
+type Roundness struct {
+	Type float64 `json:"type"`
+}
+
+// AppState is the part of the Excalidraw app state used to render the scene.
+//
+//nolint:tagliatelle
+type AppState struct {
+	ViewBackgroundColor string `json:"viewBackgroundColor"`
+}
+
+// Document is the .excalidraw file format.
+//
+//nolint:tagliatelle
+type Document struct {
+	Type     string          `json:"type"`
+	Version  int             `json:"version"`
+	Source   string          `json:"source"`
+	Elements json.RawMessage `json:"elements"`
+	AppState json.RawMessage `json:"appState"`
+	Files    map[string]File `json:"files"`
+}
+
+// File is a file of the .excalidraw document.
+//
+//nolint:tagliatelle
+type File struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mimeType"`
+	DataURL  string `json:"dataURL"`
+	Created  int64  `json:"created"`
+}
+
+// ParseElements parses the JSON array of the elements stored in the room.
+func ParseElements(elements string) ([]Element, error) {
+	if elements == "" {
+		return nil, nil
+	}
+	var parsed []Element
+	if err := json.Unmarshal([]byte(elements), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse elements: %w", err)
+	}
+	return parsed, nil
+}
+
+// ParseAppState parses the JSON object of the app state stored in the room.
+func ParseAppState(appState string) (AppState, error) {
+	var parsed AppState
+	if appState == "" {
+		return parsed, nil
+	}
+	if err := json.Unmarshal([]byte(appState), &parsed); err != nil {
+		return parsed, fmt.Errorf("failed to parse app state: %w", err)
+	}
+	return parsed, nil
+}
+
+// FileIDs returns the ids of the files used by the image elements.
+func FileIDs(elements []Element) []string {
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, e := range elements {
+		if e.IsDeleted || e.Type != "image" || e.FileID == "" || seen[e.FileID] {
+			continue
+		}
+		seen[e.FileID] = true
+		ids = append(ids, e.FileID)
+	}
+	return ids
+}
+
+// NewDocument creates the .excalidraw document from the elements and the app state stored in the room.
+func NewDocument(elements, appState string, files []*models.File) (*Document, error) {
+	if elements == "" {
+		elements = "[]"
+	}
+	if appState == "" {
+		appState = "{}"
+	}
+	if !json.Valid([]byte(elements)) || !json.Valid([]byte(appState)) {
+		return nil, ErrInvalidScene
+	}
+
+	documentFiles := make(map[string]File, len(files))
+	for _, f := range files {
+		documentFiles[f.ID] = File{
+			ID:       f.ID,
+			MimeType: f.MimeType,
+			DataURL:  DataURL(f),
+			Created:  f.Created,
+		}
+	}
+
+	return &Document{
+		Type:     DocumentType,
+		Version:  DocumentVersion,
+		Source:   DocumentSource,
+		Elements: json.RawMessage(elements),
+		AppState: json.RawMessage(appState),
+		Files:    documentFiles,
+	}, nil
+}
+
+// DataURL returns the file content as a data URL.
+func DataURL(f *models.File) string {
+	return "data:" + f.MimeType + ";base64," + base64.StdEncoding.EncodeToString(f.Data)
+}
+
+func (e *Element) opacity() float64 {
+	if e.Opacity == nil {
+		return defaultOpacity
+	}
+	return min(max(*e.Opacity, 0), defaultOpacity)
+}
+
+func (e *Element) fontSize() float64 {
+	if e.FontSize <= 0 {
+		return defaultFontSize
+	}
+	return e.FontSize
+}
+
+// fontFamily returns the font family id, the fractional ids are rounded to the nearest one.
+func (e *Element) fontFamily() int {
+	return int(math.Round(e.FontFamily))
+}
+
+func (e *Element) lineHeight() float64 {
+	if e.LineHeight <= 0 {
+		return defaultLineHeight
+	}
+	return e.LineHeight
+}
+
+// visible returns the elements that are drawn on the canvas.
+func visible(elements []Element) []Element {
+	result := make([]Element, 0, len(elements))
+	for _, e := range elements {
+		if e.IsDeleted || e.opacity() == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package scene
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+func TestRenderSanitizedScene(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements string
+	}{
+		{
+			name:     "shapes",
+			elements: `[{"id":"a","type":"rectangle","x":0,"y":0,"width":100,"height":50,"roundness":{"type":3}}]`,
+		},
+		{
+			name:     "fractional font family",
+			elements: `[{"id":"a","type":"text","x":0,"y":0,"width":100,"height":30,"text":"hi","fontFamily":1.5}]`,
+		},
+		{
+			name:     "fractional roundness type",
+			elements: `[{"id":"a","type":"rectangle","x":0,"y":0,"width":100,"height":50,"roundness":{"type":2.5}}]`,
+		},
+		{
+			name:     "unknown font family",
+			elements: `[{"id":"a","type":"text","x":0,"y":0,"width":100,"height":30,"text":"hi","fontFamily":1e9}]`,
+		},
+	}
+
+	renderer := NewPNGRenderer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The scenes accepted by the sanitizer are exported in every format
+			sanitized, err := Sanitize(tt.elements, Limits{})
+			if err != nil {
+				t.Fatalf("Sanitize() unexpected error: %v", err)
+			}
+			elements, err := ParseElements(sanitized)
+			if err != nil {
+				t.Fatalf("ParseElements() unexpected error: %v", err)
+			}
+
+			var svg bytes.Buffer
+			if err := RenderSVG(&svg, elements, AppState{}, nil); err != nil {
+				t.Fatalf("RenderSVG() unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(svg.String(), "<svg") || !strings.HasSuffix(svg.String(), "</svg>") {
+				t.Errorf("RenderSVG() = %s, want an svg document", svg.String())
+			}
+
+			var img bytes.Buffer
+			if err := renderer.RenderPNG(&img, elements, AppState{ViewBackgroundColor: "#ffffff"}, nil, 1); err != nil {
+				t.Fatalf("RenderPNG() unexpected error: %v", err)
+			}
+			if _, err := png.Decode(&img); err != nil {
+				t.Errorf("RenderPNG() produced an invalid image: %v", err)
+			}
+		})
+	}
+}
+
+func TestElementFontFamily(t *testing.T) {
+	tests := []struct {
+		family float64
+		want   int
+	}{
+		{family: 1, want: 1},
+		{family: 1.5, want: 2},
+		{family: 2.4, want: 2},
+		{family: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		e := Element{FontFamily: tt.family}
+		if got := e.fontFamily(); got != tt.want {
+			t.Errorf("fontFamily() of %v = %d, want %d", tt.family, got, tt.want)
+		}
+	}
+}
+
+func TestFileIDs(t *testing.T) {
+	elements, err := ParseElements(`[
+		{"id":"a","type":"image","fileId":"f1"},
+		{"id":"b","type":"image","fileId":"f1"},
+		{"id":"c","type":"image","fileId":"f2","isDeleted":true},
+		{"id":"d","type":"rectangle","fileId":"f3"},
+		{"id":"e","type":"image","fileId":"f4"}
+	]`)
+	if err != nil {
+		t.Fatalf("ParseElements() unexpected error: %v", err)
+	}
+	if got, want := FileIDs(elements), []string{"f1", "f4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FileIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestNewDocument(t *testing.T) {
+	f := &models.File{ID: "f1", MimeType: "image/png", Data: []byte("png"), Created: 1}
+	document, err := NewDocument("", "", []*models.File{f})
+	if err != nil {
+		t.Fatalf("NewDocument() unexpected error: %v", err)
+	}
+	if string(document.Elements) != "[]" || string(document.AppState) != "{}" ||
+		document.Type != DocumentType || document.Version != DocumentVersion {
+		t.Errorf("NewDocument() = %+v, want an empty %s document", document, DocumentType)
+	}
+	if got := document.Files[f.ID].DataURL; got != "data:image/png;base64,cG5n" {
+		t.Errorf("file data URL = %s, want data:image/png;base64,cG5n", got)
+	}
+
+	if _, err := NewDocument("[", "", nil); err == nil {
+		t.Error("NewDocument() of the invalid elements expected error, got nil")
+	}
+}
@@ -0,0 +1,199 @@
+package scene
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// svgFontFamily returns the CSS font family of the Excalidraw font family.
+func svgFontFamily(family int) (string, bool) {
+	switch family {
+	case 1:
+		return "Virgil, Segoe UI Emoji", true
+	case 2:
+		return "Helvetica, Segoe UI Emoji", true
+	case 3:
+		return "Cascadia, Segoe UI Emoji", true
+	default:
+		return "", false
+	}
+}
+
+// isSVGImageType reports whether the files of the type are embedded into the SVG.
+func isSVGImageType(mimeType string) bool {
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp", "image/svg+xml":
+		return true
+	default:
+		return false
+	}
+}
+
+// RenderSVG renders the elements as an SVG image. Files is a map of the files of the image elements by id.
+func RenderSVG(w io.Writer, elements []Element, appState AppState, files map[string]*models.File) error {
+	elements = visible(elements)
+	c := newCanvas(elements)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(
+		bw,
+		`<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%s" height="%s" viewBox="0 0 %s %s">`,
+		num(c.width), num(c.height), num(c.width), num(c.height),
+	)
+	if bg, ok := parseColor(appState.ViewBackgroundColor); ok || appState.ViewBackgroundColor == "" {
+		if !ok {
+			bg = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+		}
+		fmt.Fprintf(bw, `<rect width="100%%" height="100%%"%s/>`, svgPaint("fill", bg))
+	}
+	for i := range elements {
+		writeSVGElement(bw, c, &elements[i], files)
+	}
+	bw.WriteString(`</svg>`)
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write svg: %w", err)
+	}
+	return nil
+}
+
+func writeSVGElement(w *bufio.Writer, c canvas, e *Element, files map[string]*models.File) {
+	p := c.placement(e)
+	fmt.Fprintf(
+		w,
+		`<g transform="translate(%s %s) rotate(%s %s %s)" opacity="%s">`,
+		num(p.ox), num(p.oy), num(e.Angle*180/math.Pi), num(p.cx), num(p.cy), num(e.opacity()/100),
+	)
+	defer w.WriteString(`</g>`)
+
+	switch e.Type {
+	case "text":
+		writeSVGText(w, e)
+		return
+	case "image":
+		if f := files[e.FileID]; f != nil && isSVGImageType(f.MimeType) {
+			fmt.Fprintf(
+				w,
+				`<image width="%s" height="%s" preserveAspectRatio="none" href="%s"/>`,
+				num(e.Width), num(e.Height), html.EscapeString(DataURL(f)),
+			)
+			return
+		}
+	}
+
+	stroke := svgStroke(e)
+	fill := ` fill="none"`
+	if bg, ok := parseColor(e.BackgroundColor); ok {
+		fill = svgPaint("fill", bg)
+	}
+
+	switch e.Type {
+	case "rectangle", "image", "frame", "magicframe", "embeddable", "iframe":
+		radius := ""
+		if e.Roundness != nil {
+			radius = fmt.Sprintf(` rx="%s"`, num(min(e.Width, e.Height)/4))
+		}
+		fmt.Fprintf(w, `<rect width="%s" height="%s"%s%s%s/>`, num(e.Width), num(e.Height), radius, fill, stroke)
+	case "ellipse":
+		fmt.Fprintf(
+			w,
+			`<ellipse cx="%s" cy="%s" rx="%s" ry="%s"%s%s/>`,
+			num(e.Width/2), num(e.Height/2), num(e.Width/2), num(e.Height/2), fill, stroke,
+		)
+	default:
+		points, closed := outline(e)
+		if len(points) == 0 {
+			return
+		}
+		tag := "polyline"
+		if closed {
+			tag = "polygon"
+		} else {
+			fill = ` fill="none"`
+		}
+		fmt.Fprintf(w, `<%s points="%s"%s%s stroke-linejoin="round" stroke-linecap="round"/>`,
+			tag, svgPoints(points), fill, stroke)
+		for _, head := range arrowheads(e) {
+			fmt.Fprintf(w, `<polyline points="%s" fill="none"%s stroke-linejoin="round" stroke-linecap="round"/>`,
+				svgPoints(head), stroke)
+		}
+	}
+}
+
+func writeSVGText(w *bufio.Writer, e *Element) {
+	family, ok := svgFontFamily(e.fontFamily())
+	if !ok {
+		family = "sans-serif"
+	}
+	x, anchor := 0.0, "start"
+	switch e.TextAlign {
+	case "center":
+		x, anchor = e.Width/2, "middle"
+	case "right":
+		x, anchor = e.Width, "end"
+	}
+
+	fontSize, lineHeight := e.fontSize(), e.fontSize()*e.lineHeight()
+	fmt.Fprintf(
+		w,
+		`<g font-family="%s" font-size="%s" text-anchor="%s"%s style="white-space: pre">`,
+		html.EscapeString(family), num(fontSize), anchor, svgPaint("fill", strokeColor(e)),
+	)
+	for i, line := range strings.Split(e.Text, "\n") {
+		y := float64(i)*lineHeight + baseline(fontSize, lineHeight)
+		fmt.Fprintf(w, `<text x="%s" y="%s">%s</text>`, num(x), num(y), html.EscapeString(line))
+	}
+	w.WriteString(`</g>`)
+}
+
+// baseline returns the offset of the text baseline from the top of the line.
+func baseline(fontSize, lineHeight float64) float64 {
+	return (lineHeight-fontSize)/2 + fontSize*0.8
+}
+
+func svgStroke(e *Element) string {
+	if e.StrokeWidth <= 0 {
+		return ""
+	}
+	stroke := svgPaint("stroke", strokeColor(e)) + fmt.Sprintf(` stroke-width="%s"`, num(e.StrokeWidth))
+	if dash := dashPattern(e); dash != nil {
+		stroke += fmt.Sprintf(` stroke-dasharray="%s %s"`, num(dash[0]), num(dash[1]))
+	}
+	return stroke
+}
+
+// svgPaint returns the paint attribute of the color with its opacity.
+func svgPaint(attribute string, c color.NRGBA) string {
+	if c.A == 0 {
+		return fmt.Sprintf(` %s="none"`, attribute)
+	}
+	paint := fmt.Sprintf(` %s="#%02x%02x%02x"`, attribute, c.R, c.G, c.B)
+	if c.A < 0xff {
+		paint += fmt.Sprintf(` %s-opacity="%s"`, attribute, num(float64(c.A)/0xff))
+	}
+	return paint
+}
+
+func svgPoints(points []point) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = num(p[0]) + "," + num(p[1])
+	}
+	return strings.Join(parts, " ")
+}
+
+// num formats the number with at most two decimal places.
+func num(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "0"
+	}
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,275 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+const (
+	dataExtension = ".bin"
+	metaExtension = ".json"
+)
+
+// meta is the file metadata stored next to the file content.
+type meta struct {
+	ID       string `json:"id"`
+	BoardID  string `json:"board_id"`
+	MimeType string `json:"mime_type"`
+	Created  int64  `json:"created"`
+}
+
+// Storage stores the files in a directory per board. The names of the directories and files
+// are hashes of the ids, so the ids can't escape the root directory.
+type Storage struct {
+	// root is the directory with the files
+	root string
+
+	// boardSizes is a map of the size of the files by board directory
+	boardSizes map[string]int64
+
+	// totalSize is the size of all the files
+	totalSize int64
+
+	quota  file.Quota
+	logger *zap.Logger
+
+	mtx *sync.Mutex
+}
+
+func NewStorage(root string, quota file.Quota, logger *zap.Logger) (*Storage, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+	s := &Storage{
+		root:       root,
+		boardSizes: make(map[string]int64),
+		quota:      quota.WithDefaults(),
+		logger:     logger,
+		mtx:        &sync.Mutex{},
+	}
+
+	// Count the size of the files stored before the restart
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, dataExtension) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		s.boardSizes[filepath.Base(filepath.Dir(path))] += info.Size()
+		s.totalSize += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read files directory: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Storage) Set(value *models.File) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	boardDir, path := s.paths(value.BoardID, value.ID)
+	if _, err := os.Stat(path + dataExtension); err == nil {
+		touch(path + dataExtension)
+		return nil
+	}
+
+	size := int64(len(value.Data))
+	if err := s.quota.Check(size, s.boardSizes[filepath.Base(boardDir)], s.totalSize); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+
+	if err := os.MkdirAll(boardDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create board directory: %w", err)
+	}
+	metaData, err := json.Marshal(meta{
+		ID:       value.ID,
+		BoardID:  value.BoardID,
+		MimeType: value.MimeType,
+		Created:  value.Created,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal file metadata: %w", err)
+	}
+	// The metadata is written first, the file is visible once its content is in place
+	if err := writeFile(path+metaExtension, metaData); err != nil {
+		return err
+	}
+	if err := writeFile(path+dataExtension, value.Data); err != nil {
+		return err
+	}
+
+	s.boardSizes[filepath.Base(boardDir)] += size
+	s.totalSize += size
+	s.logger.Info("file added to storage", zap.String("boardID", value.BoardID), zap.String("fileID", value.ID))
+	return nil
+}
+
+func (s *Storage) Check(values []*models.File) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	boardSizes := make(map[string]int64)
+	totalSize := s.totalSize
+	for _, value := range values {
+		boardDir, path := s.paths(value.BoardID, value.ID)
+		if _, err := os.Stat(path + dataExtension); err == nil {
+			continue
+		}
+		size := int64(len(value.Data))
+		board := filepath.Base(boardDir)
+		if err := s.quota.Check(size, s.boardSizes[board]+boardSizes[board], totalSize); err != nil {
+			return fmt.Errorf("failed to check file: %w", err)
+		}
+		boardSizes[board] += size
+		totalSize += size
+	}
+	return nil
+}
+
+func (s *Storage) Get(boardID, fileID string) (*models.File, error) {
+	_, path := s.paths(boardID, fileID)
+	data, err := os.ReadFile(path + dataExtension)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.logger.Info("file not found in storage", zap.String("boardID", boardID), zap.String("fileID", fileID))
+		return nil, file.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	metaData, err := os.ReadFile(path + metaExtension)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file metadata: %w", err)
+	}
+	var m meta
+	if err := json.Unmarshal(metaData, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file metadata: %w", err)
+	}
+	touch(path + dataExtension)
+
+	return &models.File{
+		ID:       m.ID,
+		BoardID:  m.BoardID,
+		MimeType: m.MimeType,
+		Data:     data,
+		Created:  m.Created,
+	}, nil
+}
+
+func (s *Storage) Retain(boardID string, keep map[string]bool) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	boardDir, _ := s.paths(boardID, "")
+	entries, err := os.ReadDir(boardDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read board directory: %w", err)
+	}
+
+	// The names of the files are the hashes of the ids
+	kept := make(map[string]bool, len(keep))
+	for fileID := range keep {
+		kept[hash(fileID)] = true
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), dataExtension)
+		if !ok || kept[name] {
+			continue
+		}
+		if err := s.remove(filepath.Join(boardDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) Expire(before time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, dataExtension) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		return s.remove(strings.TrimSuffix(path, dataExtension))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire files: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the file and releases its size from the quota, the mutex must be held.
+// The content is removed first, so the file is not visible without its metadata.
+func (s *Storage) remove(path string) error {
+	info, err := os.Stat(path + dataExtension)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := os.Remove(path + dataExtension); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	_ = os.Remove(path + metaExtension)
+
+	boardDir := filepath.Base(filepath.Dir(path))
+	s.boardSizes[boardDir] -= info.Size()
+	s.totalSize -= info.Size()
+	if s.boardSizes[boardDir] <= 0 {
+		delete(s.boardSizes, boardDir)
+		_ = os.Remove(filepath.Dir(path))
+	}
+	s.logger.Info("file removed from storage", zap.String("file", filepath.Base(path)))
+	return nil
+}
+
+// touch updates the modification time of the file, it is the time the file was last stored or read.
+func touch(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// paths returns the directory of the board and the path of the file without the extension.
+func (s *Storage) paths(boardID, fileID string) (string, string) {
+	boardDir := filepath.Join(s.root, hash(boardID))
+	return boardDir, filepath.Join(boardDir, hash(fileID))
+}
+
+func hash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFile writes the data to a temporary file and renames it, so the readers never see a partial file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+const (
+	testBoardID  = "board-1"
+	otherBoardID = "board-2"
+)
+
+func newFile(boardID, fileID string, size int) *models.File {
+	return &models.File{ID: fileID, BoardID: boardID, MimeType: "image/png", Data: bytes.Repeat([]byte{1}, size)}
+}
+
+func newTestStorage(t *testing.T, root string, quota file.Quota) *Storage {
+	t.Helper()
+	s, err := NewStorage(root, quota, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStorage() unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestStorageSetGet(t *testing.T) {
+	s := newTestStorage(t, t.TempDir(), file.Quota{})
+	want := newFile(testBoardID, "../../escape", 3)
+	want.Created = 1700000000000
+	if err := s.Set(want); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	got, err := s.Get(testBoardID, want.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	if got.ID != want.ID || got.BoardID != want.BoardID || got.MimeType != want.MimeType ||
+		got.Created != want.Created || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+	if _, err := s.Get(otherBoardID, want.ID); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Get() of the other board error = %v, want %v", err, file.ErrFileNotFound)
+	}
+}
+
+func TestStorageQuotaAfterRestart(t *testing.T) {
+	root := t.TempDir()
+	quota := file.Quota{MaxFileSize: 4, MaxBoardSize: 8, MaxTotalSize: 12}
+	s := newTestStorage(t, root, quota)
+	for _, f := range []*models.File{newFile(testBoardID, "a", 4), newFile(testBoardID, "b", 4)} {
+		if err := s.Set(f); err != nil {
+			t.Fatalf("Set() unexpected error: %v", err)
+		}
+	}
+	if err := s.Set(newFile(testBoardID, "c", 5)); !errors.Is(err, file.ErrFileTooLarge) {
+		t.Errorf("Set() error = %v, want %v", err, file.ErrFileTooLarge)
+	}
+
+	// The size of the stored files is counted again when the storage is opened
+	s = newTestStorage(t, root, quota)
+	if err := s.Set(newFile(testBoardID, "c", 1)); !errors.Is(err, file.ErrQuotaExceeded) {
+		t.Errorf("Set() after the restart error = %v, want %v", err, file.ErrQuotaExceeded)
+	}
+	files := []*models.File{newFile(otherBoardID, "a", 4), newFile(otherBoardID, "b", 1)}
+	if err := s.Check(files); !errors.Is(err, file.ErrQuotaExceeded) {
+		t.Errorf("Check() error = %v, want %v", err, file.ErrQuotaExceeded)
+	}
+
+	// The removed files release their quota
+	if err := s.Retain(testBoardID, map[string]bool{"a": true}); err != nil {
+		t.Fatalf("Retain() unexpected error: %v", err)
+	}
+	if _, err := s.Get(testBoardID, "b"); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Get() of the removed file error = %v, want %v", err, file.ErrFileNotFound)
+	}
+	if err := s.Set(newFile(testBoardID, "c", 4)); err != nil {
+		t.Errorf("Set() unexpected error: %v", err)
+	}
+}
+
+func TestStorageExpire(t *testing.T) {
+	s := newTestStorage(t, t.TempDir(), file.Quota{})
+	_ = s.Set(newFile(testBoardID, "a", 1))
+
+	if err := s.Expire(time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	if _, err := s.Get(testBoardID, "a"); err != nil {
+		t.Errorf("Get() of the recent file unexpected error: %v", err)
+	}
+
+	if err := s.Expire(time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	if _, err := s.Get(testBoardID, "a"); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Get() of the expired file error = %v, want %v", err, file.ErrFileNotFound)
+	}
+}
@@ -0,0 +1,140 @@
+package inmemory
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+// entry is a stored file with the time it was last stored or read.
+type entry struct {
+	file     *models.File
+	accessed time.Time
+}
+
+type Storage struct {
+	// data is a map of the files by board id and file id
+	data map[string]map[string]*entry
+
+	// boardSizes is a map of the size of the files by board id
+	boardSizes map[string]int64
+
+	// totalSize is the size of all the files
+	totalSize int64
+
+	quota  file.Quota
+	logger *zap.Logger
+
+	mtx *sync.Mutex
+}
+
+func NewStorage(quota file.Quota, logger *zap.Logger) *Storage {
+	return &Storage{
+		data:       make(map[string]map[string]*entry),
+		boardSizes: make(map[string]int64),
+		quota:      quota.WithDefaults(),
+		logger:     logger,
+		mtx:        &sync.Mutex{},
+	}
+}
+
+func (s *Storage) Set(value *models.File) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	files, ok := s.data[value.BoardID]
+	if !ok {
+		files = make(map[string]*entry)
+		s.data[value.BoardID] = files
+	}
+	if e, ok := files[value.ID]; ok {
+		e.accessed = time.Now()
+		return nil
+	}
+
+	size := int64(len(value.Data))
+	if err := s.quota.Check(size, s.boardSizes[value.BoardID], s.totalSize); err != nil {
+		if len(files) == 0 {
+			delete(s.data, value.BoardID)
+		}
+		return err
+	}
+	files[value.ID] = &entry{file: value, accessed: time.Now()}
+	s.boardSizes[value.BoardID] += size
+	s.totalSize += size
+	s.logger.Info("file added to storage", zap.String("boardID", value.BoardID), zap.String("fileID", value.ID))
+	return nil
+}
+
+func (s *Storage) Check(values []*models.File) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	boardSizes := make(map[string]int64)
+	totalSize := s.totalSize
+	for _, value := range values {
+		if _, ok := s.data[value.BoardID][value.ID]; ok {
+			continue
+		}
+		size := int64(len(value.Data))
+		boardSize := s.boardSizes[value.BoardID] + boardSizes[value.BoardID]
+		if err := s.quota.Check(size, boardSize, totalSize); err != nil {
+			return err
+		}
+		boardSizes[value.BoardID] += size
+		totalSize += size
+	}
+	return nil
+}
+
+func (s *Storage) Get(boardID, fileID string) (*models.File, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.data[boardID][fileID]
+	if !ok {
+		s.logger.Info("file not found in storage", zap.String("boardID", boardID), zap.String("fileID", fileID))
+		return nil, file.ErrFileNotFound
+	}
+	e.accessed = time.Now()
+	return e.file, nil
+}
+
+func (s *Storage) Retain(boardID string, keep map[string]bool) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for fileID := range s.data[boardID] {
+		if !keep[fileID] {
+			s.remove(boardID, fileID)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) Expire(before time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for boardID, files := range s.data {
+		for fileID, e := range files {
+			if e.accessed.Before(before) {
+				s.remove(boardID, fileID)
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes the file and releases its size from the quota, the mutex must be held.
+func (s *Storage) remove(boardID, fileID string) {
+	files := s.data[boardID]
+	size := int64(len(files[fileID].file.Data))
+	delete(files, fileID)
+	s.boardSizes[boardID] -= size
+	s.totalSize -= size
+	if len(files) == 0 {
+		delete(s.data, boardID)
+		delete(s.boardSizes, boardID)
+	}
+	s.logger.Info("file removed from storage", zap.String("boardID", boardID), zap.String("fileID", fileID))
+}
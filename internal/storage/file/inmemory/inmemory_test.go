@@ -0,0 +1,85 @@
+package inmemory
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+	"github.com/Icerzack/excaliroom/internal/storage/file"
+)
+
+const (
+	testBoardID  = "board-1"
+	otherBoardID = "board-2"
+	thirdBoardID = "board-3"
+)
+
+func newFile(boardID, fileID string, size int) *models.File {
+	return &models.File{ID: fileID, BoardID: boardID, MimeType: "image/png", Data: make([]byte, size)}
+}
+
+func TestStorageQuota(t *testing.T) {
+	s := NewStorage(file.Quota{MaxFileSize: 4, MaxBoardSize: 8, MaxTotalSize: 12}, zap.NewNop())
+	steps := []struct {
+		name string
+		file *models.File
+		want error
+	}{
+		{name: "first file", file: newFile(testBoardID, "a", 4)},
+		{name: "same file again", file: newFile(testBoardID, "a", 4)},
+		{name: "file too large", file: newFile(testBoardID, "b", 5), want: file.ErrFileTooLarge},
+		{name: "board full", file: newFile(testBoardID, "b", 4)},
+		{name: "over the board quota", file: newFile(testBoardID, "c", 1), want: file.ErrQuotaExceeded},
+		{name: "other board", file: newFile(otherBoardID, "a", 4)},
+		{name: "over the total quota", file: newFile(thirdBoardID, "a", 1), want: file.ErrQuotaExceeded},
+	}
+	for _, step := range steps {
+		if err := s.Set(step.file); !errors.Is(err, step.want) {
+			t.Fatalf("%s: Set() error = %v, want %v", step.name, err, step.want)
+		}
+	}
+
+	// The files checked together don't fit, so nothing is stored
+	if err := s.Check([]*models.File{newFile(thirdBoardID, "a", 1)}); !errors.Is(err, file.ErrQuotaExceeded) {
+		t.Errorf("Check() error = %v, want %v", err, file.ErrQuotaExceeded)
+	}
+
+	// The removed files release their quota
+	if err := s.Retain(testBoardID, map[string]bool{"a": true}); err != nil {
+		t.Fatalf("Retain() unexpected error: %v", err)
+	}
+	if _, err := s.Get(testBoardID, "b"); !errors.Is(err, file.ErrFileNotFound) {
+		t.Errorf("Get() of the removed file error = %v, want %v", err, file.ErrFileNotFound)
+	}
+	if err := s.Check([]*models.File{newFile(testBoardID, "a", 4), newFile(thirdBoardID, "a", 4)}); err != nil {
+		t.Errorf("Check() unexpected error: %v", err)
+	}
+	if err := s.Set(newFile(thirdBoardID, "a", 4)); err != nil {
+		t.Errorf("Set() unexpected error: %v", err)
+	}
+}
+
+func TestStorageExpire(t *testing.T) {
+	s := NewStorage(file.Quota{}, zap.NewNop())
+	_ = s.Set(newFile(testBoardID, "a", 1))
+	_ = s.Set(newFile(otherBoardID, "a", 1))
+
+	if err := s.Expire(time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	if _, err := s.Get(testBoardID, "a"); err != nil {
+		t.Errorf("Get() of the recent file unexpected error: %v", err)
+	}
+
+	if err := s.Expire(time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Expire() unexpected error: %v", err)
+	}
+	for _, boardID := range []string{testBoardID, otherBoardID} {
+		if _, err := s.Get(boardID, "a"); !errors.Is(err, file.ErrFileNotFound) {
+			t.Errorf("Get() of the expired file error = %v, want %v", err, file.ErrFileNotFound)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	InMemoryStorageType = "in-memory"
+	DiskStorageType     = "disk"
+)
+
+var (
+	ErrFileNotFound  = errors.New("file not found")
+	ErrFileTooLarge  = errors.New("file too large")
+	ErrQuotaExceeded = errors.New("files quota exceeded")
+)
+
+const (
+	defaultMaxFileSize  = 4 << 20
+	defaultMaxBoardSize = 64 << 20
+	defaultMaxTotalSize = 1 << 30
+)
+
+// Quota limits the size of the stored files in bytes.
+type Quota struct {
+	// MaxFileSize is the maximum size of a single file
+	MaxFileSize int64
+
+	// MaxBoardSize is the maximum size of all the files of a board
+	MaxBoardSize int64
+
+	// MaxTotalSize is the maximum size of all the stored files
+	MaxTotalSize int64
+}
+
+// WithDefaults returns a copy of the quota with zero values replaced by defaults.
+func (q Quota) WithDefaults() Quota {
+	if q.MaxFileSize <= 0 {
+		q.MaxFileSize = defaultMaxFileSize
+	}
+	if q.MaxBoardSize <= 0 {
+		q.MaxBoardSize = defaultMaxBoardSize
+	}
+	if q.MaxTotalSize <= 0 {
+		q.MaxTotalSize = defaultMaxTotalSize
+	}
+	return q
+}
+
+// Check returns the error if a file of the given size doesn't fit into the quota.
+func (q Quota) Check(fileSize, boardSize, totalSize int64) error {
+	if fileSize > q.MaxFileSize {
+		return ErrFileTooLarge
+	}
+	if boardSize+fileSize > q.MaxBoardSize || totalSize+fileSize > q.MaxTotalSize {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Storage stores the files of the boards. The files are immutable, storing a file with an existing id is a no-op.
+type Storage interface {
+	Set(value *models.File) error
+	Get(boardID, fileID string) (*models.File, error)
+
+	// Check returns the error if the files don't fit into the quota together, the stored files are skipped.
+	// Nothing is stored.
+	Check(values []*models.File) error
+
+	// Retain removes the files of the board that are not in keep.
+	Retain(boardID string, keep map[string]bool) error
+
+	// Expire removes the files that were neither stored nor read since the time.
+	Expire(before time.Time) error
+}
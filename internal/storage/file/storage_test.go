@@ -0,0 +1,38 @@
+package file
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	quota := Quota{MaxFileSize: 4, MaxBoardSize: 8, MaxTotalSize: 12}
+	tests := []struct {
+		name      string
+		fileSize  int64
+		boardSize int64
+		totalSize int64
+		want      error
+	}{
+		{name: "fits", fileSize: 4, boardSize: 4, totalSize: 8},
+		{name: "file too large", fileSize: 5, want: ErrFileTooLarge},
+		{name: "board full", fileSize: 1, boardSize: 8, totalSize: 8, want: ErrQuotaExceeded},
+		{name: "storage full", fileSize: 1, totalSize: 12, want: ErrQuotaExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := quota.Check(tt.fileSize, tt.boardSize, tt.totalSize); !errors.Is(err, tt.want) {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaWithDefaults(t *testing.T) {
+	got := Quota{MaxFileSize: 1}.WithDefaults()
+	want := Quota{MaxFileSize: 1, MaxBoardSize: defaultMaxBoardSize, MaxTotalSize: defaultMaxTotalSize}
+	if got != want {
+		t.Errorf("WithDefaults() = %+v, want %+v", got, want)
+	}
+}
@@ -52,3 +52,13 @@ func (s *Storage) Delete(key string) error {
 	s.logger.Info("room deleted from storage", zap.String("key", key))
 	return nil
 }
+
+func (s *Storage) GetAll() ([]*models.Room, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	rooms := make([]*models.Room, 0, len(s.data))
+	for _, v := range s.data {
+		rooms = append(rooms, v)
+	}
+	return rooms, nil
+}
@@ -12,4 +12,5 @@ type Storage interface {
 	Set(key string, value *models.Room) error
 	Get(key string) (*models.Room, error)
 	Delete(key string) error
+	GetAll() ([]*models.Room, error)
 }
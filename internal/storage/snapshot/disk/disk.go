@@ -0,0 +1,178 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	snapshotExtension = ".json"
+
+	// evictedExtension marks the snapshots of the evicted rooms, they are not loaded on start
+	evictedExtension = ".evicted.json"
+)
+
+// Storage stores the snapshots in a directory, a file per board. The names of the files
+// are hashes of the board ids, so the ids can't escape the root directory.
+type Storage struct {
+	// root is the directory with the snapshots
+	root string
+
+	logger *zap.Logger
+
+	mtx *sync.Mutex
+}
+
+func NewStorage(root string, logger *zap.Logger) (*Storage, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+	return &Storage{
+		root:   root,
+		logger: logger,
+		mtx:    &sync.Mutex{},
+	}, nil
+}
+
+func (s *Storage) Save(value *models.Snapshot) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	// The snapshot is renamed into place, so a crash never leaves a partial file behind
+	path := s.path(value.BoardID, value.Evicted)
+	tmp, err := os.CreateTemp(s.root, ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	s.logger.Info("snapshot added to storage", zap.String("boardID", value.BoardID))
+	return nil
+}
+
+func (s *Storage) Load(restore func(value *models.Snapshot) bool) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+
+	loaded := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, snapshotExtension) || strings.HasSuffix(name, evictedExtension) {
+			continue
+		}
+		path := filepath.Join(s.root, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot: %w", err)
+		}
+
+		// A corrupted snapshot is skipped, it must not prevent the server from starting
+		var value models.Snapshot
+		if err := json.Unmarshal(data, &value); err != nil || value.BoardID == "" {
+			s.logger.Warn("invalid snapshot skipped", zap.String("file", name))
+			_ = os.Remove(path)
+			continue
+		}
+
+		// The snapshot is removed only after the room is restored, so a crash doesn't lose it
+		if !restore(&value) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+		loaded++
+	}
+
+	s.logger.Info("snapshots loaded from storage", zap.Int("count", loaded))
+	return nil
+}
+
+func (s *Storage) Take(boardID string) (*models.Snapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	value, path, err := s.read(boardID)
+	if path == "" || err != nil {
+		return nil, err
+	}
+	// The invalid snapshot is removed too, it would be skipped every time
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	s.logger.Info("snapshot taken from storage", zap.String("boardID", boardID))
+	return value, nil
+}
+
+func (s *Storage) Peek(boardID string) (*models.Snapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	value, _, err := s.read(boardID)
+	return value, err
+}
+
+// read returns the snapshot of the board and the path of its file, the snapshot saved on shutdown
+// takes precedence over the evicted one. The snapshot is nil if it is invalid, the path is empty
+// if there is none. The mutex must be held.
+func (s *Storage) read(boardID string) (*models.Snapshot, string, error) {
+	path := s.path(boardID, false)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		path = s.path(boardID, true)
+		data, err = os.ReadFile(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var value models.Snapshot
+	if err := json.Unmarshal(data, &value); err != nil || value.BoardID != boardID {
+		s.logger.Warn("invalid snapshot skipped", zap.String("boardID", boardID))
+		return nil, path, nil
+	}
+	return &value, path, nil
+}
+
+// path returns the path of the snapshot file of the board.
+func (s *Storage) path(boardID string, evicted bool) string {
+	sum := sha256.Sum256([]byte(boardID))
+	if evicted {
+		return filepath.Join(s.root, hex.EncodeToString(sum[:])+evictedExtension)
+	}
+	return filepath.Join(s.root, hex.EncodeToString(sum[:])+snapshotExtension)
+}
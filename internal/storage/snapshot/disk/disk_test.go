@@ -0,0 +1,113 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const testBoardID = "board-1"
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStorage() unexpected error: %v", err)
+	}
+	return s
+}
+
+// loadAll loads the snapshots with the restore result and returns the ids of the loaded boards.
+func loadAll(t *testing.T, s *Storage, restore bool) []string {
+	t.Helper()
+	var boardIDs []string
+	err := s.Load(func(value *models.Snapshot) bool {
+		boardIDs = append(boardIDs, value.BoardID)
+		return restore
+	})
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	return boardIDs
+}
+
+func TestStorageLoadsOnce(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.Save(&models.Snapshot{BoardID: testBoardID, Elements: `[]`}); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	if got := loadAll(t, s, true); len(got) != 1 || got[0] != testBoardID {
+		t.Fatalf("loaded %v, want %s", got, testBoardID)
+	}
+	// The restored snapshot is removed
+	if got := loadAll(t, s, true); len(got) != 0 {
+		t.Errorf("loaded %v again, want none", got)
+	}
+}
+
+func TestStorageKeepsDeclinedSnapshots(t *testing.T) {
+	s := newTestStorage(t)
+	_ = s.Save(&models.Snapshot{BoardID: testBoardID, Elements: `[]`})
+
+	if got := loadAll(t, s, false); len(got) != 1 {
+		t.Fatalf("loaded %v, want %s", got, testBoardID)
+	}
+	value, err := s.Take(testBoardID)
+	if err != nil || value == nil || value.Elements != `[]` {
+		t.Fatalf("Take() = %v, %v, want the declined snapshot", value, err)
+	}
+	// The taken snapshot is removed
+	if value, _ = s.Take(testBoardID); value != nil {
+		t.Errorf("Take() = %v again, want nil", value)
+	}
+}
+
+func TestStorageSkipsEvictedSnapshots(t *testing.T) {
+	s := newTestStorage(t)
+	_ = s.Save(&models.Snapshot{BoardID: testBoardID, Elements: `[]`, Evicted: true})
+
+	if got := loadAll(t, s, true); len(got) != 0 {
+		t.Fatalf("loaded %v, want none", got)
+	}
+	if value, err := s.Take(testBoardID); err != nil || value == nil || !value.Evicted {
+		t.Errorf("Take() = %v, %v, want the evicted snapshot", value, err)
+	}
+}
+
+func TestStorageSkipsCorruptedSnapshots(t *testing.T) {
+	s := newTestStorage(t)
+	corrupted := filepath.Join(s.root, "corrupted"+snapshotExtension)
+	if err := os.WriteFile(corrupted, []byte("{"), 0o600); err != nil {
+		t.Fatalf("WriteFile() unexpected error: %v", err)
+	}
+
+	if got := loadAll(t, s, true); len(got) != 0 {
+		t.Errorf("loaded %v, want none", got)
+	}
+	if _, err := os.Stat(corrupted); !os.IsNotExist(err) {
+		t.Errorf("corrupted snapshot not removed: %v", err)
+	}
+}
+
+func TestStoragePeek(t *testing.T) {
+	s := newTestStorage(t)
+	if value, err := s.Peek(testBoardID); err != nil || value != nil {
+		t.Fatalf("Peek() = %v, %v, want nil", value, err)
+	}
+	_ = s.Save(&models.Snapshot{BoardID: testBoardID, Elements: `[]`, Evicted: true})
+
+	// The peeked snapshot stays for Take
+	for i := 0; i < 2; i++ {
+		if value, err := s.Peek(testBoardID); err != nil || value == nil || value.Elements != `[]` {
+			t.Fatalf("Peek() = %v, %v, want the snapshot", value, err)
+		}
+	}
+	if value, _ := s.Take(testBoardID); value == nil {
+		t.Error("Take() = nil after Peek(), want the snapshot")
+	}
+}
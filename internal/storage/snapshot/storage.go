@@ -0,0 +1,25 @@
+package snapshot
+
+import (
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	DiskStorageType = "disk"
+)
+
+// Storage keeps the room snapshots between the restarts.
+type Storage interface {
+	// Save stores the snapshot, replacing the previous snapshot of the board
+	Save(value *models.Snapshot) error
+
+	// Load passes the snapshots saved on shutdown to restore one by one and removes the ones it restored,
+	// so a scene is restored only once. The evicted snapshots and the ones restore declined stay for Take.
+	Load(restore func(value *models.Snapshot) bool) error
+
+	// Take returns the snapshot of the board and removes it, nil if there is none
+	Take(boardID string) (*models.Snapshot, error)
+
+	// Peek returns the snapshot of the board without removing it, nil if there is none
+	Peek(boardID string) (*models.Snapshot, error)
+}
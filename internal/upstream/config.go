@@ -0,0 +1,74 @@
+package upstream
+
+import (
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTimeout          = 5
+	defaultDeadline         = 10
+	defaultMaxIdleConns     = 100
+	defaultMaxRetries       = 2
+	defaultRetryBackoff     = 100
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30
+)
+
+type Config struct {
+	// Timeout is the time allowed for a request attempt in seconds
+	Timeout int64
+
+	// Deadline is the time allowed for a request including the retries in seconds
+	Deadline int64
+
+	// MaxIdleConns is the number of the idle connections kept per host
+	MaxIdleConns int
+
+	// MaxRetries is the number of the retries after the first attempt, a negative value disables them
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry in milliseconds, it doubles with every retry
+	RetryBackoff int64
+
+	// BreakerThreshold is the number of the consecutive failed requests that open the circuit,
+	// a negative value disables the circuit breaker
+	BreakerThreshold int
+
+	// BreakerCooldown is the time the circuit stays open before a trial request in seconds
+	BreakerCooldown int64
+
+	Logger *zap.Logger
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults.
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Deadline <= 0 {
+		c.Deadline = defaultDeadline
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultRetryBackoff
+	}
+	if c.BreakerThreshold < 0 {
+		c.BreakerThreshold = 0
+	} else if c.BreakerThreshold == 0 {
+		c.BreakerThreshold = defaultBreakerThreshold
+	}
+	if c.BreakerCooldown <= 0 {
+		c.BreakerCooldown = defaultBreakerCooldown
+	}
+	if c.Logger == nil {
+		c.Logger = zap.NewNop()
+	}
+	return c
+}
@@ -0,0 +1,268 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnavailable is returned when the upstream can't be reached or keeps failing
+	ErrUnavailable = errors.New("upstream unavailable")
+
+	// ErrCircuitOpen is returned without a request while the circuit breaker is open
+	ErrCircuitOpen = fmt.Errorf("circuit breaker is open: %w", ErrUnavailable)
+)
+
+// maxDrainSize is the maximum size of the discarded response body read to reuse the connection.
+const maxDrainSize = 4 << 10
+
+// The timeouts of the transport, the same as of http.DefaultTransport.
+const (
+	dialTimeout         = 30 * time.Second
+	keepAlive           = 30 * time.Second
+	idleConnTimeout     = 90 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// Client sends the GET requests to the upstream services, e.g. the validation URLs. The requests
+// share the pool of the connections and are retried with the jittered exponential backoff.
+// The circuit breaker of the host rejects the requests at once while the host is down.
+type Client struct {
+	client     *http.Client
+	deadline   time.Duration
+	maxRetries int
+	backoff    time.Duration
+
+	// breakers is a map of the circuit breakers by host, so a failing host doesn't block the others
+	breakers map[string]*breaker
+
+	// breakersMtx guards breakers
+	breakersMtx *sync.Mutex
+
+	// threshold and cooldown configure the circuit breakers
+	threshold int
+	cooldown  time.Duration
+
+	logger *zap.Logger
+}
+
+func NewClient(config *Config) *Client {
+	cfg := config.withDefaults()
+
+	return &Client{
+		client: &http.Client{
+			Transport: newTransport(cfg.MaxIdleConns),
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		},
+		deadline:    time.Duration(cfg.Deadline) * time.Second,
+		maxRetries:  cfg.MaxRetries,
+		backoff:     time.Duration(cfg.RetryBackoff) * time.Millisecond,
+		breakers:    make(map[string]*breaker),
+		breakersMtx: &sync.Mutex{},
+		threshold:   cfg.BreakerThreshold,
+		cooldown:    time.Duration(cfg.BreakerCooldown) * time.Second,
+		logger:      cfg.Logger,
+	}
+}
+
+// newTransport returns the transport with the settings of http.DefaultTransport keeping the idle connections
+// per host.
+func newTransport(maxIdleConns int) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// Deadline returns the time allowed for a request including the retries.
+func (c *Client) Deadline() time.Duration {
+	return c.deadline
+}
+
+// Get sends the GET request with the headers. The request and its retries are limited by the deadline
+// and by the context. The network errors, the server errors and 429 Too Many Requests are retried;
+// if they persist, the error wraps ErrUnavailable. Any other response is returned to the caller,
+// who must close its body.
+func (c *Client) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	// The invalid request is not retried and says nothing about the upstream
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	b := c.breaker(req.URL.Host)
+	if !b.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.deadline)
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(req.Clone(ctx))
+		if err == nil {
+			b.success()
+			// The deadline covers the reading of the body too
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if ctxErr := ctx.Err(); attempt >= c.maxRetries || ctxErr != nil {
+			cancel()
+			// The caller gave up, it says nothing about the upstream
+			if errors.Is(ctxErr, context.Canceled) {
+				b.release()
+				return nil, fmt.Errorf("request canceled after %d attempts: %w", attempt+1, err)
+			}
+			if b.failure() {
+				c.logger.Warn("Upstream circuit opened", zap.String("host", req.URL.Host), zap.Error(err))
+			}
+			return nil, fmt.Errorf("%w after %d attempts: %w", ErrUnavailable, attempt+1, err)
+		}
+
+		// The jitter spreads the retries of the requests failed at the same time
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+}
+
+// breaker returns the circuit breaker of the host.
+func (c *Client) breaker(host string) *breaker {
+	c.breakersMtx.Lock()
+	defer c.breakersMtx.Unlock()
+	b, ok := c.breakers[host]
+	if !ok {
+		b = newBreaker(c.threshold, c.cooldown)
+		c.breakers[host] = b
+	}
+	return b
+}
+
+// do sends a single request attempt and returns an error if it can be retried.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		_, _ = io.CopyN(io.Discard, resp.Body, maxDrainSize)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// cancelBody releases the context of the request when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// breaker opens after threshold consecutive failures and rejects the requests for the cooldown.
+// Then a single trial request is allowed: its success closes the circuit, its failure opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	// failures is the number of the consecutive failures
+	failures int
+
+	// openedAt is the time the circuit was opened, zero if it is closed
+	openedAt time.Time
+
+	// probing is true while the trial request is in flight
+	probing bool
+
+	mtx *sync.Mutex
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		mtx:       &sync.Mutex{},
+	}
+}
+
+// allow reports whether a request can be sent.
+func (b *breaker) allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *breaker) success() {
+	// Close the circuit
+	if b.threshold == 0 {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+}
+
+// release ends the trial request without a result, so the next request is the trial one.
+func (b *breaker) release() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.probing = false
+}
+
+// failure records the failed request and reports whether it opened the circuit.
+func (b *breaker) failure() bool {
+	if b.threshold == 0 {
+		return false
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.failures++
+	wasOpen := !b.openedAt.IsZero()
+	if b.probing || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+	return !wasOpen && !b.openedAt.IsZero()
+}
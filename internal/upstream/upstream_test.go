@@ -0,0 +1,270 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The calls of the breaker in the steps of the breaker tests.
+const (
+	callAllow   = "allow"
+	callFailure = "failure"
+	callSuccess = "success"
+	callRelease = "release"
+)
+
+// breakerStep is a call of the breaker and its expected result.
+type breakerStep struct {
+	// call is callAllow, callFailure, callSuccess or callRelease
+	call string
+
+	// want is the expected result of allow and failure
+	want bool
+}
+
+// breakerTest is a sequence of the calls of the breaker.
+type breakerTest struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	steps     []breakerStep
+}
+
+// runBreakerTests runs the steps of every test on a new breaker.
+func runBreakerTests(t *testing.T, tests []breakerTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(tt.threshold, tt.cooldown)
+			for i, step := range tt.steps {
+				var got bool
+				switch step.call {
+				case callAllow:
+					got = b.allow()
+				case callFailure:
+					got = b.failure()
+				case callSuccess:
+					b.success()
+				case callRelease:
+					b.release()
+				default:
+					t.Fatalf("step %d: unknown call %q", i, step.call)
+				}
+				if got != step.want {
+					t.Fatalf("step %d: %s() = %v, want %v", i, step.call, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	runBreakerTests(t, []breakerTest{
+		{
+			name:      "disabled",
+			threshold: 0,
+			cooldown:  time.Hour,
+			steps: []breakerStep{
+				{callFailure, false},
+				{callFailure, false},
+				{callAllow, true},
+			},
+		},
+		{
+			name:      "closed below the threshold",
+			threshold: 3,
+			cooldown:  time.Hour,
+			steps: []breakerStep{
+				{callFailure, false},
+				{callFailure, false},
+				{callAllow, true},
+			},
+		},
+		{
+			name:      "success resets the failures",
+			threshold: 2,
+			cooldown:  time.Hour,
+			steps: []breakerStep{
+				{callFailure, false},
+				{callSuccess, false},
+				{callFailure, false},
+				{callAllow, true},
+			},
+		},
+		{
+			name:      "opens at the threshold",
+			threshold: 2,
+			cooldown:  time.Hour,
+			steps: []breakerStep{
+				{callFailure, false},
+				{callFailure, true},
+				{callAllow, false},
+				{callFailure, false},
+				{callAllow, false},
+			},
+		},
+	})
+}
+
+func TestBreakerTrial(t *testing.T) {
+	runBreakerTests(t, []breakerTest{
+		{
+			name:      "single trial request after the cooldown",
+			threshold: 1,
+			cooldown:  0,
+			steps: []breakerStep{
+				{callFailure, true},
+				{callAllow, true},
+				{callAllow, false},
+			},
+		},
+		{
+			name:      "successful trial closes the circuit",
+			threshold: 1,
+			cooldown:  0,
+			steps: []breakerStep{
+				{callFailure, true},
+				{callAllow, true},
+				{callSuccess, false},
+				{callAllow, true},
+				{callAllow, true},
+			},
+		},
+		{
+			name:      "released trial lets the next request through",
+			threshold: 1,
+			cooldown:  0,
+			steps: []breakerStep{
+				{callFailure, true},
+				{callAllow, true},
+				{callRelease, false},
+				{callAllow, true},
+				{callFailure, false},
+				{callAllow, true},
+			},
+		},
+	})
+}
+
+func TestClientBreakerPerHost(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	c := NewClient(&Config{
+		MaxRetries:       -1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  3600,
+		Logger:           zap.NewNop(),
+	})
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{name: "failing host", url: failing.URL, wantErr: ErrUnavailable},
+		{name: "open circuit", url: failing.URL, wantErr: ErrCircuitOpen},
+		{name: "other host", url: healthy.URL, wantErr: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := c.Get(context.Background(), tt.url, nil)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int64
+		maxRetries   int
+		wantErr      error
+		wantAttempts int64
+	}{
+		{name: "success", wantAttempts: 1},
+		{name: "retried until success", failures: 2, maxRetries: 2, wantAttempts: 3},
+		{name: "retries exhausted", failures: 3, maxRetries: 2, wantErr: ErrUnavailable, wantAttempts: 3},
+		{name: "retries disabled", failures: 1, maxRetries: -1, wantErr: ErrUnavailable, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := &atomic.Int64{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if attempts.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			c := NewClient(&Config{MaxRetries: tt.maxRetries, RetryBackoff: 1, Logger: zap.NewNop()})
+			resp, err := c.Get(context.Background(), server.URL, nil)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestClientInvalidRequest(t *testing.T) {
+	attempts := &atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewClient(&Config{BreakerThreshold: 1, BreakerCooldown: 3600, Logger: zap.NewNop()})
+
+	// The request that can't be created is neither retried nor counted as a failure of the upstream
+	_, err := c.Get(context.Background(), server.URL+"/%zz", nil)
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Get() error = %v, want the request error", err)
+	}
+	if got := attempts.Load(); got != 0 {
+		t.Errorf("attempts = %d, want 0", got)
+	}
+	if got := len(c.breakers); got != 0 {
+		t.Errorf("breakers = %d, want 0", got)
+	}
+
+	resp, err := c.Get(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"go.uber.org/zap"
+)
+
+const (
+	defaultQueueSize  = 256
+	defaultWorkers    = 2
+	defaultMaxRetries = 5
+	defaultTimeout    = 5
+	defaultDebounce   = 5
+)
+
+type Config struct {
+	// URL is the URL the events are posted to
+	URL string
+
+	// Secret is the key of the HMAC-SHA256 signature of the payloads
+	Secret string
+
+	// Events is the list of the event types that are sent, empty means all
+	Events []string
+
+	// QueueSize is the number of the events waiting for the delivery, new events are dropped when it is full
+	QueueSize int
+
+	// Workers is the number of the concurrent deliveries
+	Workers int
+
+	// MaxRetries is the number of the delivery retries after the first attempt
+	MaxRetries int
+
+	// Timeout is the time allowed for a delivery attempt in seconds
+	Timeout int64
+
+	// Debounce is the time the scene updates of a board are collected into a single event in seconds
+	Debounce int64
+
+	Logger *zap.Logger
+}
+
+// withDefaults returns a copy of the config with zero values replaced by defaults.
+func (c Config) withDefaults() Config {
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Debounce <= 0 {
+		c.Debounce = defaultDebounce
+	}
+	return c
+}
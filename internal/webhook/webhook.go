@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+// Headers of the webhook requests.
+const (
+	HeaderEvent     = "X-Excaliroom-Event"
+	HeaderDelivery  = "X-Excaliroom-Delivery"
+	HeaderTimestamp = "X-Excaliroom-Timestamp"
+	HeaderSignature = "X-Excaliroom-Signature"
+)
+
+const (
+	// initialBackoff is the delay before the first retry, it doubles with every retry
+	initialBackoff = time.Second
+
+	// maxBackoff is the maximum delay between the retries
+	maxBackoff = time.Minute
+)
+
+var errUnexpectedStatus = errors.New("unexpected status code")
+
+// Dispatcher posts the room events to the webhook URL. The events are queued and delivered
+// in the background, so Notify never blocks the caller.
+type Dispatcher struct {
+	url    string
+	secret string
+
+	// events is a set of the event types that are sent, nil means all
+	events map[string]bool
+
+	queue      chan models.RoomEvent
+	maxRetries int
+	debounce   time.Duration
+	client     *http.Client
+
+	// pending is a map of the last scene update by board waiting for the debounce timer
+	pending map[string]*models.RoomEvent
+
+	// mtx guards pending and closed
+	mtx    *sync.Mutex
+	closed bool
+
+	// dropped is the number of the events dropped because the queue was full
+	dropped *atomic.Uint64
+
+	// done is closed when the dispatcher stops, it interrupts the backoff delays
+	done    chan struct{}
+	workers *sync.WaitGroup
+
+	logger *zap.Logger
+}
+
+func NewDispatcher(config *Config) *Dispatcher {
+	cfg := config.withDefaults()
+
+	var events map[string]bool
+	if len(cfg.Events) > 0 {
+		events = make(map[string]bool, len(cfg.Events))
+		for _, event := range cfg.Events {
+			events[event] = true
+		}
+	}
+
+	d := &Dispatcher{
+		url:        cfg.URL,
+		secret:     cfg.Secret,
+		events:     events,
+		queue:      make(chan models.RoomEvent, cfg.QueueSize),
+		maxRetries: cfg.MaxRetries,
+		debounce:   time.Duration(cfg.Debounce) * time.Second,
+		client:     &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		pending:    make(map[string]*models.RoomEvent),
+		mtx:        &sync.Mutex{},
+		dropped:    &atomic.Uint64{},
+		done:       make(chan struct{}),
+		workers:    &sync.WaitGroup{},
+		logger:     cfg.Logger,
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Notify queues the event for the delivery. The scene updates of a board are debounced:
+// only the last one within the debounce interval is sent.
+func (d *Dispatcher) Notify(event models.RoomEvent) {
+	if d.events != nil && !d.events[event.Type] {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.closed {
+		return
+	}
+
+	if event.Type != models.RoomEventSceneUpdated {
+		d.enqueue(event)
+		return
+	}
+
+	_, scheduled := d.pending[event.BoardID]
+	d.pending[event.BoardID] = &event
+	if !scheduled {
+		time.AfterFunc(d.debounce, func() {
+			d.mtx.Lock()
+			defer d.mtx.Unlock()
+			if last := d.pending[event.BoardID]; last != nil && !d.closed {
+				d.enqueue(*last)
+			}
+			delete(d.pending, event.BoardID)
+		})
+	}
+}
+
+// enqueue adds the event to the queue or drops it if the queue is full, the mutex must be held.
+func (d *Dispatcher) enqueue(event models.RoomEvent) {
+	select {
+	case d.queue <- event:
+	default:
+		dropped := d.dropped.Add(1)
+		d.logger.Warn(
+			"Webhook queue is full, event dropped",
+			zap.String("event", event.Type),
+			zap.String("boardID", event.BoardID),
+			zap.Uint64("droppedTotal", dropped),
+		)
+	}
+}
+
+// Close sends the debounced and the queued events and stops the dispatcher.
+// The deliveries that are not finished when the context is done are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mtx.Lock()
+	if d.closed {
+		d.mtx.Unlock()
+		return nil
+	}
+	for _, event := range d.pending {
+		d.enqueue(*event)
+	}
+	d.pending = make(map[string]*models.RoomEvent)
+	d.closed = true
+	close(d.queue)
+	d.mtx.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		close(d.done)
+		return fmt.Errorf("failed to deliver webhooks: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for event := range d.queue {
+		d.deliver(event)
+	}
+}
+
+// deliver posts the event, retrying with the exponential backoff on the network errors,
+// the server errors and 429 Too Many Requests.
+func (d *Dispatcher) deliver(event models.RoomEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Failed to encode webhook", zap.Error(err))
+		return
+	}
+
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(event, body)
+		if err == nil {
+			d.logger.Debug("Webhook delivered", zap.String("event", event.Type), zap.String("id", event.ID))
+			return
+		}
+		if !retry || attempt >= d.maxRetries {
+			d.logger.Error(
+				"Failed to deliver webhook",
+				zap.String("event", event.Type),
+				zap.String("id", event.ID),
+				zap.Int("attempts", attempt+1),
+				zap.Error(err),
+			)
+			return
+		}
+
+		// The jitter spreads the retries of the events failed at the same time
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(delay):
+		case <-d.done:
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post sends the signed request and reports whether it can be retried on failure.
+func (d *Dispatcher) post(event models.RoomEvent, body []byte) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if d.secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(d.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return true, fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	default:
+		return false, fmt.Errorf("%w: %d", errUnexpectedStatus, resp.StatusCode)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and the body joined with a dot.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Icerzack/excaliroom/internal/models"
+)
+
+const (
+	testSecret    = "secret"
+	testTimestamp = "1700000000"
+	testBody      = `{"type":"roomCreated"}`
+	testBoardID   = "board-1"
+
+	// testTimeout is the time the tests wait for the deliveries
+	testTimeout = 5 * time.Second
+)
+
+// delivery is the webhook request received by the test receiver.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// testReceiver records the webhook requests and responds with the status.
+type testReceiver struct {
+	server *httptest.Server
+	status int
+
+	mtx        *sync.Mutex
+	deliveries []delivery
+}
+
+func newTestReceiver(t *testing.T, status int) *testReceiver {
+	t.Helper()
+	r := &testReceiver{status: status, mtx: &sync.Mutex{}}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mtx.Lock()
+		r.deliveries = append(r.deliveries, delivery{header: req.Header.Clone(), body: body})
+		r.mtx.Unlock()
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// received returns the requests received so far.
+func (r *testReceiver) received() []delivery {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+// newTestDispatcher creates the dispatcher posting to the receiver.
+func newTestDispatcher(receiver *testReceiver, cfg Config) *Dispatcher {
+	cfg.URL = receiver.server.URL
+	cfg.Logger = zap.NewNop()
+	return NewDispatcher(&cfg)
+}
+
+// closeDispatcher delivers the pending events and stops the dispatcher.
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close() unexpected error: %v", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			name:      "event",
+			secret:    testSecret,
+			timestamp: testTimestamp,
+			body:      testBody,
+			want:      "d4e43fbba3470f29427e858918989ba4d51fcf1b9e5b85334d58d25f9fcc3cb7",
+		},
+		{
+			name:      "other timestamp",
+			secret:    testSecret,
+			timestamp: "1700000001",
+			body:      testBody,
+			want:      "70e457175a6d839dff80a16da27eede254c783fa7aad872d54967c8d053bd11b",
+		},
+		{
+			name:      "other secret",
+			secret:    "another-secret",
+			timestamp: testTimestamp,
+			body:      testBody,
+			want:      "9df737602149ac652fb2689cba53b330a37315b272233a69bfa873c3cdb633ba",
+		},
+		{
+			name:      "empty",
+			secret:    "",
+			timestamp: "0",
+			body:      "",
+			want:      "b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusNoContent)
+	d := newTestDispatcher(receiver, Config{Secret: testSecret})
+
+	event := models.NewRoomEvent(models.RoomEventRoomCreated, models.NewRoom(testBoardID), "")
+	d.Notify(event)
+	closeDispatcher(t, d)
+
+	deliveries := receiver.received()
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	header, body := deliveries[0].header, deliveries[0].body
+	if header.Get(HeaderEvent) != event.Type || header.Get(HeaderDelivery) != event.ID {
+		t.Errorf("event %s with delivery %s, want %s with %s",
+			header.Get(HeaderEvent), header.Get(HeaderDelivery), event.Type, event.ID)
+	}
+	if want := "sha256=" + Sign(testSecret, header.Get(HeaderTimestamp), body); header.Get(HeaderSignature) != want {
+		t.Errorf("signature %s, want %s", header.Get(HeaderSignature), want)
+	}
+	var received models.RoomEvent
+	if err := json.Unmarshal(body, &received); err != nil || received.BoardID != testBoardID {
+		t.Errorf("body %s, want the event of the board %s", body, testBoardID)
+	}
+}
+
+func TestDispatcherFiltersEvents(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusOK)
+	d := newTestDispatcher(receiver, Config{Events: []string{models.RoomEventRoomCreated}})
+
+	room := models.NewRoom(testBoardID)
+	d.Notify(models.NewRoomEvent(models.RoomEventUserJoined, room, "user-1"))
+	d.Notify(models.NewRoomEvent(models.RoomEventRoomCreated, room, ""))
+	closeDispatcher(t, d)
+
+	deliveries := receiver.received()
+	if len(deliveries) != 1 || deliveries[0].header.Get(HeaderEvent) != models.RoomEventRoomCreated {
+		t.Errorf("got %d deliveries, want only the %s event", len(deliveries), models.RoomEventRoomCreated)
+	}
+}
+
+func TestDispatcherDebouncesSceneUpdates(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusOK)
+	d := newTestDispatcher(receiver, Config{Debounce: 60})
+
+	// Only the last update is sent, Close delivers it without waiting for the debounce timer
+	room := models.NewRoom(testBoardID)
+	for _, elements := range []string{`[]`, `[{}]`, `[{},{}]`} {
+		d.Notify(models.NewSceneUpdatedEvent(room, "user-1", elements, ""))
+	}
+	closeDispatcher(t, d)
+
+	deliveries := receiver.received()
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	var received models.RoomEvent
+	if err := json.Unmarshal(deliveries[0].body, &received); err != nil || received.SceneSize != len(`[{},{}]`) {
+		t.Errorf("body %s, want the last scene update", deliveries[0].body)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantAttempts int
+	}{
+		{name: "server error", status: http.StatusInternalServerError, wantAttempts: 2},
+		{name: "too many requests", status: http.StatusTooManyRequests, wantAttempts: 2},
+		{name: "client error", status: http.StatusBadRequest, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newTestReceiver(t, tt.status)
+			d := newTestDispatcher(receiver, Config{MaxRetries: 1})
+
+			d.Notify(models.NewRoomEvent(models.RoomEventRoomCreated, models.NewRoom(testBoardID), ""))
+			closeDispatcher(t, d)
+
+			if got := len(receiver.received()); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
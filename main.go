@@ -40,21 +40,114 @@ func main() {
 		return
 	}
 
-	restApp := rest.NewRest(&rest.Config{
+	restApp := rest.NewRest(newRestConfig(appConfig, logger))
+
+	appsManager := cmd.NewAppsManager(logger)
+
+	appsManager.Register(cmd.RestApp, restApp)
+	appsManager.RunAll()
+	appsManager.WaitForShutdown()
+}
+
+// newRestConfig returns the config of the REST app.
+func newRestConfig(appConfig *cmd.Config, logger *zap.Logger) *rest.Config {
+	cfg := &rest.Config{
 		Port:               appConfig.Apps.Rest.Port,
 		JwtValidationURL:   appConfig.Apps.Rest.Validation.JWTValidationURL,
 		JwtHeaderName:      appConfig.Apps.Rest.Validation.JWTHeaderName,
 		BoardValidationURL: appConfig.Apps.Rest.Validation.BoardValidationURL,
-		UsersStorageType:   appConfig.Storage.Users.Type,
-		RoomsStorageType:   appConfig.Storage.Rooms.Type,
+		AllowedOrigins:     appConfig.Apps.Rest.AllowedOrigins,
 		CacheType:          appConfig.Cache.Type,
 		CacheTTL:           appConfig.Cache.TTL,
 		Logger:             logger,
-	})
 
-	appsManager := cmd.NewAppsManager(logger)
+		ValidationTimeout:          appConfig.Apps.Rest.Validation.Timeout,
+		ValidationDeadline:         appConfig.Apps.Rest.Validation.Deadline,
+		ValidationMaxIdleConns:     appConfig.Apps.Rest.Validation.MaxIdleConns,
+		ValidationMaxRetries:       appConfig.Apps.Rest.Validation.MaxRetries,
+		ValidationRetryBackoff:     appConfig.Apps.Rest.Validation.RetryBackoff,
+		ValidationBreakerThreshold: appConfig.Apps.Rest.Validation.CircuitBreaker.Threshold,
+		ValidationBreakerCooldown:  appConfig.Apps.Rest.Validation.CircuitBreaker.Cooldown,
+		ValidationFallback:         appConfig.Apps.Rest.Validation.Fallback,
+		ValidationFallbackTTL:      appConfig.Apps.Rest.Validation.FallbackTTL,
 
-	appsManager.Register(cmd.RestApp, restApp)
-	appsManager.RunAll()
-	appsManager.WaitForShutdown()
+		SocketIOEnabled:        appConfig.Apps.Rest.SocketIO.Enabled,
+		SocketIOAllowAnonymous: appConfig.Apps.Rest.SocketIO.AllowAnonymous,
+		SocketIOPingInterval:   appConfig.Apps.Rest.SocketIO.PingInterval,
+		SocketIOPingTimeout:    appConfig.Apps.Rest.SocketIO.PingTimeout,
+
+		WebhookURL:        appConfig.Apps.Rest.Webhooks.URL,
+		WebhookSecret:     appConfig.Apps.Rest.Webhooks.Secret,
+		WebhookEvents:     appConfig.Apps.Rest.Webhooks.Events,
+		WebhookQueueSize:  appConfig.Apps.Rest.Webhooks.QueueSize,
+		WebhookWorkers:    appConfig.Apps.Rest.Webhooks.Workers,
+		WebhookMaxRetries: appConfig.Apps.Rest.Webhooks.MaxRetries,
+		WebhookTimeout:    appConfig.Apps.Rest.Webhooks.Timeout,
+		WebhookDebounce:   appConfig.Apps.Rest.Webhooks.Debounce,
+
+		AdminToken: appConfig.Apps.Rest.Admin.Token,
+
+		EventsToken:      appConfig.Apps.Rest.Events.Token,
+		EventsBufferSize: appConfig.Apps.Rest.Events.BufferSize,
+		EventsKeepAlive:  appConfig.Apps.Rest.Events.KeepAlive,
+
+		ShutdownTimeout:        appConfig.Apps.Rest.Shutdown.Timeout,
+		ShutdownReconnectAfter: appConfig.Apps.Rest.Shutdown.ReconnectAfter,
+
+		CacheAccessTTL:          appConfig.Cache.AccessTTL,
+		CacheNegativeTTL:        appConfig.Cache.NegativeTTL,
+		CacheMaxEntries:         appConfig.Cache.MaxEntries,
+		CacheNegativeMaxEntries: appConfig.Cache.NegativeMaxEntries,
+		CacheFallbackMaxEntries: appConfig.Cache.FallbackMaxEntries,
+		CacheCleanupInterval:    appConfig.Cache.CleanupInterval,
+	}
+	setWebSocketConfig(cfg, appConfig)
+	setStorageConfig(cfg, appConfig)
+	return cfg
+}
+
+// setWebSocketConfig sets the websocket settings of the REST app config.
+func setWebSocketConfig(cfg *rest.Config, appConfig *cmd.Config) {
+	rateLimits := make(map[string]rest.RateLimit, len(appConfig.Apps.Rest.WebSocket.RateLimits))
+	for event, limit := range appConfig.Apps.Rest.WebSocket.RateLimits {
+		rateLimits[event] = rest.RateLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+
+	cfg.MessageQueueSize = appConfig.Apps.Rest.WebSocket.MessageQueueSize
+	cfg.MaxConcurrentHandlers = appConfig.Apps.Rest.WebSocket.MaxConcurrentHandlers
+	cfg.PingInterval = appConfig.Apps.Rest.WebSocket.PingInterval
+	cfg.PongWait = appConfig.Apps.Rest.WebSocket.PongWait
+	cfg.WriteTimeout = appConfig.Apps.Rest.WebSocket.WriteTimeout
+	cfg.MaxMessageSize = appConfig.Apps.Rest.WebSocket.MaxMessageSize
+	cfg.MaxSceneSize = appConfig.Apps.Rest.WebSocket.MaxSceneSize
+	cfg.MaxElements = appConfig.Apps.Rest.WebSocket.MaxElements
+	cfg.MaxTextLength = appConfig.Apps.Rest.WebSocket.MaxTextLength
+	cfg.RateLimits = rateLimits
+	cfg.MaxViolations = appConfig.Apps.Rest.WebSocket.MaxViolations
+	cfg.ViolationWindow = appConfig.Apps.Rest.WebSocket.ViolationWindow
+	cfg.EnableCompression = appConfig.Apps.Rest.WebSocket.Compression.Enabled
+	cfg.CompressionLevel = appConfig.Apps.Rest.WebSocket.Compression.Level
+	cfg.CompressionThreshold = appConfig.Apps.Rest.WebSocket.Compression.Threshold
+	cfg.KeepEncryptedScenes = appConfig.Apps.Rest.WebSocket.KeepEncryptedScenes
+	cfg.MaxRoomUsers = appConfig.Apps.Rest.WebSocket.MaxRoomUsers
+	cfg.RoomOverflow = appConfig.Apps.Rest.WebSocket.RoomOverflow
+	cfg.ResumeGracePeriod = appConfig.Apps.Rest.WebSocket.Resume.GracePeriod
+	cfg.ResumeHistorySize = appConfig.Apps.Rest.WebSocket.Resume.HistorySize
+	cfg.ChatHistorySize = appConfig.Apps.Rest.WebSocket.Chat.HistorySize
+	cfg.MaxChatMessageLength = appConfig.Apps.Rest.WebSocket.Chat.MaxMessageLength
+}
+
+// setStorageConfig sets the storages settings of the REST app config.
+func setStorageConfig(cfg *rest.Config, appConfig *cmd.Config) {
+	cfg.UsersStorageType = appConfig.Storage.Users.Type
+	cfg.RoomsStorageType = appConfig.Storage.Rooms.Type
+	cfg.FilesStorageType = appConfig.Storage.Files.Type
+	cfg.FilesStoragePath = appConfig.Storage.Files.Path
+	cfg.MaxFileSize = appConfig.Storage.Files.MaxFileSize
+	cfg.MaxBoardFilesSize = appConfig.Storage.Files.MaxBoardSize
+	cfg.MaxFilesSize = appConfig.Storage.Files.MaxTotalSize
+	cfg.FilesTTL = appConfig.Storage.Files.TTL
+	cfg.SnapshotsStorageType = appConfig.Storage.Snapshots.Type
+	cfg.SnapshotsStoragePath = appConfig.Storage.Snapshots.Path
+	cfg.MaxScenesSize = appConfig.Storage.Rooms.MaxScenesSize
 }